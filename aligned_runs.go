@@ -0,0 +1,156 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// The strategies by which loadTestRuns can choose runs.
+const (
+	// StrategyExact is used for runs at an explicitly requested SHA, or requested by ID.
+	StrategyExact = "exact"
+	// StrategyLatest is used for the latest runs of each browser (which may be for different revisions).
+	StrategyLatest = "latest"
+	// StrategyComplete is used for the runs at the latest revision which has runs for all browsers.
+	StrategyComplete = "complete"
+	// StrategyAligned is used for the run of each browser which is closest in time to an anchor time
+	// (see getAlignedRuns).
+	StrategyAligned = "aligned"
+)
+
+// runsStrategyHeader is the response header which states the strategy used to choose the runs.
+const runsStrategyHeader = "X-WPTD-Runs-Strategy"
+
+// runsSpreadHeader is the response header which states the spread, in seconds, between the earliest and the latest
+// of the chosen runs.
+const runsSpreadHeader = "X-WPTD-Runs-Spread"
+
+// setRunsStrategyHeaders describes how the given runs were chosen, in the response headers.
+func setRunsStrategyHeaders(w http.ResponseWriter, strategy string, testRuns []TestRun) {
+	w.Header().Set(runsStrategyHeader, strategy)
+	w.Header().Set(runsSpreadHeader, strconv.FormatInt(int64(getRunsSpread(testRuns)/time.Second), 10))
+}
+
+// getRunsSpread returns the time between the earliest and the latest of the given runs.
+func getRunsSpread(testRuns []TestRun) time.Duration {
+	if len(testRuns) == 0 {
+		return 0
+	}
+	earliest, latest := testRuns[0].CreatedAt, testRuns[0].CreatedAt
+	for _, run := range testRuns[1:] {
+		if run.CreatedAt.Before(earliest) {
+			earliest = run.CreatedAt
+		}
+		if run.CreatedAt.After(latest) {
+			latest = run.CreatedAt
+		}
+	}
+	return latest.Sub(earliest)
+}
+
+// getAlignedRuns returns, for each of the given browsers, the run which is closest in time to the anchor.
+// A zero anchor defaults to the oldest of the browsers' latest runs, so that the browser which was run least
+// recently uses its latest run, and the others are aligned with it. Browsers without any runs are omitted.
+func getAlignedRuns(ctx context.Context, browserNames []string, anchor time.Time) ([]TestRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, datastoreQueryTimeout)
+	defer cancel()
+
+	if anchor.IsZero() {
+		var err error
+		if anchor, err = getDefaultAlignmentAnchor(ctx, browserNames); err != nil || anchor.IsZero() {
+			return nil, err
+		}
+	}
+
+	aligned := make([]TestRun, len(browserNames))
+	err := runConcurrently(ctx, len(browserNames), maxConcurrentQueries, func(ctx context.Context, i int) error {
+		query := datastore.NewQuery("TestRun").Filter("BrowserName =", browserNames[i])
+		before, err := getFirstTestRun(ctx, query.Filter("CreatedAt <=", anchor).Order("-CreatedAt"), isDefaultRun)
+		if err != nil {
+			return err
+		}
+		after, err := getFirstTestRun(ctx, query.Filter("CreatedAt >", anchor).Order("CreatedAt"), isDefaultRun)
+		if err != nil {
+			return err
+		}
+		if closest := getClosestRun(anchor, before, after); closest != nil {
+			aligned[i] = *closest
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var testRuns []TestRun
+	for _, run := range aligned {
+		if run.ID != 0 {
+			testRuns = append(testRuns, run)
+		}
+	}
+	return testRuns, nil
+}
+
+// getDefaultAlignmentAnchor returns the time of the oldest of the given browsers' latest runs.
+func getDefaultAlignmentAnchor(ctx context.Context, browserNames []string) (anchor time.Time, err error) {
+	latest := make([]*TestRun, len(browserNames))
+	err = runConcurrently(ctx, len(browserNames), maxConcurrentQueries, func(ctx context.Context, i int) (err error) {
+		query := datastore.
+			NewQuery("TestRun").
+			Filter("BrowserName =", browserNames[i]).
+			Order("-CreatedAt")
+		latest[i], err = getFirstTestRun(ctx, query, isDefaultRun)
+		return err
+	})
+	if err != nil {
+		return anchor, err
+	}
+	for _, run := range latest {
+		if run != nil && (anchor.IsZero() || run.CreatedAt.Before(anchor)) {
+			anchor = run.CreatedAt
+		}
+	}
+	return anchor, nil
+}
+
+// getFirstTestRun returns the first TestRun matching the (unlimited) query for which include returns true (see
+// getTestRuns), or nil if there are none.
+func getFirstTestRun(ctx context.Context, query *datastore.Query, include func(TestRun) bool) (*TestRun, error) {
+	testRuns, err := getTestRuns(ctx, query, 1, include)
+	if err != nil || len(testRuns) == 0 {
+		return nil, err
+	}
+	return &testRuns[0], nil
+}
+
+// getClosestRun returns whichever of the (possibly nil) runs was created closest to the anchor time.
+func getClosestRun(anchor time.Time, before *TestRun, after *TestRun) *TestRun {
+	if before == nil || after == nil {
+		if before == nil {
+			return after
+		}
+		return before
+	}
+	if anchor.Sub(before.CreatedAt) <= after.CreatedAt.Sub(anchor) {
+		return before
+	}
+	return after
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var alignmentAnchor = time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestGetClosestRun(t *testing.T) {
+	before := &TestRun{ID: 1, CreatedAt: alignmentAnchor.Add(-time.Hour)}
+	after := &TestRun{ID: 2, CreatedAt: alignmentAnchor.Add(2 * time.Hour)}
+	assert.Equal(t, before, getClosestRun(alignmentAnchor, before, after))
+
+	after.CreatedAt = alignmentAnchor.Add(30 * time.Minute)
+	assert.Equal(t, after, getClosestRun(alignmentAnchor, before, after))
+
+	assert.Equal(t, before, getClosestRun(alignmentAnchor, before, nil))
+	assert.Equal(t, after, getClosestRun(alignmentAnchor, nil, after))
+	assert.Nil(t, getClosestRun(alignmentAnchor, nil, nil))
+}
+
+func TestGetRunsSpread(t *testing.T) {
+	assert.Equal(t, time.Duration(0), getRunsSpread(nil))
+	assert.Equal(t, 3*time.Hour, getRunsSpread([]TestRun{
+		{CreatedAt: alignmentAnchor},
+		{CreatedAt: alignmentAnchor.Add(2 * time.Hour)},
+		{CreatedAt: alignmentAnchor.Add(-time.Hour)},
+	}))
+}
+
+func TestSetRunsStrategyHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	setRunsStrategyHeaders(w, StrategyAligned, []TestRun{
+		{CreatedAt: alignmentAnchor},
+		{CreatedAt: alignmentAnchor.Add(90 * time.Second)},
+	})
+	assert.Equal(t, StrategyAligned, w.Header().Get(runsStrategyHeader))
+	assert.Equal(t, "90", w.Header().Get(runsSpreadHeader))
+}
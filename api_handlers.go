@@ -32,62 +32,161 @@ import (
 //
 // URL Params:
 //     sha: SHA[0:10] of the repo when the tests were executed (or 'latest')
+//     complete: (optional) When 'latest', use the latest SHA with runs for all browsers, falling back to
+//         aligned runs when there isn't one
+//     aligned: (optional) When 'latest', use the run of each browser closest in time to the anchor
+//     anchor: (optional) RFC 3339 time to align runs with (default: the oldest of the browsers' latest runs)
+//     browser(s): (optional) Browser names to include (see ParseBrowsersParam)
+//     max-count: (optional) Maximum number of runs per browser
+//     partial: (optional) Include partial runs, i.e. runs of only some of the tests (see TestRun.Paths)
+//     status: (optional) Comma-separated statuses of the runs to include (see RunStatuses), or 'all'; only
+//         complete runs by default
+//     run_ids: (optional) Comma-separated IDs of specific runs to emit, instead of any of the above
+//     sort: (optional) 'disruption' to order the runs by how many tests changed since the previous run
 func apiTestRunsHandler(w http.ResponseWriter, r *http.Request) {
-	runSHA, err := ParseSHAParam(r)
+	selection, err := ParseTestRunSelection(r)
 	if err != nil {
-		http.Error(w, "Invalid query params", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	ctx := appengine.NewContext(r)
-	// When ?complete=true, make sure to show results for the same complete run (executed for all browsers).
-	if complete, err := strconv.ParseBool(r.URL.Query().Get("complete")); err == nil && complete {
-		if runSHA == "latest" {
-			runSHA, err = getLastCompleteRunSHA(ctx)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
+	testRuns, strategy, err := loadTestRuns(ctx, selection)
+	if _, ok := err.(testRunNotFoundError); ok {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	var browserNames []string
-	if browserNames, err = ParseBrowsersParam(r); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	setRunsStrategyHeaders(w, strategy, testRuns)
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "disruption" {
+		sortRunsByDisruption(testRuns)
+	} else if sortBy != "" {
+		http.Error(w, "Invalid 'sort' param: "+sortBy, http.StatusBadRequest)
+		return
+	}
+	// Never pinned, since artifacts can be attached to the runs (see pinnedCacheControl).
+	if checkETag(w, r, computeRunsETag(testRuns, "sort="+sortBy), false) {
 		return
 	}
 
-	var testRuns []TestRun
-	var limit int
-	if limit, err = ParseMaxCountParam(r); err != nil {
-		http.Error(w, "Invalid 'max-count' param: "+err.Error(), http.StatusBadRequest)
+	testRunsBytes, err := json.Marshal(testRuns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	w.Write(testRunsBytes)
+}
+
+// testRunNotFoundError is returned when a TestRun requested by ID doesn't exist.
+type testRunNotFoundError int64
+
+func (id testRunNotFoundError) Error() string {
+	return fmt.Sprintf("test run %d not found", int64(id))
+}
+
+// loadTestRuns loads the TestRuns for the given selection, in browser order, along with the strategy used to
+// choose them (see StrategyExact etc).
+func loadTestRuns(ctx context.Context, selection TestRunSelection) (testRuns []TestRun, strategy string, err error) {
+	if len(selection.IDs) > 0 {
+		testRuns, err = loadTestRunsByID(ctx, selection.IDs)
+		return testRuns, StrategyExact, err
+	}
+
+	runSHA := selection.SHA
+	strategy = StrategyExact
+	if runSHA == "latest" {
+		strategy = StrategyLatest
+		if selection.Aligned {
+			testRuns, err = getAlignedRuns(ctx, selection.BrowserNames, selection.Anchor)
+			return testRuns, StrategyAligned, err
+		}
+		// When ?complete=true, make sure to show results for the same complete run (executed for all browsers).
+		if selection.Complete {
+			if runSHA, err = getLastCompleteRunSHA(ctx); err != nil {
+				return nil, strategy, err
+			}
+			// When no revision is complete, align the runs instead of mixing arbitrary revisions.
+			if runSHA == "latest" {
+				testRuns, err = getAlignedRuns(ctx, selection.BrowserNames, selection.Anchor)
+				return testRuns, StrategyAligned, err
+			}
+			strategy = StrategyComplete
+		}
+	}
+
 	baseQuery := datastore.
 		NewQuery("TestRun").
-		Order("-CreatedAt").
-		Limit(limit)
-
-	for _, browserName := range browserNames {
-		var testRunResults []TestRun
-		query := baseQuery.Filter("BrowserName =", browserName)
+		Order("-CreatedAt")
+	include := getRunsFilter(selection.Statuses, selection.Partial)
+
+	// Query each browser concurrently, keeping the results in browser order.
+	browserNames := selection.BrowserNames
+	testRunsByBrowser := make([][]TestRun, len(browserNames))
+	ctx, cancel := context.WithTimeout(ctx, datastoreQueryTimeout)
+	defer cancel()
+	err = runConcurrently(ctx, len(browserNames), maxConcurrentQueries, func(ctx context.Context, i int) (err error) {
+		query := baseQuery.Filter("BrowserName =", browserNames[i])
 		if runSHA != "" && runSHA != "latest" {
 			query = query.Filter("Revision =", runSHA)
 		}
-		if _, err := query.GetAll(ctx, &testRunResults); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		testRunsByBrowser[i], err = getTestRuns(ctx, query, selection.MaxCount, include)
+		return err
+	})
+	if err != nil {
+		return nil, strategy, err
+	}
+	for _, browserTestRuns := range testRunsByBrowser {
+		testRuns = append(testRuns, browserTestRuns...)
+	}
+	return testRuns, strategy, nil
+}
+
+// loadTestRunsByID loads the TestRuns with the given IDs, in the same order.
+func loadTestRunsByID(ctx context.Context, ids []int64) ([]TestRun, error) {
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = datastore.NewKey(ctx, "TestRun", "", id, nil)
+	}
+	testRuns := make([]TestRun, len(ids))
+	if err := datastore.GetMulti(ctx, keys, testRuns); err != nil {
+		if multiErr, ok := err.(appengine.MultiError); ok {
+			for i, err := range multiErr {
+				if err == datastore.ErrNoSuchEntity {
+					return nil, testRunNotFoundError(ids[i])
+				}
+			}
 		}
-		testRuns = append(testRuns, testRunResults...)
+		return nil, err
 	}
+	setTestRunIDs(keys, testRuns)
+	return testRuns, nil
+}
 
-	testRunsBytes, err := json.Marshal(testRuns)
+// loadRunByIDParam loads the TestRun with the ID given by the 'run_id' param. If it's invalid or there's no such
+// run, an error response is written and false is returned.
+func loadRunByIDParam(ctx context.Context, w http.ResponseWriter, r *http.Request) (TestRun, bool) {
+	ids, err := ParseRunIDsParam(r)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return TestRun{}, false
+	} else if len(ids) != 1 {
+		http.Error(w, "Exactly one 'run_id' param is required", http.StatusBadRequest)
+		return TestRun{}, false
+	}
+	testRuns, err := loadTestRunsByID(ctx, ids)
+	if _, ok := err.(testRunNotFoundError); ok {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return TestRun{}, false
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return TestRun{}, false
 	}
-
-	w.Write(testRunsBytes)
+	return testRuns[0], true
 }
 
 func apiTestRunHandler(w http.ResponseWriter, r *http.Request) {
@@ -106,6 +205,8 @@ func apiTestRunHandler(w http.ResponseWriter, r *http.Request) {
 // URL Params:
 //     sha: SHA[0:10] of the repo when the test was executed (or 'latest')
 //     browser: Browser for the run (e.g. 'chrome', 'safari-10')
+//     partial: (optional) Include partial runs (see TestRun.Paths)
+//     status: (optional) As for /api/runs
 func apiTestRunGetHandler(w http.ResponseWriter, r *http.Request) {
 	runSHA, err := ParseSHAParam(r)
 	if err != nil {
@@ -128,14 +229,19 @@ func apiTestRunGetHandler(w http.ResponseWriter, r *http.Request) {
 	query := datastore.
 		NewQuery("TestRun").
 		Order("-CreatedAt").
-		Limit(1).
 		Filter("BrowserName =", browserName)
 	if runSHA != "" && runSHA != "latest" {
 		query = query.Filter("Revision =", runSHA)
 	}
+	statuses, err := ParseStatusParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	include := getRunsFilter(statuses, ParseBooleanParam(r, "partial"))
 
-	var testRuns []TestRun
-	if _, err := query.GetAll(ctx, &testRuns); err != nil {
+	testRuns, err := getTestRuns(ctx, query, 1, include)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -145,6 +251,10 @@ func apiTestRunGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if checkETag(w, r, computeRunsETag(testRuns), false) {
+		return
+	}
+
 	testRunsBytes, err := json.Marshal(testRuns[0])
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -156,22 +266,13 @@ func apiTestRunGetHandler(w http.ResponseWriter, r *http.Request) {
 
 // apiTestRunPostHandler is responsible for handling TestRun submissions (via HTTP POST requests).
 // It asserts the presence of a required secret token, then saves the JSON blob to the Datastore.
-// See models.go for the JSON format expected.
+// See models.go for the JSON format expected. Runs created with a pending or running status are updated through
+// /api/run/status.
 func apiTestRunPostHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := appengine.NewContext(r)
 	var err error
 
-	// Fetch pre-uploaded Token entity.
-	suppliedSecret := r.URL.Query().Get("secret")
-	tokenKey := datastore.NewKey(ctx, "Token", "upload-token", 0, nil)
-	var token Token
-	if err = datastore.Get(ctx, tokenKey, &token); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if suppliedSecret != token.Secret {
-		http.Error(w, fmt.Sprintf("Invalid token '%s'", suppliedSecret), http.StatusUnauthorized)
+	if !checkUploadToken(ctx, w, r) {
 		return
 	}
 
@@ -187,17 +288,54 @@ func apiTestRunPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Changes are computed after upload (see computeRunChanges), artifacts are uploaded separately, and archives are
+	// built on request (see apiTestRunArchiveHandler).
+	testRun.Changes = RunChanges{}
+	testRun.Artifacts = nil
+	testRun.ArchiveURL = ""
+	if testRun.Paths, err = normalizeRunPaths(testRun.Paths); err != nil {
+		http.Error(w, "Invalid 'paths': "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if testRun.Runner != "" {
+		if err = validateRunnerName(testRun.Runner); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if testRun.Status != "" && !isValidRunStatus(testRun.Status) {
+		http.Error(w, "Invalid 'status': "+testRun.Status, http.StatusBadRequest)
+		return
+	} else if testRun.Status == RunStatusComplete && testRun.ResultsURL == "" {
+		http.Error(w, errResultsURLRequired.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateRunProgress(testRun.Progress); err != nil {
+		http.Error(w, "Invalid 'progress': "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Use 'now' as created time, unless flagged as retroactive.
 	if retro, err := strconv.ParseBool(r.URL.Query().Get("retroactive")); err != nil || !retro {
 		testRun.CreatedAt = time.Now()
 	}
+	if !testRun.IsComplete() {
+		testRun.Progress.UpdatedAt = time.Now()
+	}
 
 	// Create a new TestRun out of the JSON body of the request.
 	key := datastore.NewIncompleteKey(ctx, "TestRun", nil)
-	if _, err := datastore.Put(ctx, key, &testRun); err != nil {
+	if key, err = datastore.Put(ctx, key, &testRun); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	testRun.ID = key.IntID()
+	noteRunnerSeen(ctx, testRun.Runner)
+	// In-progress runs are processed once they complete (see apiRunStatusHandler).
+	if testRun.IsComplete() {
+		processNewTestRun(ctx, testRun)
+	}
 
 	var jsonOutput []byte
 	if jsonOutput, err = json.Marshal(testRun); err != nil {
@@ -208,44 +346,60 @@ func apiTestRunPostHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
-// getLastCompleteRunSHA returns the SHA[0:10] for the most recent run that exists for all initially-loaded browser
-// names (see GetBrowserNames).
-func getLastCompleteRunSHA(ctx context.Context) (sha string, err error) {
-	baseQuery := datastore.
-		NewQuery("TestRun").
-		Order("-CreatedAt").
-		Limit(100).
-		Project("Revision")
+// setTestRunIDs copies the IDs of the given Datastore keys into the ID field of the corresponding TestRuns.
+func setTestRunIDs(keys []*datastore.Key, testRuns []TestRun) {
+	for i, key := range keys {
+		testRuns[i].ID = key.IntID()
+	}
+}
 
-	// Map is sha -> browser -> seen yet?  - this prevents over-counting dupes.
-	runSHAs := make(map[string]map[string]bool)
+// checkUploadToken asserts that the 'secret' param of the request matches the pre-uploaded upload-token entity.
+// If it does not (or the token can't be loaded), an error response is written and false is returned.
+func checkUploadToken(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	// Fetch pre-uploaded Token entity.
+	suppliedSecret := r.URL.Query().Get("secret")
+	tokenKey := datastore.NewKey(ctx, "Token", "upload-token", 0, nil)
+	var token Token
+	if err := datastore.Get(ctx, tokenKey, &token); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if suppliedSecret != token.Secret {
+		http.Error(w, fmt.Sprintf("Invalid token '%s'", suppliedSecret), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// getLastCompleteRunSHA returns the SHA[0:10] of the most recently completed revision (see Revision) which has runs
+// for all of the current initially-loaded browser names (see GetBrowserNames), or "latest" if there isn't one.
+func getLastCompleteRunSHA(ctx context.Context) (sha string, err error) {
 	var browserNames []string
 	if browserNames, err = GetBrowserNames(); err != nil {
-		return sha, err
+		return "latest", err
 	}
 
-	for _, browser := range browserNames {
-		it := baseQuery.Filter("BrowserName = ", browser).Run(ctx)
-		for {
-			var testRun TestRun
-			_, err := it.Next(&testRun)
-			if err == datastore.Done {
-				break
-			}
-			if err != nil {
-				return "latest", err
-			}
-			if _, ok := runSHAs[testRun.Revision]; !ok {
-				runSHAs[testRun.Revision] = make(map[string]bool)
-			}
-			browsersSeen := runSHAs[testRun.Revision]
-			browsersSeen[browser] = true
-			if len(browsersSeen) == len(browserNames) {
-				return testRun.Revision, nil
-			}
+	// Revisions are flagged complete for the browsers at the time; skip any missing a browser added since.
+	query := datastore.
+		NewQuery("Revision").
+		Filter("Complete =", true).
+		Order("-CompletedAt").
+		Limit(100)
+	it := query.Run(ctx)
+	for {
+		var revision Revision
+		_, err := it.Next(&revision)
+		if err == datastore.Done {
+			return "latest", nil
+		}
+		if err != nil {
+			return "latest", err
+		}
+		if revision.hasBrowsers(browserNames) {
+			return revision.SHA, nil
 		}
 	}
-	return "latest", nil
 }
 
 // apiDiffHandler takes 2 test-run results JSON blobs and produces JSON in the same format, with only the differences
@@ -253,6 +407,7 @@ func getLastCompleteRunSHA(ctx context.Context) (sha string, err error) {
 //
 // GET takes before and after params, for historical production runs.
 // POST takes only a before param, and the after state is provided in the body of the POST request.
+// Both take a metadata param, to annotate the differences with test metadata (see writeDiff).
 func apiDiffHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
@@ -279,28 +434,11 @@ func handleAPIDiffGet(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "before param missing", http.StatusBadRequest)
 		return
 	}
-	var beforeJSON map[string][]int
-	if beforeJSON, err = fetchRunResultsJSONForParam(ctx, r, specBefore); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	} else if beforeJSON == nil {
-		http.Error(w, specBefore+" not found", http.StatusNotFound)
-		return
-	}
-
 	specAfter := params.Get("after")
 	if specAfter == "" {
 		http.Error(w, "after param missing", http.StatusBadRequest)
 		return
 	}
-	var afterJSON map[string][]int
-	if afterJSON, err = fetchRunResultsJSONForParam(ctx, r, specAfter); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	} else if afterJSON == nil {
-		http.Error(w, specAfter+" not found", http.StatusNotFound)
-		return
-	}
 
 	var filter DiffFilterParam
 	if filter, err = ParseDiffFilterParam(r); err != nil {
@@ -308,17 +446,79 @@ func handleAPIDiffGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	diffJSON := getResultsDiff(beforeJSON, afterJSON, filter)
-	var bytes []byte
-	if bytes, err = json.Marshal(diffJSON); err != nil {
+	specs := make([]platformAtRevision, 2)
+	for i, spec := range []string{specBefore, specAfter} {
+		if specs[i], err = parsePlatformAtRevisionSpec(spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Resolve both runs first, so that conditional requests are answered without fetching any summaries.
+	runs := make([]TestRun, len(specs))
+	err = runConcurrently(ctx, len(specs), len(specs), func(ctx context.Context, i int) (err error) {
+		runs[i], err = fetchRunForSpec(ctx, specs[i])
+		return err
+	})
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Write(bytes)
+	for i, spec := range []string{specBefore, specAfter} {
+		if runs[i].ID == 0 {
+			http.Error(w, spec+" not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	etagExtras := []string{fmt.Sprintf("filter=%+v", filter)}
+	var metadata []TestMetadata
+	withMetadata := ParseBooleanParam(r, "metadata")
+	if withMetadata {
+		if metadata, err = loadTestMetadata(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		etagExtras = append(etagExtras, "metadata="+computeTestMetadataVersion(metadata))
+	}
+	// Specs resolve runs from revisions, so the diff is never pinned (see pinnedCacheControl).
+	if checkETag(w, r, computeRunsETag(runs, etagExtras...), false) {
+		return
+	}
+
+	writeRunsDiff(ctx, w, r, runs[0], runs[1], filter, withMetadata, metadata)
+}
+
+// writeRunsDiff fetches the summaries of both runs (concurrently), and writes their diff (see writeDiff), limited to
+// the scope shared by the runs when either is a partial run (see filterToSharedScope).
+func writeRunsDiff(ctx context.Context, w http.ResponseWriter, r *http.Request, beforeRun, afterRun TestRun,
+	filter DiffFilterParam, withMetadata bool, metadata []TestMetadata) {
+	runs := []TestRun{beforeRun, afterRun}
+	results := make([]*ResultsSummary, len(runs))
+	err := runConcurrently(ctx, len(runs), len(runs), func(ctx context.Context, i int) (err error) {
+		results[i], err = fetchRunResultsSummary(ctx, r, runs[i])
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	before, after := filterToSharedScope(beforeRun, afterRun, results[0], results[1])
+
+	diff := DiffResultsSummaries(before, after, filter)
+	writeDiff(w, diff, withMetadata, metadata, runs)
 }
 
 // handleAPIDiffPost handles POST requests to /api/diff, which allows the caller to produce the diff of an arbitrary
-// run result JSON blob against a historical production run.
+// run result JSON blob against a historical production run. The body is either a results summary or the raw
+// wptreport JSON of a (local) run, which is converted into a summary (see ParseResults).
+//
+// URL Params:
+//     before: platform@revision spec of the production run
+//     filter: (optional) Differences to include (see ParseDiffFilterParam)
+//     format: (optional) Format of the body, summary or report; detected from the body by default
+//     partial: (optional) Treat tests missing from the body as not run, rather than deleted
+//     metadata: (optional) Annotate the diff with the test metadata which applies to its tests (see writeDiff)
 func handleAPIDiffPost(w http.ResponseWriter, r *http.Request) {
 	ctx := appengine.NewContext(r)
 
@@ -334,26 +534,36 @@ func handleAPIDiffPost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "before param missing", http.StatusBadRequest)
 		return
 	}
-	var beforeJSON map[string][]int
-	if beforeJSON, err = fetchRunResultsJSONForParam(ctx, r, specBefore); err != nil {
+	spec, err := parsePlatformAtRevisionSpec(specBefore)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	beforeRun, err := fetchRunForSpec(ctx, spec)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
-	} else if beforeJSON == nil {
+	} else if beforeRun.ID == 0 {
 		http.Error(w, specBefore+" not found", http.StatusNotFound)
 		return
 	}
-
-	var body []byte
-	if body, err = ioutil.ReadAll(r.Body); err != nil {
+	var before *ResultsSummary
+	if before, err = fetchRunResultsSummary(ctx, r, beforeRun); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var afterJSON map[string][]int
-	if err = json.Unmarshal(body, &afterJSON); err != nil {
+	var after *ResultsSummary
+	if after, err = ParseResults(r.Body, params.Get("format")); err != nil {
 		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if ParseBooleanParam(r, "partial") {
+		before = before.FilterToTests(after)
+	}
+	if filter := SharedScopeFilter(beforeRun); filter != nil {
+		after = after.Filter(filter)
+	}
 
 	var filter DiffFilterParam
 	if filter, err = ParseDiffFilterParam(r); err != nil {
@@ -361,9 +571,47 @@ func handleAPIDiffPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	diffJSON := getResultsDiff(beforeJSON, afterJSON, filter)
+	var metadata []TestMetadata
+	withMetadata := ParseBooleanParam(r, "metadata")
+	if withMetadata {
+		if metadata, err = loadTestMetadata(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	diff := DiffResultsSummaries(before, after, filter)
+	writeDiff(w, diff, withMetadata, metadata, []TestRun{beforeRun})
+}
+
+// annotatedDiff is the response of /api/diff when the 'metadata' param is set.
+type annotatedDiff struct {
+	Diff *ResultsSummary `json:"diff"`
+
+	// Metadata which applies to the tests in the diff, keyed by test.
+	Metadata map[string][]TestMetadata `json:"metadata"`
+}
+
+// writeDiff writes the diff to the response. When withMetadata is set, the diff is wrapped in an annotatedDiff,
+// along with the metadata which applies to its tests on the platforms of the diffed runs.
+func writeDiff(w http.ResponseWriter, diff *ResultsSummary, withMetadata bool, metadata []TestMetadata,
+	runs []TestRun) {
 	var bytes []byte
-	if bytes, err = json.Marshal(diffJSON); err != nil {
+	var err error
+	if !withMetadata {
+		bytes, err = diff.MarshalJSON()
+	} else {
+		tests := make([]string, diff.Len())
+		for i := range tests {
+			tests[i], _ = diff.At(i)
+		}
+		platforms := make([]string, len(runs))
+		for i, run := range runs {
+			platforms[i] = run.BrowserName
+		}
+		bytes, err = json.Marshal(annotatedDiff{Diff: diff, Metadata: annotateTests(metadata, tests, platforms)})
+	}
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
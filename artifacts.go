@@ -0,0 +1,195 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// The kinds of RunArtifact: runner logs (e.g. its stdout and stderr), browser crash logs, and per-test stack
+// traces.
+const (
+	ArtifactKindLog   = "log"
+	ArtifactKindCrash = "crash"
+	ArtifactKindStack = "stack"
+)
+
+// ArtifactKinds are all of the kinds of artifacts.
+var ArtifactKinds = []string{ArtifactKindLog, ArtifactKindCrash, ArtifactKindStack}
+
+// maxArtifactSize is the maximum (decompressed) size of an artifact. Artifacts are served decompressed, so this is
+// well below App Engine's 32MB limit on the size of a response.
+const maxArtifactSize = 16 << 20
+
+// maxArtifactsPerRun is the maximum number of artifacts a run can have, since they're listed on the TestRun entity.
+const maxArtifactsPerRun = 100
+
+// artifactsDir is the directory within a run's results directory which its artifacts are stored in. It's named
+// so that it can't clash with a WPT directory.
+const artifactsDir = "/__artifacts__"
+
+// artifactNameRegex matches valid artifact names, e.g. "stderr.log".
+var artifactNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._\-]*$`)
+
+var (
+	errArtifactTooLarge = fmt.Errorf("artifact is larger than %d bytes (decompressed)", maxArtifactSize)
+	errTooManyArtifacts = fmt.Errorf("run already has %d artifacts", maxArtifactsPerRun)
+	errArtifactNotFound = errors.New("artifact not found")
+)
+
+// validateArtifact checks the name, kind and test of an artifact being uploaded.
+func validateArtifact(artifact RunArtifact) error {
+	if !artifactNameRegex.MatchString(artifact.Name) {
+		return fmt.Errorf("invalid name %s", artifact.Name)
+	}
+	if !containsString(ArtifactKinds, artifact.Kind) {
+		return fmt.Errorf("invalid kind %s (must be one of %s)", artifact.Kind, strings.Join(ArtifactKinds, ", "))
+	}
+	if artifact.Test != "" && (!strings.HasPrefix(artifact.Test, "/") || path.Clean(artifact.Test) != artifact.Test) {
+		return fmt.Errorf("invalid test %s (must be a path starting with /)", artifact.Test)
+	}
+	return nil
+}
+
+// getArtifactFile returns the path of an artifact relative to the run's results directory.
+func getArtifactFile(name string) string {
+	return artifactsDir + "/" + name
+}
+
+// getArtifactURL returns the URL the artifact of the run is served from (see apiRunArtifactHandler).
+func getArtifactURL(runID int64, name string) string {
+	return fmt.Sprintf("/api/run/artifact?run_id=%d&name=%s", runID, url.QueryEscape(name))
+}
+
+// getArtifact returns the run's artifact with the given name, or nil if it doesn't have one.
+func (run TestRun) getArtifact(name string) *RunArtifact {
+	for i := range run.Artifacts {
+		if run.Artifacts[i].Name == name {
+			return &run.Artifacts[i]
+		}
+	}
+	return nil
+}
+
+// readArtifact reads an uploaded artifact, decompressing it if it's gzipped.
+func readArtifact(r io.Reader) ([]byte, error) {
+	rc, err := maybeGunzip(ioutil.NopCloser(r))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readAllLimited(rc, maxArtifactSize, errArtifactTooLarge)
+}
+
+// readAllLimited reads all of the reader's data, returning tooLarge if there's more than limit bytes.
+func readAllLimited(r io.Reader, limit int64, tooLarge error) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(data)) > limit {
+		return nil, tooLarge
+	}
+	return data, nil
+}
+
+// writeArtifact stores the (decompressed) artifact of the run, gzipped, with the run's results files.
+func writeArtifact(ctx context.Context, writer ResultsWriter, run TestRun, name string, data []byte) error {
+	if run.ResultsURL == "" {
+		return errNoResultsURL
+	}
+	resultsPath, err := getResultsPath(run, getArtifactFile(name))
+	if err != nil {
+		return err
+	}
+	gzipped, err := gzipData(data)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(ctx, resultsPath, gzipped)
+	return err
+}
+
+// addArtifact adds the artifact to the run, replacing any with the same name, and sets its URL.
+func (run *TestRun) addArtifact(artifact RunArtifact) error {
+	artifact.URL = getArtifactURL(run.ID, artifact.Name)
+	if existing := run.getArtifact(artifact.Name); existing != nil {
+		*existing = artifact
+		return nil
+	}
+	if len(run.Artifacts) >= maxArtifactsPerRun {
+		return errTooManyArtifacts
+	}
+	run.Artifacts = append(run.Artifacts, artifact)
+	return nil
+}
+
+// addRunArtifact records the (stored) artifact on the run with the given ID, returning the artifact as recorded.
+func addRunArtifact(ctx context.Context, runID int64, artifact RunArtifact, now time.Time) (RunArtifact, error) {
+	artifact.UploadedAt = now
+	key := datastore.NewKey(ctx, "TestRun", "", runID, nil)
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var run TestRun
+		if err := datastore.Get(ctx, key, &run); err == datastore.ErrNoSuchEntity {
+			return testRunNotFoundError(runID)
+		} else if err != nil {
+			return err
+		}
+		run.ID = runID
+		if err := run.addArtifact(artifact); err != nil {
+			return err
+		}
+		artifact = *run.getArtifact(artifact.Name)
+		_, err := datastore.Put(ctx, key, &run)
+		return err
+	}, nil)
+	return artifact, err
+}
+
+// loadArtifact reads the (decompressed) artifact of the run from the results store. It returns
+// errArtifactNotFound if the run doesn't have one with the given name.
+func loadArtifact(ctx context.Context, store ResultsStore, run TestRun, name string) ([]byte, error) {
+	if run.getArtifact(name) == nil {
+		return nil, errArtifactNotFound
+	}
+	f, err := store.Open(ctx, run, getArtifactFile(name))
+	if err == errResultsNotFound {
+		return nil, errArtifactNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readAllLimited(f, maxArtifactSize, errArtifactTooLarge)
+}
+
+// getArtifactContentType returns the content type to serve an artifact with. Artifacts are never served as HTML
+// (or anything else a browser would render), since they're uploaded by runners.
+func getArtifactContentType(data []byte) string {
+	if utf8.Valid(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"google.golang.org/appengine"
+)
+
+// apiRunArtifactHandler is responsible for the artifacts of runs (see RunArtifact), which are listed on the run.
+// GET serves an artifact (decompressed, with support for Range requests). PUT (or POST) uploads one from the body,
+// which may be gzipped, replacing any previous artifact with the same name, and emits it; uploads require the
+// upload token, supplied in the 'secret' param, and the run's results_url.
+//
+// URL Params:
+//     run_id: ID of the run
+//     name: Name of the artifact, e.g. 'stderr.log'
+//     kind: (PUT) 'log', 'crash' or 'stack'
+//     test: (PUT, optional) Path of the test the artifact relates to
+func apiRunArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if r.Method != "GET" && r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "This endpoint only supports GET, PUT and POST.", http.StatusMethodNotAllowed)
+		return
+	} else if r.Method != "GET" && !checkUploadToken(ctx, w, r) {
+		return
+	}
+	params := r.URL.Query()
+	artifact := RunArtifact{Name: params.Get("name"), Kind: params.Get("kind"), Test: params.Get("test")}
+	run, ok := loadRunByIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "GET" {
+		data, err := loadArtifact(ctx, getResultsStore(ctx, r), run, artifact.Name)
+		if err == errArtifactNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", getArtifactContentType(data))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, "", run.getArtifact(artifact.Name).UploadedAt, bytes.NewReader(data))
+		return
+	}
+
+	if err := validateArtifact(artifact); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := readArtifact(r.Body)
+	if err == errArtifactTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if run.getArtifact(artifact.Name) == nil && len(run.Artifacts) >= maxArtifactsPerRun {
+		http.Error(w, errTooManyArtifacts.Error(), http.StatusConflict)
+		return
+	}
+	if err = writeArtifact(ctx, getResultsWriter(), run, artifact.Name, data); err == errNoResultsURL {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	artifact.Size = int64(len(data))
+	if artifact, err = addRunArtifact(ctx, run.ID, artifact, time.Now()); err == errTooManyArtifacts {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	artifactBytes, err := json.Marshal(artifact)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(artifactBytes)
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestValidateArtifact(t *testing.T) {
+	assert.Nil(t, validateArtifact(RunArtifact{Name: "stderr.log", Kind: ArtifactKindLog}))
+	assert.Nil(t, validateArtifact(RunArtifact{Name: "a-1_2.txt", Kind: ArtifactKindStack, Test: "/css/a.html"}))
+	assert.NotNil(t, validateArtifact(RunArtifact{Name: "stderr.log", Kind: "core"}))
+	assert.NotNil(t, validateArtifact(RunArtifact{Name: "", Kind: ArtifactKindLog}))
+	assert.NotNil(t, validateArtifact(RunArtifact{Name: ".hidden", Kind: ArtifactKindLog}))
+	assert.NotNil(t, validateArtifact(RunArtifact{Name: "a/b.log", Kind: ArtifactKindLog}))
+	assert.NotNil(t, validateArtifact(RunArtifact{Name: "a.log", Kind: ArtifactKindStack, Test: "css/a.html"}))
+	assert.NotNil(t, validateArtifact(RunArtifact{Name: "a.log", Kind: ArtifactKindStack, Test: "/css/../a.html"}))
+}
+
+func TestReadArtifact(t *testing.T) {
+	data, err := readArtifact(strings.NewReader("plain"))
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", string(data))
+
+	data, err = readArtifact(bytes.NewReader(gzipBytes("gzipped")))
+	assert.Nil(t, err)
+	assert.Equal(t, "gzipped", string(data))
+
+	_, err = readArtifact(bytes.NewReader(make([]byte, maxArtifactSize+1)))
+	assert.Equal(t, errArtifactTooLarge, err)
+}
+
+func TestTestRun_AddArtifact(t *testing.T) {
+	run := TestRun{ID: 123}
+	assert.Nil(t, run.addArtifact(RunArtifact{Name: "stderr.log", Kind: ArtifactKindLog, Size: 1}))
+	assert.Nil(t, run.addArtifact(RunArtifact{Name: "crash.txt", Kind: ArtifactKindCrash}))
+	assert.Len(t, run.Artifacts, 2)
+	assert.Equal(t, "/api/run/artifact?run_id=123&name=stderr.log", run.Artifacts[0].URL)
+
+	// Re-uploading replaces the artifact.
+	assert.Nil(t, run.addArtifact(RunArtifact{Name: "stderr.log", Kind: ArtifactKindLog, Size: 2}))
+	assert.Len(t, run.Artifacts, 2)
+	assert.Equal(t, int64(2), run.getArtifact("stderr.log").Size)
+	assert.Nil(t, run.getArtifact("stdout.log"))
+
+	run.Artifacts = make([]RunArtifact, maxArtifactsPerRun)
+	assert.Equal(t, errTooManyArtifacts, run.addArtifact(RunArtifact{Name: "another.log"}))
+}
+
+func TestArtifacts_WriteAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := localResultsStore{dir: dir}
+	ctx := context.Background()
+
+	run := TestRun{ID: 123, Revision: "abcdef0123"}
+	assert.Equal(t, errNoResultsURL, writeArtifact(ctx, store, run, "stderr.log", []byte("log")))
+
+	run.ResultsURL = "https://storage.googleapis.com/wptd/abcdef0123/chrome-63.0-linux-summary.json.gz"
+	assert.Nil(t, writeArtifact(ctx, store, run, "stderr.log", []byte("log")))
+	_, err = os.Stat(filepath.Join(dir, "abcdef0123", "chrome-63.0-linux", "__artifacts__", "stderr.log"))
+	assert.Nil(t, err)
+
+	// Artifacts which aren't listed on the run aren't served.
+	_, err = loadArtifact(ctx, store, run, "stderr.log")
+	assert.Equal(t, errArtifactNotFound, err)
+
+	run.Artifacts = []RunArtifact{{Name: "stderr.log", Kind: ArtifactKindLog, UploadedAt: time.Now()}}
+	data, err := loadArtifact(ctx, store, run, "stderr.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "log", string(data))
+}
+
+func TestGetArtifactContentType(t *testing.T) {
+	assert.Equal(t, "text/plain; charset=utf-8", getArtifactContentType([]byte("<html>")))
+	assert.Equal(t, "application/octet-stream", getArtifactContentType([]byte{0xff, 0xfe, 0x00}))
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// browsersSeedFile is the file the browser registry is seeded from, until the Datastore has been populated
+// (see /api/admin/browsers/import). It is also the import/export format.
+const browsersSeedFile = "browsers.json"
+
+// browserRegistryTTL is how long an instance uses the browsers it loaded from the Datastore before reloading them,
+// so that changes made through the admin API (on any instance) are picked up.
+const browserRegistryTTL = time.Minute
+
+// PlatformIDRegex matches valid platform IDs (the keys of browsers.json), e.g. "safari-11.0-macos-10.12-sauce".
+var PlatformIDRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9.\-]*$`)
+
+// browsers is the registry used by GetBrowsers, GetBrowserNames and IsBrowserName.
+var browsers = &browserRegistry{}
+
+// browserRegistry holds an immutable snapshot of the known browsers, which is replaced wholesale whenever the
+// browsers are (re)loaded, so readers never need to hold a lock for longer than it takes to grab the snapshot.
+type browserRegistry struct {
+	mu       sync.RWMutex
+	snapshot *browserSnapshot
+	loadedAt time.Time // Last load from the Datastore.
+}
+
+type browserSnapshot struct {
+	browsers map[string]Browser
+
+	// No 'set' type in Go, so use map instead.
+	names             map[string]bool
+	namesAlphabetical []string
+}
+
+func newBrowserSnapshot(browsers map[string]Browser) *browserSnapshot {
+	snapshot := &browserSnapshot{
+		browsers: browsers,
+		names:    make(map[string]bool),
+	}
+	for _, browser := range browsers {
+		if browser.InitiallyLoaded && !snapshot.names[browser.BrowserName] {
+			snapshot.namesAlphabetical = append(snapshot.namesAlphabetical, browser.BrowserName)
+			snapshot.names[browser.BrowserName] = true
+		}
+	}
+	sort.Strings(snapshot.namesAlphabetical)
+	return snapshot
+}
+
+// get returns the current snapshot, seeding the registry from browsers.json if nothing has been loaded yet.
+func (r *browserRegistry) get() (*browserSnapshot, error) {
+	r.mu.RLock()
+	snapshot := r.snapshot
+	r.mu.RUnlock()
+	if snapshot != nil {
+		return snapshot, nil
+	}
+
+	seed, err := readBrowsersFile(browsersSeedFile)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.snapshot == nil {
+		r.snapshot = newBrowserSnapshot(seed)
+	}
+	return r.snapshot, nil
+}
+
+// set replaces the registry's browsers.
+func (r *browserRegistry) set(browsers map[string]Browser) {
+	snapshot := newBrowserSnapshot(browsers)
+	r.mu.Lock()
+	r.snapshot = snapshot
+	r.mu.Unlock()
+}
+
+// refresh reloads the browsers from the Datastore if they haven't been loaded within browserRegistryTTL.
+// Failures are logged, and the previous snapshot is kept.
+func (r *browserRegistry) refresh(ctx context.Context) {
+	// Claim the reload, so that concurrent requests keep using the current snapshot in the meantime.
+	r.mu.Lock()
+	stale := time.Since(r.loadedAt) > browserRegistryTTL
+	if stale {
+		r.loadedAt = time.Now()
+	}
+	r.mu.Unlock()
+	if !stale {
+		return
+	}
+	if err := r.load(ctx); err != nil {
+		log.Warningf(ctx, "Failed to load browsers: %s", err.Error())
+	}
+}
+
+// load (re)loads the browsers from the Datastore. If there are no Browser entities, the browsers.json seed
+// is used.
+func (r *browserRegistry) load(ctx context.Context) error {
+	stored, err := loadStoredBrowsers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(stored) == 0 {
+		if stored, err = readBrowsersFile(browsersSeedFile); err != nil {
+			return err
+		}
+	}
+	snapshot := newBrowserSnapshot(stored)
+	r.mu.Lock()
+	r.snapshot = snapshot
+	r.loadedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// browserRegistryKey is the parent of all Browser entities, so that the registry can be read with a strongly
+// consistent (ancestor) query straight after it is modified.
+func browserRegistryKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "BrowserRegistry", "default", 0, nil)
+}
+
+func browserKey(ctx context.Context, platformID string) *datastore.Key {
+	return datastore.NewKey(ctx, "Browser", platformID, 0, browserRegistryKey(ctx))
+}
+
+// loadStoredBrowsers loads all the Browser entities, keyed by platform ID.
+func loadStoredBrowsers(ctx context.Context) (map[string]Browser, error) {
+	var stored []Browser
+	keys, err := datastore.NewQuery("Browser").Ancestor(browserRegistryKey(ctx)).GetAll(ctx, &stored)
+	if err != nil {
+		return nil, err
+	}
+	browsers := make(map[string]Browser, len(stored))
+	for i, key := range keys {
+		browsers[key.StringID()] = stored[i]
+	}
+	return browsers, nil
+}
+
+func readBrowsersFile(path string) (browsers map[string]Browser, err error) {
+	var bytes []byte
+	if bytes, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(bytes, &browsers); err != nil {
+		return nil, err
+	}
+	return browsers, nil
+}
+
+// validateBrowser checks that a browser submitted to the admin API is fully specified.
+func validateBrowser(platformID string, browser Browser) error {
+	if !PlatformIDRegex.MatchString(platformID) {
+		return fmt.Errorf("invalid platform ID %s", platformID)
+	}
+	if browser.BrowserName == "" || browser.BrowserVersion == "" {
+		return fmt.Errorf("platform %s missing browser_name or browser_version", platformID)
+	}
+	if browser.OSName == "" || browser.OSVersion == "" {
+		return fmt.Errorf("platform %s missing os_name or os_version", platformID)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBrowserSnapshot(t *testing.T) {
+	snapshot := newBrowserSnapshot(map[string]Browser{
+		"firefox-57.0-linux":       {InitiallyLoaded: true, BrowserName: "firefox"},
+		"chrome-63.0-linux":        {InitiallyLoaded: true, BrowserName: "chrome"},
+		"chrome-64.0-linux":        {InitiallyLoaded: true, BrowserName: "chrome"},
+		"edge-15-windows-10-sauce": {InitiallyLoaded: false, BrowserName: "edge"},
+	})
+	assert.Equal(t, []string{"chrome", "firefox"}, snapshot.namesAlphabetical)
+	assert.True(t, snapshot.names["chrome"])
+	assert.False(t, snapshot.names["edge"])
+	assert.Len(t, snapshot.browsers, 4)
+}
+
+func TestBrowserRegistry_Seed(t *testing.T) {
+	registry := &browserRegistry{}
+	snapshot, err := registry.get()
+	assert.Nil(t, err)
+	seed, err := readBrowsersFile(browsersSeedFile)
+	assert.Nil(t, err)
+	assert.Equal(t, seed, snapshot.browsers)
+}
+
+func TestBrowserRegistry_Set(t *testing.T) {
+	registry := &browserRegistry{}
+	registry.set(map[string]Browser{
+		"chrome-64.0-linux": {InitiallyLoaded: true, BrowserName: "chrome"},
+	})
+	snapshot, err := registry.get()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"chrome"}, snapshot.namesAlphabetical)
+}
+
+func TestValidateBrowser(t *testing.T) {
+	browser := Browser{
+		BrowserName:    "chrome",
+		BrowserVersion: "64.0",
+		OSName:         "linux",
+		OSVersion:      "*",
+	}
+	assert.Nil(t, validateBrowser("chrome-64.0-linux", browser))
+	assert.NotNil(t, validateBrowser("Chrome 64", browser))
+	assert.NotNil(t, validateBrowser("", browser))
+
+	invalid := browser
+	invalid.BrowserVersion = ""
+	assert.NotNil(t, validateBrowser("chrome-64.0-linux", invalid))
+
+	invalid = browser
+	invalid.OSName = ""
+	assert.NotNil(t, validateBrowser("chrome-64.0-linux", invalid))
+}
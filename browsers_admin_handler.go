@@ -0,0 +1,220 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// apiAdminBrowsersHandler is responsible for managing the browser registry (see browser_registry.go).
+// All methods require an admin of the app (see checkAdminRequest).
+//
+// GET emits all browsers, in the browsers.json format.
+// PUT creates or replaces the browser with the given 'platform' ID, from a JSON body in the Browser format.
+// DELETE removes the browser with the given 'platform' ID.
+func apiAdminBrowsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkAdminRequest(ctx, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		handleAdminBrowsersGet(ctx, w, r)
+	case "PUT":
+		handleAdminBrowsersPut(ctx, w, r)
+	case "DELETE":
+		handleAdminBrowsersDelete(ctx, w, r)
+	default:
+		http.Error(w, "This endpoint only supports GET, PUT and DELETE.", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleAdminBrowsersGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if err := browsers.load(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBrowsers(w)
+}
+
+func handleAdminBrowsersPut(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	platformID := r.URL.Query().Get("platform")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var browser Browser
+	if err = json.Unmarshal(body, &browser); err != nil {
+		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateBrowser(platformID, browser); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := seedStoredBrowsers(ctx); err != nil {
+			return err
+		}
+		_, err := datastore.Put(ctx, browserKey(ctx, platformID), &browser)
+		return err
+	}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = browsers.load(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBrowsers(w)
+}
+
+func handleAdminBrowsersDelete(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	platformID := r.URL.Query().Get("platform")
+	if !PlatformIDRegex.MatchString(platformID) {
+		http.Error(w, "Invalid 'platform' param", http.StatusBadRequest)
+		return
+	}
+
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := seedStoredBrowsers(ctx); err != nil {
+			return err
+		}
+		return datastore.Delete(ctx, browserKey(ctx, platformID))
+	}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = browsers.load(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBrowsers(w)
+}
+
+// apiAdminBrowsersImportHandler replaces the browser registry with the browsers in the body of a POST request
+// (in the browsers.json format), or with the deployed browsers.json when the body is empty.
+// It requires an admin of the app (see checkAdminRequest).
+func apiAdminBrowsersImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := appengine.NewContext(r)
+	if !checkAdminRequest(ctx, w, r) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var imported map[string]Browser
+	if len(body) == 0 {
+		if imported, err = readBrowsersFile(browsersSeedFile); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if err = json.Unmarshal(body, &imported); err != nil {
+		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for platformID, browser := range imported {
+		if err = validateBrowser(platformID, browser); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		return replaceStoredBrowsers(ctx, imported)
+	}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = browsers.load(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBrowsers(w)
+}
+
+// seedStoredBrowsers populates the Datastore from browsers.json if it has no Browser entities yet, so that
+// modifying one browser doesn't drop all of the others from the registry.
+func seedStoredBrowsers(ctx context.Context) error {
+	keys, err := datastore.NewQuery("Browser").Ancestor(browserRegistryKey(ctx)).KeysOnly().Limit(1).GetAll(ctx, nil)
+	if err != nil || len(keys) > 0 {
+		return err
+	}
+	seed, err := readBrowsersFile(browsersSeedFile)
+	if err != nil {
+		return err
+	}
+	return replaceStoredBrowsers(ctx, seed)
+}
+
+// replaceStoredBrowsers replaces all of the Browser entities with the given browsers.
+func replaceStoredBrowsers(ctx context.Context, replacements map[string]Browser) error {
+	existing, err := datastore.NewQuery("Browser").Ancestor(browserRegistryKey(ctx)).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var removed []*datastore.Key
+	for _, key := range existing {
+		if _, ok := replacements[key.StringID()]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	if err = datastore.DeleteMulti(ctx, removed); err != nil {
+		return err
+	}
+
+	keys := make([]*datastore.Key, 0, len(replacements))
+	values := make([]Browser, 0, len(replacements))
+	for platformID, browser := range replacements {
+		keys = append(keys, browserKey(ctx, platformID))
+		values = append(values, browser)
+	}
+	_, err = datastore.PutMulti(ctx, keys, values)
+	return err
+}
+
+// writeBrowsers writes the current registry to the response, in the browsers.json format.
+func writeBrowsers(w http.ResponseWriter) {
+	registry, err := GetBrowsers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bytes, err := json.MarshalIndent(registry, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
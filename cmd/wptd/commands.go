@@ -0,0 +1,604 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !appengine
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/w3c/wptdashboard"
+	"golang.org/x/net/context"
+)
+
+// command is the implementation of a wptd subcommand. args are the arguments following the subcommand's name.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, src source, out *output, args []string) error
+}
+
+var commands = []command{
+	{
+		name:    "runs",
+		usage:   "runs [--sha=latest] [--browsers=chrome,firefox] [--max-count=1] [--complete]",
+		summary: "List test runs",
+		run:     runsCommand,
+	},
+	{
+		name:    "diff",
+		usage:   "diff --before=SPEC (--after=SPEC | --after-file=FILE [--partial]) [--filter=ADC] [path...]",
+		summary: "Diff the results of a run and another run, or a local summary or wptreport file",
+		run:     diffCommand,
+	},
+	{
+		name:    "summary",
+		usage:   "summary platform@revision [path...]",
+		summary: "Show the results of a run, optionally under the given paths",
+		run:     summaryCommand,
+	},
+	{
+		name:    "history",
+		usage:   "history --browser=chrome [--max-count=10] [path...]",
+		summary: "Show the results of a browser's recent runs, optionally under the given paths",
+		run:     historyCommand,
+	},
+	{
+		name:    "expectations",
+		usage:   "expectations --type=chromium|gecko --file=PATH [--generate] platform@revision [path...]",
+		summary: "Compare a browser's TestExpectations file or metadata directory with a run, or --generate it",
+		run:     expectationsCommand,
+	},
+	{
+		name:    "owners",
+		usage:   "owners --wpt-dir=DIR",
+		summary: "List the owners of directories in a WPT checkout (import the JSON to /api/admin/owners/import)",
+		run:     ownersCommand,
+	},
+	{
+		name:    "upload",
+		usage:   "upload --secret=token run.json",
+		summary: "Create a test run from a JSON file (server only)",
+		run:     uploadCommand,
+	},
+}
+
+func getCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// newFlagSet returns a flag set for the command, which reports errors (rather than exiting).
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return flags
+}
+
+// pathsFilter returns a filter for tests under any of the given path prefixes, or nil if there are none.
+func pathsFilter(paths []string) func(test string) bool {
+	if len(paths) == 0 {
+		return nil
+	}
+	prefixes := make([]string, len(paths))
+	for i, path := range paths {
+		prefixes[i] = "/" + strings.TrimPrefix(path, "/")
+	}
+	return func(test string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(test, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// loadSummary loads the run's results summary, filtered to the tests under the given paths.
+func loadSummary(ctx context.Context, src source, run wptdashboard.TestRun, paths []string) (
+	*wptdashboard.ResultsSummary, error) {
+	summary, err := src.summary(ctx, run)
+	if err != nil {
+		return nil, err
+	}
+	if filter := pathsFilter(paths); filter != nil {
+		summary = summary.Filter(filter)
+	}
+	return summary, nil
+}
+
+// readResultsFile reads a local results summary or wptreport file (see wptdashboard.ParseResults), which may be
+// gzipped, filtered to the tests under the given paths.
+func readResultsFile(file, format string, paths []string) (*wptdashboard.ResultsSummary, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buffered := bufio.NewReader(f)
+	var r io.Reader = buffered
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	summary, err := wptdashboard.ParseResults(r, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err.Error())
+	}
+	if filter := pathsFilter(paths); filter != nil {
+		summary = summary.Filter(filter)
+	}
+	return summary, nil
+}
+
+// formatCounts formats a pair of counts, e.g. "3/4", or "-" if there aren't any.
+func formatCounts(counts wptdashboard.TestCounts, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%d/%d", counts[0], counts[1])
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func runsCommand(ctx context.Context, src source, out *output, args []string) error {
+	flags := newFlagSet("runs")
+	sha := flags.String("sha", "latest", "SHA[0:10] of the runs, or latest")
+	browsers := flags.String("browsers", "", "Comma-separated browser names (default browsers if empty)")
+	maxCount := flags.Int("max-count", 1, "Maximum number of runs per browser")
+	complete := flags.Bool("complete", false, "Only the latest revision with runs for all browsers")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	query := runsQuery{SHA: *sha, MaxCount: *maxCount, Complete: *complete}
+	if *browsers != "" {
+		query.BrowserNames = strings.Split(*browsers, ",")
+	}
+	testRuns, err := src.runs(ctx, query)
+	if err != nil {
+		return err
+	}
+	return writeRuns(out, testRuns)
+}
+
+func writeRuns(out *output, testRuns []wptdashboard.TestRun) error {
+	t := table{headers: []string{"ID", "Browser", "Version", "OS", "Revision", "Created"}}
+	for _, run := range testRuns {
+		os := strings.TrimSpace(run.OSName + " " + run.OSVersion)
+		t.add(strconv.FormatInt(run.ID, 10), run.BrowserName, run.BrowserVersion, os, run.Revision,
+			formatTime(run.CreatedAt))
+	}
+	if testRuns == nil {
+		testRuns = []wptdashboard.TestRun{}
+	}
+	return out.write(testRuns, t)
+}
+
+func diffCommand(ctx context.Context, src source, out *output, args []string) error {
+	flags := newFlagSet("diff")
+	before := flags.String("before", "", "platform@revision spec of the run to diff from")
+	after := flags.String("after", "", "platform@revision spec of the run to diff to")
+	afterFile := flags.String("after-file", "", "Local summary or wptreport file (optionally gzipped) to diff to")
+	afterFormat := flags.String("after-format", "", "Format of --after-file, summary or report (default detected)")
+	partial := flags.Bool("partial", false, "Treat tests missing from --after-file as not run, rather than deleted")
+	filterParam := flags.String("filter", "", "Differences to include: A(dded), D(eleted) and/or C(hanged)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *before == "" || (*after == "") == (*afterFile == "") {
+		return errors.New("--before and one of --after or --after-file are required")
+	}
+	filter, err := wptdashboard.ParseDiffFilter(*filterParam)
+	if err != nil {
+		return err
+	}
+
+	var runs []wptdashboard.TestRun
+	var summaries [2]*wptdashboard.ResultsSummary
+	for i, spec := range []string{*before, *after} {
+		if spec == "" {
+			continue
+		}
+		run, err := src.run(ctx, spec)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+		if summaries[i], err = loadSummary(ctx, src, run, flags.Args()); err != nil {
+			return err
+		}
+	}
+	if *afterFile != "" {
+		if summaries[1], err = readResultsFile(*afterFile, *afterFormat, flags.Args()); err != nil {
+			return err
+		}
+		if *partial {
+			summaries[0] = summaries[0].FilterToTests(summaries[1])
+		}
+	}
+	// Only compare the tests which both runs could have run, in case either is a partial run.
+	if scope := wptdashboard.SharedScopeFilter(runs...); scope != nil {
+		summaries[0], summaries[1] = summaries[0].Filter(scope), summaries[1].Filter(scope)
+	}
+	diff := wptdashboard.DiffResultsSummaries(summaries[0], summaries[1], filter)
+
+	t := table{headers: []string{"Test", "Before", "After", "Different"}}
+	for i := 0; i < diff.Len(); i++ {
+		test, counts := diff.At(i)
+		t.add(test, formatCounts(summaries[0].Get(test)), formatCounts(summaries[1].Get(test)),
+			formatCounts(counts, true))
+	}
+	return out.write(diff, t)
+}
+
+func summaryCommand(ctx context.Context, src source, out *output, args []string) error {
+	flags := newFlagSet("summary")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return errors.New("a platform@revision spec is required")
+	}
+	run, err := src.run(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	summary, err := loadSummary(ctx, src, run, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	t := table{headers: []string{"Test", "Passed", "Total"}}
+	var total wptdashboard.TestCounts
+	for i := 0; i < summary.Len(); i++ {
+		test, counts := summary.At(i)
+		t.add(test, strconv.Itoa(int(counts[0])), strconv.Itoa(int(counts[1])))
+		total[0] += counts[0]
+		total[1] += counts[1]
+	}
+	t.add("Total", strconv.Itoa(int(total[0])), strconv.Itoa(int(total[1])))
+	return out.write(summary, t)
+}
+
+// historyEntry is the JSON output of the history command, for each run.
+type historyEntry struct {
+	RunID     int64     `json:"run_id"`
+	Revision  string    `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	Passed    int       `json:"passed"`
+	Total     int       `json:"total"`
+}
+
+func historyCommand(ctx context.Context, src source, out *output, args []string) error {
+	flags := newFlagSet("history")
+	browser := flags.String("browser", "", "Browser name of the runs")
+	maxCount := flags.Int("max-count", 10, "Maximum number of runs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *browser == "" {
+		return errors.New("--browser is required")
+	}
+	testRuns, err := src.runs(ctx, runsQuery{SHA: "latest", BrowserNames: []string{*browser}, MaxCount: *maxCount})
+	if err != nil {
+		return err
+	}
+
+	history := []historyEntry{}
+	t := table{headers: []string{"ID", "Revision", "Created", "Passed", "Total"}}
+	for _, run := range testRuns {
+		summary, err := loadSummary(ctx, src, run, flags.Args())
+		if err != nil {
+			return err
+		}
+		entry := historyEntry{RunID: run.ID, Revision: run.Revision, CreatedAt: run.CreatedAt}
+		for i := 0; i < summary.Len(); i++ {
+			_, counts := summary.At(i)
+			entry.Passed += int(counts[0])
+			entry.Total += int(counts[1])
+		}
+		history = append(history, entry)
+		t.add(strconv.FormatInt(run.ID, 10), run.Revision, formatTime(run.CreatedAt),
+			strconv.Itoa(entry.Passed), strconv.Itoa(entry.Total))
+	}
+	return out.write(history, t)
+}
+
+func uploadCommand(ctx context.Context, src source, out *output, args []string) error {
+	flags := newFlagSet("upload")
+	secret := flags.String("secret", "", "Upload token")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *secret == "" || flags.NArg() != 1 {
+		return errors.New("--secret and a run JSON file are required")
+	}
+	body, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var run wptdashboard.TestRun
+	if err := json.Unmarshal(body, &run); err != nil {
+		return fmt.Errorf("failed to parse %s: %s", flags.Arg(0), err.Error())
+	}
+	uploaded, err := src.upload(ctx, run, *secret)
+	if err != nil {
+		return err
+	}
+	return writeRuns(out, []wptdashboard.TestRun{uploaded})
+}
+
+func expectationsCommand(ctx context.Context, src source, out *output, args []string) error {
+	flags := newFlagSet("expectations")
+	expectationsType := flags.String("type", "", "Format of the expectations, chromium or gecko")
+	file := flags.String("file", "", "TestExpectations file (chromium), or metadata directory (gecko)")
+	generate := flags.Bool("generate", false, "Generate --file from the run's results, instead of comparing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" || flags.NArg() < 1 {
+		return errors.New("--file and a platform@revision spec are required")
+	}
+	if *generate {
+		return generateExpectations(ctx, src, out, *expectationsType, *file, flags.Arg(0), flags.Args()[1:])
+	}
+	var expectations []wptdashboard.Expectation
+	var err error
+	switch *expectationsType {
+	case wptdashboard.ExpectationsFormatChromium:
+		expectations, err = readChromiumExpectations(*file)
+	case wptdashboard.ExpectationsFormatGecko:
+		expectations, err = readGeckoMetadataDir(*file)
+	default:
+		return fmt.Errorf("invalid --type %s (must be chromium or gecko)", *expectationsType)
+	}
+	if err != nil {
+		return err
+	}
+
+	run, err := src.run(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	summary, err := loadSummary(ctx, src, run, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	comparison := wptdashboard.CompareExpectations(expectations, summary)
+
+	t := table{headers: []string{"Test", "Subtest", "Expected", "Results"}}
+	for _, stale := range comparison.Stale {
+		t.add(stale.Test, stale.Subtest, strings.Join(stale.Results, " "), formatCounts(summary.Get(stale.Test)))
+	}
+	for i := 0; i < comparison.Unexpected.Len(); i++ {
+		test, counts := comparison.Unexpected.At(i)
+		t.add(test, "", "-", formatCounts(counts, true))
+	}
+	return out.write(comparison, t)
+}
+
+// generateExpectations writes the expectations of the run's tests (and subtests) under the given paths which
+// didn't pass, read from their results files (see wptdashboard.LoadFailingResults), to a TestExpectations file
+// (chromium), or to .ini files under a metadata directory (gecko). It lists the files written.
+func generateExpectations(ctx context.Context, src source, out *output, expectationsType, file, spec string,
+	paths []string) error {
+	if expectationsType != wptdashboard.ExpectationsFormatChromium &&
+		expectationsType != wptdashboard.ExpectationsFormatGecko {
+		return fmt.Errorf("invalid --type %s (must be chromium or gecko)", expectationsType)
+	}
+	run, err := src.run(ctx, spec)
+	if err != nil {
+		return err
+	}
+	summary, err := loadSummary(ctx, src, run, paths)
+	if err != nil {
+		return err
+	}
+	results, err := wptdashboard.LoadFailingResults(ctx, src.resultsStore(), run, summary)
+	if err != nil {
+		return err
+	}
+
+	var written []string
+	if expectationsType == wptdashboard.ExpectationsFormatChromium {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# Generated from the results of %s@%s.\n", run.BrowserName, run.Revision)
+		if err = wptdashboard.WriteChromiumExpectations(&buf, results); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		written = append(written, file)
+	} else {
+		files := wptdashboard.GenerateGeckoMetadata(results)
+		for name := range files {
+			written = append(written, name)
+		}
+		sort.Strings(written)
+		for i, name := range written {
+			// The names come from the run's test paths, so mustn't escape the metadata directory.
+			path := filepath.Join(file, filepath.FromSlash(name))
+			if rel, err := filepath.Rel(file, path); err != nil || strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("invalid metadata file %s", name)
+			}
+			if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err = ioutil.WriteFile(path, files[name], 0644); err != nil {
+				return err
+			}
+			written[i] = path
+		}
+	}
+
+	t := table{headers: []string{"File"}}
+	for _, name := range written {
+		t.add(name)
+	}
+	return out.write(written, t)
+}
+
+func readChromiumExpectations(file string) ([]wptdashboard.Expectation, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	expectations, err := wptdashboard.ParseChromiumExpectations(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err.Error())
+	}
+	return expectations, nil
+}
+
+// readGeckoMetadataDir parses all of the .ini files under a Gecko metadata directory (testing/web-platform/meta).
+func readGeckoMetadataDir(dir string) (expectations []wptdashboard.Expectation, err error) {
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(file, ".ini") {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		parsed, err := wptdashboard.ParseGeckoMetadata(filepath.ToSlash(rel), f)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %s", file, err.Error())
+		}
+		expectations = append(expectations, parsed...)
+		return nil
+	})
+	return expectations, err
+}
+
+func ownersCommand(ctx context.Context, src source, out *output, args []string) error {
+	flags := newFlagSet("owners")
+	wptDir := flags.String("wpt-dir", "", "Local checkout of web-platform-tests")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *wptDir == "" {
+		return errors.New("--wpt-dir is required")
+	}
+	owners, err := readWPTOwners(*wptDir)
+	if err != nil {
+		return err
+	}
+
+	var directoryOwners []wptdashboard.DirectoryOwners
+	for directory, names := range owners {
+		directoryOwners = append(directoryOwners, wptdashboard.DirectoryOwners{Directory: directory, Owners: names})
+	}
+	sort.Slice(directoryOwners, func(i, j int) bool {
+		return directoryOwners[i].Directory < directoryOwners[j].Directory
+	})
+
+	t := table{headers: []string{"Directory", "Owners"}}
+	for _, owners := range directoryOwners {
+		t.add(owners.Directory, strings.Join(owners.Owners, ", "))
+	}
+	return out.write(directoryOwners, t)
+}
+
+// readWPTOwners reads the owners of each directory of a WPT checkout which has an OWNERS or META.yml file (or
+// both), keyed by directory, e.g. "/dom/events/". Hidden directories (e.g. .git) are skipped.
+func readWPTOwners(root string) (map[string][]string, error) {
+	owners := make(map[string][]string)
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if file != root && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		var parse func(io.Reader) ([]string, error)
+		switch info.Name() {
+		case "OWNERS":
+			parse = wptdashboard.ParseOwnersFile
+		case "META.yml":
+			parse = wptdashboard.ParseMetaYML
+		default:
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		directory := "/"
+		if rel != "." {
+			directory = "/" + filepath.ToSlash(rel) + "/"
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		parsed, err := parse(f)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %s", file, err.Error())
+		}
+		for _, owner := range parsed {
+			if !containsOwner(owners[directory], owner) {
+				owners[directory] = append(owners[directory], owner)
+			}
+		}
+		return nil
+	})
+	return owners, err
+}
+
+func containsOwner(owners []string, owner string) bool {
+	for _, o := range owners {
+		if o == owner {
+			return true
+		}
+	}
+	return false
+}
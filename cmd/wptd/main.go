@@ -0,0 +1,93 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !appengine
+
+// Command wptd queries and diffs web-platform-tests runs, either through the API of a wptdashboard instance
+// (https://wpt.fyi by default) or from a local results directory, laid out like the results bucket
+// (<sha>/<platform>-summary.json.gz). Runs are named by platform@revision specs, e.g. chrome@latest.
+//
+// Usage:
+//     wptd [--server=URL | --results-dir=DIR] [--format=table|json|markdown] <command> [args]
+//
+// Run "wptd help" for the list of commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+const defaultServer = "https://wpt.fyi"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the wptd command with the given args, returning the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("wptd", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	server := flags.String("server", defaultServer, "URL of the wptdashboard instance to query")
+	resultsDir := flags.String("results-dir", "", "Local results directory to query, instead of a server")
+	format := flags.String("format", formatTable, "Output format: table, json or markdown")
+	flags.Usage = func() { printUsage(stderr, flags) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || flags.Arg(0) == "help" {
+		printUsage(stderr, flags)
+		return 2
+	}
+	cmd := getCommand(flags.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(stderr, "wptd: unknown command %s\n", flags.Arg(0))
+		printUsage(stderr, flags)
+		return 2
+	}
+
+	out, err := newOutput(stdout, *format)
+	if err != nil {
+		fmt.Fprintf(stderr, "wptd: %s\n", err.Error())
+		return 2
+	}
+	var src source
+	if *resultsDir != "" {
+		src = &localSource{dir: *resultsDir}
+	} else if src, err = newRemoteSource(*server, http.DefaultClient); err != nil {
+		fmt.Fprintf(stderr, "wptd: %s\n", err.Error())
+		return 2
+	}
+
+	if err := cmd.run(context.Background(), src, out, flags.Args()[1:]); err != nil {
+		fmt.Fprintf(stderr, "wptd %s: %s\n", cmd.name, err.Error())
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: wptd [flags] <command> [args]")
+	fmt.Fprintln(w, "\nFlags:")
+	flags.PrintDefaults()
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\n    \t%s\n", cmd.usage, cmd.summary)
+	}
+}
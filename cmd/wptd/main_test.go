@@ -0,0 +1,393 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !appengine
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/w3c/wptdashboard"
+	"golang.org/x/net/context"
+)
+
+// writeSummary writes a gzipped summary file for the platform to <dir>/<sha>, modified at the given time.
+func writeSummary(t *testing.T, dir, sha, platform, summary string, modTime time.Time) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(summary))
+	zw.Close()
+	file := filepath.Join(dir, sha, platform+summarySuffix)
+	assert.Nil(t, os.MkdirAll(filepath.Dir(file), 0755))
+	assert.Nil(t, ioutil.WriteFile(file, buf.Bytes(), 0644))
+	assert.Nil(t, os.Chtimes(file, modTime, modTime))
+}
+
+// newResultsDir returns a results directory with chrome and firefox runs at two revisions.
+func newResultsDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wptd")
+	assert.Nil(t, err)
+	day := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeSummary(t, dir, "aaaaaaaaaa", "chrome-63.0-linux", `{"/dom/a.html":[1,2],"/css/b.html":[1,1]}`, day)
+	writeSummary(t, dir, "bbbbbbbbbb", "chrome-64.0-linux", `{"/dom/a.html":[2,2],"/dom/c.html":[0,1]}`,
+		day.AddDate(0, 0, 1))
+	writeSummary(t, dir, "bbbbbbbbbb", "firefox-57.0-linux", `{"/dom/a.html":[0,2]}`, day.AddDate(0, 0, 1))
+	return dir
+}
+
+func runCommand(t *testing.T, args ...string) (stdout string, stderr string, code int) {
+	var out, errOut bytes.Buffer
+	code = run(args, &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+func TestLocalSource_Runs(t *testing.T) {
+	dir := newResultsDir(t)
+	defer os.RemoveAll(dir)
+	src := &localSource{dir: dir}
+	ctx := context.Background()
+
+	testRuns, err := src.runs(ctx, runsQuery{SHA: "latest", MaxCount: 1})
+	assert.Nil(t, err)
+	if assert.Len(t, testRuns, 2) {
+		assert.Equal(t, "chrome", testRuns[0].BrowserName)
+		assert.Equal(t, "64.0", testRuns[0].BrowserVersion)
+		assert.Equal(t, "linux", testRuns[0].OSName)
+		assert.Equal(t, "bbbbbbbbbb", testRuns[0].Revision)
+		assert.Equal(t, "firefox", testRuns[1].BrowserName)
+	}
+
+	testRuns, err = src.runs(ctx, runsQuery{SHA: "aaaaaaaaaa", BrowserNames: []string{"chrome"}})
+	assert.Nil(t, err)
+	if assert.Len(t, testRuns, 1) {
+		assert.Equal(t, "63.0", testRuns[0].BrowserVersion)
+	}
+
+	_, err = src.runs(ctx, runsQuery{SHA: "latest", Complete: true})
+	assert.NotNil(t, err)
+}
+
+func TestLocalSource_Run(t *testing.T) {
+	dir := newResultsDir(t)
+	defer os.RemoveAll(dir)
+	src := &localSource{dir: dir}
+	ctx := context.Background()
+
+	run, err := src.run(ctx, "chrome")
+	assert.Nil(t, err)
+	assert.Equal(t, "bbbbbbbbbb", run.Revision)
+
+	run, err = src.run(ctx, "chrome-63.0-linux@aaaaaaaaaa")
+	assert.Nil(t, err)
+	assert.Equal(t, "63.0", run.BrowserVersion)
+
+	summary, err := src.summary(ctx, run)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}, "/css/b.html": {1, 1}}, summary.ToMap())
+
+	_, err = src.run(ctx, "firefox@aaaaaaaaaa")
+	assert.NotNil(t, err)
+}
+
+func TestPathsFilter(t *testing.T) {
+	assert.Nil(t, pathsFilter(nil))
+	filter := pathsFilter([]string{"dom/", "/css/b"})
+	assert.True(t, filter("/dom/a.html"))
+	assert.True(t, filter("/css/b.html"))
+	assert.False(t, filter("/css/a.html"))
+}
+
+func TestRun_Diff(t *testing.T) {
+	dir := newResultsDir(t)
+	defer os.RemoveAll(dir)
+
+	stdout, stderr, code := runCommand(t, "--results-dir", dir, "diff",
+		"--before", "chrome@aaaaaaaaaa", "--after", "chrome@bbbbbbbbbb", "dom")
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, []string{"Test", "Before", "After", "Different"}, strings.Fields(lines[0]))
+		assert.Equal(t, []string{"/dom/a.html", "1/2", "2/2", "1/2"}, strings.Fields(lines[1]))
+		assert.Equal(t, []string{"/dom/c.html", "-", "0/1", "1/1"}, strings.Fields(lines[2]))
+	}
+
+	stdout, stderr, code = runCommand(t, "--results-dir", dir, "--format", "json", "diff",
+		"--before", "chrome@aaaaaaaaaa", "--after", "chrome@bbbbbbbbbb", "--filter", "D")
+	assert.Equal(t, 0, code, stderr)
+	var diff map[string][]int
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &diff))
+	assert.Equal(t, map[string][]int{"/css/b.html": {1, 1}}, diff)
+
+	_, stderr, code = runCommand(t, "--results-dir", dir, "diff", "--before", "chrome")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "--after")
+}
+
+func TestRun_DiffFile(t *testing.T) {
+	dir := newResultsDir(t)
+	defer os.RemoveAll(dir)
+	report := filepath.Join(dir, "wptreport.json")
+	assert.Nil(t, ioutil.WriteFile(report, []byte(`{"results": [
+		{"test": "/dom/a.html", "status": "OK", "subtests": [{"name": "x", "status": "FAIL"}]}
+	]}`), 0644))
+
+	stdout, stderr, code := runCommand(t, "--results-dir", dir, "--format", "json", "diff",
+		"--before", "chrome@latest", "--after-file", report)
+	assert.Equal(t, 0, code, stderr)
+	var diff map[string][]int
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &diff))
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}, "/dom/c.html": {1, 1}}, diff)
+
+	stdout, stderr, code = runCommand(t, "--results-dir", dir, "--format", "json", "diff",
+		"--before", "chrome@latest", "--after-file", report, "--partial")
+	assert.Equal(t, 0, code, stderr)
+	diff = nil
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &diff))
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}}, diff)
+
+	_, stderr, code = runCommand(t, "--results-dir", dir, "diff",
+		"--before", "chrome", "--after", "firefox", "--after-file", report)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "--after-file")
+}
+
+func TestRun_SummaryMarkdown(t *testing.T) {
+	dir := newResultsDir(t)
+	defer os.RemoveAll(dir)
+
+	stdout, stderr, code := runCommand(t, "--results-dir", dir, "--format", "markdown", "summary", "chrome")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, strings.Join([]string{
+		"| Test | Passed | Total |",
+		"| --- | --- | --- |",
+		"| /dom/a.html | 2 | 2 |",
+		"| /dom/c.html | 0 | 1 |",
+		"| Total | 2 | 3 |",
+	}, "\n")+"\n", stdout)
+}
+
+func TestRun_History(t *testing.T) {
+	dir := newResultsDir(t)
+	defer os.RemoveAll(dir)
+
+	stdout, stderr, code := runCommand(t, "--results-dir", dir, "--format", "json", "history",
+		"--browser", "chrome", "dom/a.html")
+	assert.Equal(t, 0, code, stderr)
+	var history []historyEntry
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &history))
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, "bbbbbbbbbb", history[0].Revision)
+		assert.Equal(t, 2, history[0].Passed)
+		assert.Equal(t, "aaaaaaaaaa", history[1].Revision)
+		assert.Equal(t, 1, history[1].Passed)
+		assert.Equal(t, 2, history[1].Total)
+	}
+}
+
+func TestRun_Expectations(t *testing.T) {
+	dir := newResultsDir(t)
+	defer os.RemoveAll(dir)
+	expectationsFile := filepath.Join(dir, "TestExpectations")
+	assert.Nil(t, ioutil.WriteFile(expectationsFile, []byte("external/wpt/dom/a.html [ Failure ]\n"), 0644))
+
+	stdout, stderr, code := runCommand(t, "--results-dir", dir, "--format", "json", "expectations",
+		"--type", "chromium", "--file", expectationsFile, "chrome")
+	assert.Equal(t, 0, code, stderr)
+	var comparison wptdashboard.ExpectationsComparison
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &comparison))
+	if assert.Len(t, comparison.Stale, 1) {
+		assert.Equal(t, "/dom/a.html", comparison.Stale[0].Test)
+	}
+	assert.Equal(t, map[string][]int{"/dom/c.html": {0, 1}}, comparison.Unexpected.ToMap())
+
+	metaDir := filepath.Join(dir, "meta")
+	assert.Nil(t, os.MkdirAll(filepath.Join(metaDir, "dom"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(metaDir, "dom", "b.html.ini"),
+		[]byte("[b.html]\n  [Subtest]\n    expected: FAIL\n"), 0644))
+	stdout, stderr, code = runCommand(t, "--results-dir", dir, "--format", "markdown", "expectations",
+		"--type", "gecko", "--file", metaDir, "firefox")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, strings.Join([]string{
+		"| Test | Subtest | Expected | Results |",
+		"| --- | --- | --- | --- |",
+		"| /dom/a.html |  | - | 0/2 |",
+	}, "\n")+"\n", stdout)
+
+	_, _, code = runCommand(t, "--results-dir", dir, "expectations", "--type", "webkit", "--file", metaDir, "chrome")
+	assert.NotEqual(t, 0, code)
+}
+
+func TestRun_GenerateExpectations(t *testing.T) {
+	dir := newResultsDir(t)
+	defer os.RemoveAll(dir)
+	resultsFile := filepath.Join(dir, "bbbbbbbbbb", "chrome-64.0-linux", "dom", "c.html")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(resultsFile), 0755))
+	assert.Nil(t, ioutil.WriteFile(resultsFile, []byte(`{"status": "TIMEOUT", "subtests": []}`), 0644))
+
+	expectationsFile := filepath.Join(dir, "TestExpectations")
+	_, stderr, code := runCommand(t, "--results-dir", dir, "expectations", "--generate", "--type", "chromium",
+		"--file", expectationsFile, "chrome", "dom/")
+	assert.Equal(t, 0, code, stderr)
+	generated, err := ioutil.ReadFile(expectationsFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(generated), "external/wpt/dom/c.html [ Timeout ]")
+
+	metaDir := filepath.Join(dir, "meta")
+	stdout, stderr, code := runCommand(t, "--results-dir", dir, "--format", "json", "expectations", "--generate",
+		"--type", "gecko", "--file", metaDir, "chrome")
+	assert.Equal(t, 0, code, stderr)
+	metadataFile := filepath.Join(metaDir, "dom", "c.html.ini")
+	assert.Equal(t, "[\n  "+strconv.Quote(metadataFile)+"\n]\n", stdout)
+	generated, err = ioutil.ReadFile(metadataFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "[c.html]\n  expected: TIMEOUT\n", string(generated))
+}
+
+func TestRun_Owners(t *testing.T) {
+	wptDir, err := ioutil.TempDir("", "wpt")
+	assert.Nil(t, err)
+	defer os.RemoveAll(wptDir)
+	for file, content := range map[string]string{
+		"OWNERS":               "@alice\n",
+		"dom/OWNERS":           "@bob\n",
+		"dom/META.yml":         "suggested_reviewers:\n  - bob\n  - carol\n",
+		"dom/events/test.html": "<!doctype html>",
+		".git/OWNERS":          "@mallory\n",
+	} {
+		path := filepath.Join(wptDir, filepath.FromSlash(file))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	owners, err := readWPTOwners(wptDir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{"/": {"alice"}, "/dom/": {"bob", "carol"}}, owners)
+
+	stdout, stderr, code := runCommand(t, "--format", "markdown", "owners", "--wpt-dir", wptDir)
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, strings.Join([]string{
+		"| Directory | Owners |",
+		"| --- | --- |",
+		"| / | alice |",
+		"| /dom/ | bob, carol |",
+	}, "\n")+"\n", stdout)
+}
+
+func TestRun_Usage(t *testing.T) {
+	_, stderr, code := runCommand(t)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "Commands:")
+
+	_, stderr, code = runCommand(t, "frobnicate")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "unknown command frobnicate")
+
+	_, _, code = runCommand(t, "--format", "yaml", "runs")
+	assert.Equal(t, 2, code)
+}
+
+func TestRemoteSource(t *testing.T) {
+	chrome := wptdashboard.TestRun{
+		ID:          1,
+		BrowserName: "chrome",
+		Revision:    "aaaaaaaaaa",
+		ResultsURL:  "/results/aaaaaaaaaa/chrome-63.0-linux-summary.json.gz",
+	}
+	var queries []string
+	var uploaded wptdashboard.TestRun
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/runs":
+			queries = append(queries, r.URL.RawQuery)
+			json.NewEncoder(w).Encode([]wptdashboard.TestRun{chrome})
+		case "/api/run":
+			if r.URL.Query().Get("secret") != "token" {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&uploaded)
+			uploaded.ID = 2
+			json.NewEncoder(w).Encode(uploaded)
+		case "/results/aaaaaaaaaa/chrome-63.0-linux-summary.json.gz":
+			w.Write([]byte(`{"/dom/a.html":[1,2]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	src, err := newRemoteSource(server.URL, http.DefaultClient)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	run, err := src.run(ctx, "chrome@aaaaaaaaaa")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), run.ID)
+	assert.Equal(t, []string{"browsers=chrome&max-count=1&sha=aaaaaaaaaa"}, queries)
+
+	summary, err := src.summary(ctx, run)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}}, summary.ToMap())
+
+	run, err = src.upload(ctx, wptdashboard.TestRun{BrowserName: "firefox"}, "token")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), run.ID)
+	assert.Equal(t, "firefox", uploaded.BrowserName)
+
+	_, err = src.upload(ctx, wptdashboard.TestRun{BrowserName: "firefox"}, "wrong")
+	assert.NotNil(t, err)
+
+	_, err = newRemoteSource("not a url", http.DefaultClient)
+	assert.NotNil(t, err)
+}
+
+func TestRemoteSource_PartialRun(t *testing.T) {
+	partial := wptdashboard.TestRun{ID: 3, BrowserName: "chrome", Revision: "aaaaaaaaaa", Paths: []string{"/css/"}}
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		testRuns := []wptdashboard.TestRun{}
+		if r.URL.Query().Get("partial") == "true" {
+			testRuns = append(testRuns, partial)
+		}
+		json.NewEncoder(w).Encode(testRuns)
+	}))
+	defer server.Close()
+	src, err := newRemoteSource(server.URL, http.DefaultClient)
+	assert.Nil(t, err)
+
+	// A specific revision without a full run falls back to its partial run, but 'latest' doesn't.
+	run, err := src.run(context.Background(), "chrome@aaaaaaaaaa")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), run.ID)
+	assert.Equal(t, []string{
+		"browsers=chrome&max-count=1&sha=aaaaaaaaaa",
+		"browsers=chrome&max-count=1&partial=true&sha=aaaaaaaaaa",
+	}, queries)
+
+	_, err = src.run(context.Background(), "chrome")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !appengine
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats, selected by the --format flag.
+const (
+	formatTable    = "table"
+	formatJSON     = "json"
+	formatMarkdown = "markdown"
+)
+
+// table is the tabular rendering of a command's output.
+type table struct {
+	headers []string
+	rows    [][]string
+}
+
+func (t *table) add(row ...string) {
+	t.rows = append(t.rows, row)
+}
+
+// output writes the results of commands in the selected format.
+type output struct {
+	w      io.Writer
+	format string
+}
+
+func newOutput(w io.Writer, format string) (*output, error) {
+	switch format {
+	case formatTable, formatJSON, formatMarkdown:
+		return &output{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("invalid format %s (must be %s, %s or %s)", format, formatTable, formatJSON, formatMarkdown)
+}
+
+// write emits v as (indented) JSON, or t as an aligned text table or a Markdown table.
+func (o *output) write(v interface{}, t table) error {
+	switch o.format {
+	case formatJSON:
+		bytes, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(o.w, "%s\n", bytes)
+		return err
+	case formatMarkdown:
+		return writeMarkdownTable(o.w, t)
+	}
+	return writeTextTable(o.w, t)
+}
+
+func writeTextTable(w io.Writer, t table) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.headers, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeMarkdownTable(w io.Writer, t table) error {
+	separators := make([]string, len(t.headers))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	lines := []string{markdownRow(t.headers), markdownRow(separators)}
+	for _, row := range t.rows {
+		lines = append(lines, markdownRow(row))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func markdownRow(cells []string) string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = strings.Replace(cell, "|", `\|`, -1)
+	}
+	return "| " + strings.Join(escaped, " | ") + " |"
+}
@@ -0,0 +1,304 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !appengine
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/w3c/wptdashboard"
+	"golang.org/x/net/context"
+)
+
+// summarySuffix is the suffix of run summary files in a results directory.
+const summarySuffix = "-summary.json.gz"
+
+// runsQuery selects runs, in the same way as the /api/runs params.
+type runsQuery struct {
+	// SHA is the SHA[0:10] of the runs, or "latest".
+	SHA string
+
+	// BrowserNames to include, or nil for the default browsers.
+	BrowserNames []string
+
+	// MaxCount is the maximum number of runs per browser.
+	MaxCount int
+
+	// Complete means 'latest' should resolve to the most recent revision with runs for all browsers.
+	Complete bool
+
+	// Partial means partial runs (see wptdashboard.TestRun.Paths) are included, as well as full runs.
+	Partial bool
+}
+
+// source provides runs and their results, from either a wptdashboard instance or a local results directory.
+type source interface {
+	// runs returns the runs matching the query, ordered by browser and then most recent first.
+	runs(ctx context.Context, query runsQuery) ([]wptdashboard.TestRun, error)
+
+	// run returns the run for a platform@revision spec (see wptdashboard.ParsePlatformAtRevision). Like /api/diff,
+	// a specific revision resolves to a partial run when it has no full run.
+	run(ctx context.Context, spec string) (wptdashboard.TestRun, error)
+
+	// summary loads the run's results summary.
+	summary(ctx context.Context, run wptdashboard.TestRun) (*wptdashboard.ResultsSummary, error)
+
+	// resultsStore returns the store of the runs' results files, i.e. their summaries and per-test results.
+	resultsStore() wptdashboard.ResultsStore
+
+	// upload creates a TestRun, returning it as stored.
+	upload(ctx context.Context, run wptdashboard.TestRun, secret string) (wptdashboard.TestRun, error)
+}
+
+// remoteSource is a source which uses the API of a wptdashboard instance.
+type remoteSource struct {
+	server *url.URL
+	client *http.Client
+}
+
+func newRemoteSource(server string, client *http.Client) (*remoteSource, error) {
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	} else if serverURL.Scheme == "" || serverURL.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %s", server)
+	}
+	return &remoteSource{server: serverURL, client: client}, nil
+}
+
+// apiURL returns the URL of the given API path on the server, with the given params.
+func (s *remoteSource) apiURL(apiPath string, params url.Values) string {
+	u := s.server.ResolveReference(&url.URL{Path: apiPath})
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// getJSON decodes the JSON response of a GET request for the URL into v.
+func (s *remoteSource) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned HTTP status %d: %s", u, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *remoteSource) runs(ctx context.Context, query runsQuery) (testRuns []wptdashboard.TestRun, err error) {
+	params := url.Values{}
+	params.Set("sha", query.SHA)
+	if query.BrowserNames != nil {
+		params.Set("browsers", strings.Join(query.BrowserNames, ","))
+	}
+	if query.MaxCount > 0 {
+		params.Set("max-count", strconv.Itoa(query.MaxCount))
+	}
+	if query.Complete {
+		params.Set("complete", "true")
+	}
+	if query.Partial {
+		params.Set("partial", "true")
+	}
+	err = s.getJSON(ctx, s.apiURL("/api/runs", params), &testRuns)
+	return testRuns, err
+}
+
+func (s *remoteSource) run(ctx context.Context, spec string) (wptdashboard.TestRun, error) {
+	platform, revision, err := wptdashboard.ParsePlatformAtRevision(spec)
+	if err != nil {
+		return wptdashboard.TestRun{}, err
+	}
+	query := runsQuery{SHA: revision, BrowserNames: []string{platform}, MaxCount: 1}
+	testRuns, err := s.runs(ctx, query)
+	if err == nil && len(testRuns) == 0 && revision != "latest" {
+		query.Partial = true
+		testRuns, err = s.runs(ctx, query)
+	}
+	if err != nil {
+		return wptdashboard.TestRun{}, err
+	} else if len(testRuns) == 0 {
+		return wptdashboard.TestRun{}, fmt.Errorf("no run found for %s", spec)
+	}
+	return testRuns[0], nil
+}
+
+func (s *remoteSource) summary(ctx context.Context, run wptdashboard.TestRun) (*wptdashboard.ResultsSummary, error) {
+	return wptdashboard.LoadResultsSummary(ctx, s.resultsStore(), run)
+}
+
+func (s *remoteSource) resultsStore() wptdashboard.ResultsStore {
+	return wptdashboard.NewHTTPResultsStore(s.client, s.server)
+}
+
+func (s *remoteSource) upload(ctx context.Context, run wptdashboard.TestRun, secret string) (
+	uploaded wptdashboard.TestRun, err error) {
+	err = s.postJSON(ctx, s.apiURL("/api/run", url.Values{"secret": []string{secret}}), run, &uploaded)
+	return uploaded, err
+}
+
+// postJSON POSTs v as JSON to the URL, decoding the JSON response into result.
+func (s *remoteSource) postJSON(ctx context.Context, u string, v interface{}, result interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload failed with HTTP status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+// localSource is a source which reads runs from a local results directory, laid out like the results bucket
+// (see wptdashboard.NewLocalResultsStore). Each <sha>/<platform>-summary.json.gz file is a run, created at the
+// file's modification time.
+type localSource struct {
+	dir string
+}
+
+// platform returns the platform of a local run, e.g. "chrome-63.0-linux".
+func (s *localSource) platform(run wptdashboard.TestRun) string {
+	return strings.TrimSuffix(path.Base(run.ResultsURL), summarySuffix)
+}
+
+// all returns every run in the directory, ordered by browser and then most recent first.
+func (s *localSource) all() ([]wptdashboard.TestRun, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*", "*"+summarySuffix))
+	if err != nil {
+		return nil, err
+	}
+	var testRuns []wptdashboard.TestRun
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		sha := filepath.Base(filepath.Dir(file))
+		name := filepath.Base(file)
+		run := wptdashboard.TestRun{
+			Revision:   sha,
+			ResultsURL: "/" + sha + "/" + name,
+			CreatedAt:  info.ModTime().UTC(),
+		}
+		// Platforms are browser[-version[-os[-version]]].
+		pieces := strings.SplitN(strings.TrimSuffix(name, summarySuffix), "-", 4)
+		for i, field := range []*string{&run.BrowserName, &run.BrowserVersion, &run.OSName, &run.OSVersion} {
+			if i < len(pieces) {
+				*field = pieces[i]
+			}
+		}
+		testRuns = append(testRuns, run)
+	}
+	sort.SliceStable(testRuns, func(i, j int) bool {
+		if testRuns[i].BrowserName != testRuns[j].BrowserName {
+			return testRuns[i].BrowserName < testRuns[j].BrowserName
+		}
+		return testRuns[i].CreatedAt.After(testRuns[j].CreatedAt)
+	})
+	return testRuns, nil
+}
+
+func (s *localSource) runs(ctx context.Context, query runsQuery) ([]wptdashboard.TestRun, error) {
+	if query.Complete {
+		return nil, errors.New("complete runs can only be queried from a server")
+	}
+	all, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+	include := make(map[string]bool)
+	for _, name := range query.BrowserNames {
+		include[name] = true
+	}
+	var testRuns []wptdashboard.TestRun
+	counts := make(map[string]int)
+	for _, run := range all {
+		if query.BrowserNames != nil && !include[run.BrowserName] {
+			continue
+		}
+		if query.SHA != "" && query.SHA != "latest" && run.Revision != query.SHA {
+			continue
+		}
+		if query.MaxCount > 0 && counts[run.BrowserName] >= query.MaxCount {
+			continue
+		}
+		counts[run.BrowserName]++
+		testRuns = append(testRuns, run)
+	}
+	return testRuns, nil
+}
+
+func (s *localSource) run(ctx context.Context, spec string) (wptdashboard.TestRun, error) {
+	platform, revision, err := wptdashboard.ParsePlatformAtRevision(spec)
+	if err != nil {
+		return wptdashboard.TestRun{}, err
+	}
+	all, err := s.all()
+	if err != nil {
+		return wptdashboard.TestRun{}, err
+	}
+	for _, run := range all {
+		if run.BrowserName != platform && s.platform(run) != platform {
+			continue
+		}
+		if revision == "latest" || run.Revision == revision {
+			return run, nil
+		}
+	}
+	return wptdashboard.TestRun{}, fmt.Errorf("no run found for %s in %s", spec, s.dir)
+}
+
+func (s *localSource) summary(ctx context.Context, run wptdashboard.TestRun) (*wptdashboard.ResultsSummary, error) {
+	return wptdashboard.LoadResultsSummary(ctx, s.resultsStore(), run)
+}
+
+func (s *localSource) resultsStore() wptdashboard.ResultsStore {
+	return wptdashboard.NewLocalResultsStore(s.dir)
+}
+
+func (s *localSource) upload(ctx context.Context, run wptdashboard.TestRun, secret string) (
+	wptdashboard.TestRun, error) {
+	return wptdashboard.TestRun{}, errors.New("runs can only be uploaded to a server")
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etagVersion is mixed into every ETag, and should be bumped whenever the format of the API responses changes,
+// so that clients don't keep using responses cached from a previous deployment.
+const etagVersion = "1"
+
+// pinnedCacheControl is the Cache-Control for responses which only depend on an explicitly requested run ID, and
+// can't change, e.g. the run's computed changes. Responses for a revision aren't pinned, since runs of other
+// browsers (or re-runs) at that revision can still arrive, and neither are responses emitting runs themselves, since
+// artifacts can be attached to a run at any time (see addRunArtifact).
+const pinnedCacheControl = "public, max-age=86400"
+
+// unpinnedCacheControl is the Cache-Control for responses which resolve runs from a revision (including 'latest'
+// and complete runs); clients may store them, but must revalidate (cheaply, via If-None-Match) before each use.
+const unpinnedCacheControl = "no-cache"
+
+// computeRunsETag returns a strong ETag for a response built from the given runs (by ID, whether their changes
+// have been computed, their artifacts, and the status and progress of runs which aren't complete) and any other
+// request-dependent parameters which affect the response body (e.g. a diff filter). For 'latest' requests the ETag
+// therefore only changes when a new run is resolved, processed, reports progress or has an artifact uploaded.
+func computeRunsETag(runs []TestRun, extra ...string) string {
+	hash := sha1.New()
+	io.WriteString(hash, etagVersion)
+	for _, run := range runs {
+		io.WriteString(hash, "\x00run:"+strconv.FormatInt(run.ID, 10))
+		if run.Changes.Computed {
+			io.WriteString(hash, ":changes")
+		}
+		for _, artifact := range run.Artifacts {
+			io.WriteString(hash, fmt.Sprintf(":artifact:%s:%d", artifact.Name, artifact.UploadedAt.UnixNano()))
+		}
+		if !run.IsComplete() {
+			io.WriteString(hash, fmt.Sprintf(":%s:%d", run.Status, run.Progress.UpdatedAt.UnixNano()))
+		}
+	}
+	for _, e := range extra {
+		io.WriteString(hash, "\x00"+e)
+	}
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// checkETag sets the ETag and Cache-Control headers on the response. If the request's If-None-Match header matches
+// the ETag, it writes a 304 Not Modified response and returns true, in which case the caller should not write a body.
+func checkETag(w http.ResponseWriter, r *http.Request, etag string, pinned bool) (notModified bool) {
+	w.Header().Set("ETag", etag)
+	if pinned {
+		w.Header().Set("Cache-Control", pinnedCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", unpinnedCacheControl)
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagMatches determines whether the given If-None-Match header value (a comma-separated list of entity tags, or
+// '*') matches the ETag. As per RFC 7232, If-None-Match uses the weak comparison function.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeRunsETag(t *testing.T) {
+	runs := []TestRun{{ID: 1}, {ID: 2}}
+	etag := computeRunsETag(runs)
+	assert.Equal(t, etag, computeRunsETag([]TestRun{{ID: 1, Revision: "abcdef0123"}, {ID: 2}}))
+	assert.NotEqual(t, etag, computeRunsETag([]TestRun{{ID: 1}, {ID: 3}}))
+	assert.NotEqual(t, etag, computeRunsETag(runs, "filter=A"))
+	assert.NotEqual(t, etag, computeRunsETag([]TestRun{{ID: 1, Changes: RunChanges{Computed: true}}, {ID: 2}}))
+
+	running := TestRun{ID: 1, Status: RunStatusRunning}
+	progressed := running
+	progressed.Progress.UpdatedAt = time.Unix(1000, 0)
+	assert.NotEqual(t, etag, computeRunsETag([]TestRun{running, {ID: 2}}))
+	assert.NotEqual(t, computeRunsETag([]TestRun{running}), computeRunsETag([]TestRun{progressed}))
+	assert.Equal(t, etag, computeRunsETag([]TestRun{{ID: 1, Status: RunStatusComplete}, {ID: 2}}))
+	assert.Regexp(t, `^"[0-9a-f]{40}"$`, etag)
+}
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `"abc"`
+	assert.False(t, etagMatches("", etag))
+	assert.True(t, etagMatches(`"abc"`, etag))
+	assert.True(t, etagMatches(`W/"abc"`, etag))
+	assert.True(t, etagMatches(`"xyz", "abc"`, etag))
+	assert.True(t, etagMatches(`*`, etag))
+	assert.False(t, etagMatches(`"xyz"`, etag))
+	assert.False(t, etagMatches(`abc`, etag))
+}
+
+func TestCheckETag_NotModified(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs", nil)
+	r.Header.Set("If-None-Match", `"abc"`)
+	w := httptest.NewRecorder()
+	assert.True(t, checkETag(w, r, `"abc"`, false))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+	assert.Equal(t, unpinnedCacheControl, w.Header().Get("Cache-Control"))
+}
+
+func TestCheckETag_Modified(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs?run_ids=1", nil)
+	r.Header.Set("If-None-Match", `"xyz"`)
+	w := httptest.NewRecorder()
+	assert.False(t, checkETag(w, r, `"abc"`, true))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+	assert.Equal(t, pinnedCacheControl, w.Header().Get("Cache-Control"))
+}
@@ -0,0 +1,556 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Formats of browsers' own expectations, i.e. the values of the 'format' param of the expectations endpoints.
+const (
+	// ExpectationsFormatChromium is Chromium's TestExpectations file format.
+	ExpectationsFormatChromium = "chromium"
+
+	// ExpectationsFormatGecko is Gecko's per-test .ini metadata format (testing/web-platform/meta).
+	ExpectationsFormatGecko = "gecko"
+)
+
+// ExpectationsFormats are all of the supported expectations formats.
+var ExpectationsFormats = []string{ExpectationsFormatChromium, ExpectationsFormatGecko}
+
+// chromiumWPTDir is the directory of Chromium's layout tests which WPT is imported into.
+const chromiumWPTDir = "external/wpt/"
+
+// geckoDirMetadataFile is the name of Gecko's metadata files for all of the tests under a directory.
+const geckoDirMetadataFile = "__dir__.ini"
+
+// expectationsFetchParallelism is the maximum number of results files fetched concurrently while generating
+// expectations.
+const expectationsFetchParallelism = archiveFetchParallelism
+
+// maxExpectationsTests is the maximum number of failing tests /api/expectations generates the expectations of, since
+// it fetches each of their results files within the request's deadline. Expectations for more tests (e.g. a whole
+// run) are generated by wptd expectations --generate.
+const maxExpectationsTests = 1000
+
+// chromiumResults maps Chromium's expectations onto wptreport statuses. Others (e.g. Slow) don't change the
+// expected result.
+var chromiumResults = map[string]string{
+	"Pass":    "PASS",
+	"Failure": "FAIL",
+	"Timeout": "TIMEOUT",
+	"Crash":   "CRASH",
+	"Skip":    "SKIP",
+	"WontFix": "SKIP",
+}
+
+// chromiumBugPrefixes are the prefixes of the bugs which TestExpectations lines start with.
+var chromiumBugPrefixes = []string{"crbug.com/", "skbug.com/", "webkit.org/b/", "Bug(", "http://", "https://"}
+
+// Expectation is a browser's expected results of a WPT test (or of all the tests under a directory), or of one of
+// the test's subtests, as recorded in the browser's own expectations format.
+type Expectation struct {
+	// Test is the path of the test, e.g. "/dom/a.html", or of a directory ending in "/".
+	Test    string `json:"test"`
+	Subtest string `json:"subtest,omitempty"`
+
+	// Results are the expected statuses, as in wptreports (e.g. PASS, FAIL, TIMEOUT), or SKIP if it isn't run.
+	Results []string `json:"results"`
+
+	// Conditional expectations only apply to some configurations of the browser, e.g. some operating systems.
+	Conditional bool `json:"conditional,omitempty"`
+
+	Bugs []string `json:"bugs,omitempty"`
+}
+
+// expectsFailure determines whether the expectation is that the test (or subtest) doesn't pass, rather than that it
+// passes (at least intermittently) or isn't run.
+func (expectation Expectation) expectsFailure() bool {
+	for _, result := range expectation.Results {
+		if result == "PASS" || result == "OK" || result == "SKIP" {
+			return false
+		}
+	}
+	return len(expectation.Results) > 0
+}
+
+// covers determines whether the expectation applies to (any part of) the given test.
+func (expectation Expectation) covers(test string) bool {
+	if strings.HasSuffix(expectation.Test, "/") {
+		return strings.HasPrefix(test, expectation.Test)
+	}
+	return test == expectation.Test
+}
+
+// addResult adds a result to the expectation, unless it's already expected.
+func (expectation *Expectation) addResult(result string) {
+	if !containsString(expectation.Results, result) {
+		expectation.Results = append(expectation.Results, result)
+	}
+}
+
+// ParseChromiumExpectations parses a Chromium TestExpectations file, e.g.
+//     crbug.com/123 [ Linux Mac ] external/wpt/dom/a.html [ Failure Timeout ]
+// Only the lines for WPT tests (under external/wpt) are returned, with their paths relative to WPT. Lines with
+// modifiers (e.g. platforms) are conditional.
+func ParseChromiumExpectations(r io.Reader) ([]Expectation, error) {
+	var expectations []Expectation
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var expectation Expectation
+		i := 0
+		for ; i < len(fields) && hasAnyPrefix(fields[i], chromiumBugPrefixes); i++ {
+			expectation.Bugs = append(expectation.Bugs, fields[i])
+		}
+		if i < len(fields) && fields[i] == "[" {
+			end := indexOfString(fields[i:], "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated modifiers", lineNumber)
+			}
+			expectation.Conditional = end > 1
+			i += end + 1
+		}
+		if i >= len(fields) {
+			return nil, fmt.Errorf("line %d: missing test", lineNumber)
+		}
+		test := fields[i]
+		results := fields[i+1:]
+		if len(results) < 2 || results[0] != "[" || results[len(results)-1] != "]" {
+			return nil, fmt.Errorf("line %d: missing expectations", lineNumber)
+		}
+		if !strings.HasPrefix(test, chromiumWPTDir) {
+			continue
+		}
+
+		expectation.Test = "/" + strings.TrimPrefix(test, chromiumWPTDir)
+		for _, result := range results[1 : len(results)-1] {
+			if status, ok := chromiumResults[result]; ok {
+				expectation.addResult(status)
+			}
+		}
+		if len(expectation.Results) == 0 {
+			// e.g. [ Slow ], which is still expected to pass.
+			expectation.Results = []string{"PASS"}
+		}
+		expectations = append(expectations, expectation)
+	}
+	return expectations, scanner.Err()
+}
+
+// ParseGeckoMetadata parses one of Gecko's .ini metadata files, given its path relative to the metadata root
+// (testing/web-platform/meta), e.g. "dom/a.html.ini", or "dom/__dir__.ini" for all of the tests under /dom/. Tests'
+// and subtests' 'expected' statuses (which may be conditional, or lists of intermittent statuses) and 'disabled'
+// keys (as SKIP) are returned, along with their bugs.
+func ParseGeckoMetadata(metadataPath string, r io.Reader) ([]Expectation, error) {
+	metadataPath = strings.TrimPrefix(path.Clean("/"+metadataPath), "/")
+	dir := "/"
+	if d := path.Dir(metadataPath); d != "." {
+		dir = "/" + d + "/"
+	}
+
+	var expectations []Expectation
+	// Indices of the expectations of the current test, and of the current test or subtest.
+	test, current := -1, -1
+	subtestIndent := -1
+	// The key whose values are on the following (more indented) lines, if any.
+	pendingKey, pendingIndent := "", -1
+
+	// Keys outside of any section (i.e. in __dir__.ini files) apply to the whole directory.
+	if path.Base(metadataPath) == geckoDirMetadataFile {
+		expectations = append(expectations, Expectation{Test: dir})
+		test, current = 0, 0
+	} else if !strings.HasSuffix(metadataPath, ".ini") {
+		return nil, fmt.Errorf("invalid metadata file %s", metadataPath)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if pendingKey != "" && indent > pendingIndent {
+			value, conditional := trimmed, false
+			if strings.HasPrefix(trimmed, "if ") {
+				i := strings.LastIndex(trimmed, ":")
+				if i < 0 {
+					return nil, fmt.Errorf("line %d: invalid condition", lineNumber)
+				}
+				value, conditional = trimmed[i+1:], true
+			}
+			expectations[current].Conditional = expectations[current].Conditional || conditional
+			addGeckoValue(&expectations[current], pendingKey, value)
+			continue
+		}
+		pendingKey = ""
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			name := unescapeGeckoHeading(trimmed[1 : len(trimmed)-1])
+			if indent == 0 {
+				expectations = append(expectations, Expectation{Test: dir + name})
+				test, subtestIndent = len(expectations)-1, -1
+			} else if test < 0 || strings.HasSuffix(expectations[test].Test, "/") {
+				return nil, fmt.Errorf("line %d: subtest %s outside of a test", lineNumber, name)
+			} else {
+				expectations = append(expectations, Expectation{Test: expectations[test].Test, Subtest: name})
+				subtestIndent = indent
+			}
+			current = len(expectations) - 1
+			continue
+		}
+
+		i := strings.Index(trimmed, ":")
+		if i < 0 || current < 0 {
+			return nil, fmt.Errorf("line %d: expected a key: value or [heading]", lineNumber)
+		}
+		if subtestIndent >= 0 && indent <= subtestIndent {
+			// Back to the test's own keys.
+			current, subtestIndent = test, -1
+		}
+		key, value := strings.TrimSpace(trimmed[:i]), strings.TrimSpace(trimmed[i+1:])
+		if value == "" {
+			pendingKey, pendingIndent = key, indent
+			continue
+		}
+		addGeckoValue(&expectations[current], key, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only keep the tests and subtests with expectations.
+	var kept []Expectation
+	for _, expectation := range expectations {
+		if len(expectation.Results) > 0 {
+			kept = append(kept, expectation)
+		}
+	}
+	return kept, nil
+}
+
+// addGeckoValue records the value of a key of a Gecko metadata section on its expectation.
+func addGeckoValue(expectation *Expectation, key string, value string) {
+	value = strings.TrimSpace(value)
+	switch key {
+	case "expected":
+		for _, status := range splitGeckoList(value) {
+			expectation.addResult(status)
+		}
+	case "disabled":
+		if value != "@False" {
+			expectation.addResult("SKIP")
+		}
+	case "bug", "bugs":
+		for _, bug := range splitGeckoList(value) {
+			if !containsString(expectation.Bugs, bug) {
+				expectation.Bugs = append(expectation.Bugs, bug)
+			}
+		}
+	}
+}
+
+// splitGeckoList splits a Gecko metadata value which may be a list, e.g. "[PASS, FAIL]", into its items.
+func splitGeckoList(value string) []string {
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		value = value[1 : len(value)-1]
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), `"'`); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// escapeGeckoHeading escapes a test or subtest name for use as a Gecko metadata [heading].
+func escapeGeckoHeading(name string) string {
+	return strings.NewReplacer(`\`, `\\`, `]`, `\]`).Replace(name)
+}
+
+// unescapeGeckoHeading reverses escapeGeckoHeading.
+func unescapeGeckoHeading(heading string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(heading); i++ {
+		if heading[i] == '\\' && i+1 < len(heading) {
+			i++
+		}
+		buf.WriteByte(heading[i])
+	}
+	return buf.String()
+}
+
+// ExpectationsComparison is the comparison of a browser's expectations with the results of one of its runs.
+type ExpectationsComparison struct {
+	// Expectations is the number of expectations compared.
+	Expectations int `json:"expectations"`
+
+	// Stale expectations are (unconditional) expectations of tests or subtests failing, whose tests now pass.
+	Stale []Expectation `json:"stale"`
+
+	// Unexpected failures are the tests which didn't all pass, with their [passed, total] counts, that aren't
+	// covered by any expectation.
+	Unexpected *ResultsSummary `json:"unexpected"`
+}
+
+// CompareExpectations compares a browser's expectations with the results summary of one of its runs. Summaries
+// don't include subtests, so an expectation of a subtest failing is only stale once all of the test passes.
+func CompareExpectations(expectations []Expectation, summary *ResultsSummary) ExpectationsComparison {
+	comparison := ExpectationsComparison{
+		Expectations: len(expectations),
+		Stale:        []Expectation{},
+		Unexpected:   &ResultsSummary{},
+	}
+	covered := make(map[string]bool)
+	var dirs []string
+	for _, expectation := range expectations {
+		if strings.HasSuffix(expectation.Test, "/") {
+			dirs = append(dirs, expectation.Test)
+			continue
+		}
+		covered[expectation.Test] = true
+		if expectation.Conditional || !expectation.expectsFailure() {
+			continue
+		}
+		if counts, ok := summary.Get(expectation.Test); ok && counts[1] > 0 && counts[0] == counts[1] {
+			comparison.Stale = append(comparison.Stale, expectation)
+		}
+	}
+
+	for i := 0; i < summary.Len(); i++ {
+		test, counts := summary.At(i)
+		if counts[0] == counts[1] || covered[test] || hasAnyPrefix(test, dirs) {
+			continue
+		}
+		comparison.Unexpected.add(test, counts)
+	}
+	return comparison
+}
+
+// isUnexpectedStatus determines whether a status of a test or subtest is one which needs an expectation.
+func isUnexpectedStatus(status string) bool {
+	return status != "PASS" && status != "OK"
+}
+
+// hasUnexpectedStatus determines whether the result, or any of its subtests, needs an expectation.
+func hasUnexpectedStatus(result WPTReportResult) bool {
+	if isUnexpectedStatus(result.Status) {
+		return true
+	}
+	for _, subtest := range result.Subtests {
+		if isUnexpectedStatus(subtest.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteChromiumExpectations writes TestExpectations lines expecting the results which didn't pass, e.g.
+//     external/wpt/dom/a.html [ Timeout ]
+// Chromium only has expectations of whole tests, so tests with failing subtests are expected to fail.
+func WriteChromiumExpectations(w io.Writer, results []WPTReportResult) error {
+	for _, result := range results {
+		if !hasUnexpectedStatus(result) {
+			continue
+		}
+		expected := "Failure"
+		switch result.Status {
+		case "TIMEOUT":
+			expected = "Timeout"
+		case "CRASH":
+			expected = "Crash"
+		}
+		test := chromiumWPTDir + strings.TrimPrefix(result.Test, "/")
+		if _, err := fmt.Fprintf(w, "%s [ %s ]\n", test, expected); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateGeckoMetadata returns the Gecko .ini metadata files, keyed by their paths relative to the metadata root,
+// expecting the statuses of the tests and subtests which didn't pass.
+func GenerateGeckoMetadata(results []WPTReportResult) map[string][]byte {
+	files := make(map[string]*bytes.Buffer)
+	for _, result := range results {
+		if !hasUnexpectedStatus(result) {
+			continue
+		}
+		// Variants of a test, e.g. a.html?1-10, share the test file's metadata file.
+		testFile, variant := result.Test, ""
+		if i := strings.IndexAny(testFile, "?#"); i >= 0 {
+			testFile, variant = testFile[:i], testFile[i:]
+		}
+		file := strings.TrimPrefix(testFile, "/") + ".ini"
+		buf, ok := files[file]
+		if !ok {
+			buf = &bytes.Buffer{}
+			files[file] = buf
+		}
+
+		fmt.Fprintf(buf, "[%s]\n", escapeGeckoHeading(path.Base(testFile)+variant))
+		if isUnexpectedStatus(result.Status) {
+			fmt.Fprintf(buf, "  expected: %s\n", result.Status)
+		}
+		for _, subtest := range result.Subtests {
+			if isUnexpectedStatus(subtest.Status) {
+				fmt.Fprintf(buf, "  [%s]\n    expected: %s\n", escapeGeckoHeading(subtest.Name), subtest.Status)
+			}
+		}
+	}
+
+	generated := make(map[string][]byte, len(files))
+	for file, buf := range files {
+		generated[file] = buf.Bytes()
+	}
+	return generated
+}
+
+// getFailingTests returns the tests in the summary which didn't all pass, in test order.
+func getFailingTests(summary *ResultsSummary) []string {
+	var failing []string
+	for i := 0; i < summary.Len(); i++ {
+		if test, counts := summary.At(i); counts[0] < counts[1] {
+			failing = append(failing, test)
+		}
+	}
+	return failing
+}
+
+// LoadFailingResults loads the results files of the run's tests which didn't all pass (according to the summary),
+// in test order. Tests without a results file are omitted.
+func LoadFailingResults(ctx context.Context, store ResultsStore, run TestRun, summary *ResultsSummary) (
+	[]WPTReportResult, error) {
+	failing := getFailingTests(summary)
+	var results []WPTReportResult
+	fetch := func(ctx context.Context, i int) ([]byte, error) {
+		return readResultsFile(ctx, store, run, failing[i])
+	}
+	emit := func(i int, data []byte) error {
+		if data == nil {
+			return nil
+		}
+		var result WPTReportResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return fmt.Errorf("failed to parse results of %s: %s", failing[i], err.Error())
+		}
+		// Results files don't necessarily include the test's path.
+		result.Test = failing[i]
+		results = append(results, result)
+		return nil
+	}
+	err := runOrdered(ctx, len(failing), expectationsFetchParallelism, fetch, emit)
+	return results, err
+}
+
+// ParseGeckoMetadataArchive parses the .ini files of a (optionally gzipped) tar of Gecko's metadata, with paths
+// relative to the metadata root (see ParseGeckoMetadata). Other files are ignored.
+func ParseGeckoMetadataArchive(r io.Reader) ([]Expectation, error) {
+	rc, err := maybeGunzip(ioutil.NopCloser(r))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var expectations []Expectation
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return expectations, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".ini") {
+			continue
+		}
+		parsed, err := ParseGeckoMetadata(header.Name, tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", header.Name, err.Error())
+		}
+		expectations = append(expectations, parsed...)
+	}
+}
+
+// writeGeckoMetadataArchive writes a tar.gz of the metadata files (see GenerateGeckoMetadata), in path order.
+func writeGeckoMetadataArchive(w io.Writer, files map[string][]byte, modTime time.Time) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: modTime}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// hasAnyPrefix determines whether s starts with any of the prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexOfString returns the index of the first occurrence of value in values, or -1.
+func indexOfString(values []string, value string) int {
+	for i := range values {
+		if values[i] == value {
+			return i
+		}
+	}
+	return -1
+}
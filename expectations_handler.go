@@ -0,0 +1,160 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/appengine"
+)
+
+// apiExpectationsHandler generates expectation files, in a browser's own format, from the results of a run: the
+// expected statuses of its tests (and subtests) under a path which didn't pass, read from their results files.
+// Chromium expectations are emitted as a TestExpectations file, and Gecko's as a tar.gz of .ini metadata files.
+// Since the results files are fetched within the request, at most maxExpectationsTests tests are generated.
+//
+// URL Params:
+//     run: platform@SHA[0:10] of the run, e.g. chrome@abcdef0123, or just the platform for the latest run
+//     run_id: (optional) ID of the run, instead of the run param
+//     format: 'chromium' or 'gecko'
+//     path: Path of the directory (ending in /) or test to generate the expectations of, e.g. /dom/
+func apiExpectationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "This endpoint only supports GET.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := appengine.NewContext(r)
+	format, err := parseExpectationsFormatParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A scope of / (the whole run) normalizes to nil.
+	scopes, err := normalizeRunPaths([]string{r.URL.Query().Get("path")})
+	if err != nil || len(scopes) == 0 {
+		http.Error(w, "Invalid 'path' param (must be a directory or test under /)", http.StatusBadRequest)
+		return
+	}
+	scope := scopes[0]
+	run, ok := loadRunSpecParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	// Only pinned for a run_id, since the run param can resolve to a newer run (see pinnedCacheControl).
+	pinned := r.URL.Query().Get("run_id") != ""
+	if checkETag(w, r, computeRunsETag([]TestRun{run}, "expectations="+format, "path="+scope), pinned) {
+		return
+	}
+
+	summary, err := fetchRunResultsSummary(ctx, r, run)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	summary = summary.Filter(func(test string) bool { return pathCovers(scope, test) })
+	if failing := len(getFailingTests(summary)); failing > maxExpectationsTests {
+		http.Error(w, fmt.Sprintf("%d tests under %s didn't pass, more than the %d which expectations can be "+
+			"generated for here; narrow the path, or use wptd expectations --generate", failing, scope,
+			maxExpectationsTests), http.StatusBadRequest)
+		return
+	}
+	results, err := LoadFailingResults(ctx, getResultsStore(ctx, r), run, summary)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Generate the whole file first, so that failures can still be reported.
+	var buf bytes.Buffer
+	name := fmt.Sprintf("%s-%s", getResultsPlatform(run), run.Revision)
+	if format == ExpectationsFormatChromium {
+		fmt.Fprintf(&buf, "# Generated from the results of run %d (%s@%s).\n", run.ID, run.BrowserName, run.Revision)
+		err = WriteChromiumExpectations(&buf, results)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		name += "-TestExpectations"
+	} else {
+		err = writeGeckoMetadataArchive(&buf, GenerateGeckoMetadata(results), run.CreatedAt)
+		w.Header().Set("Content-Type", "application/gzip")
+		name += "-meta.tar.gz"
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	w.Write(buf.Bytes())
+}
+
+// apiExpectationsCompareHandler compares a browser's expectations, in the body of a POST request, with the results
+// of one of its runs (see CompareExpectations), emitting the stale expectations and the unexpected failures. The
+// body is a Chromium TestExpectations file, or a tar (optionally gzipped) of Gecko's .ini metadata files, with
+// paths relative to the metadata root.
+//
+// URL Params:
+//     run: platform@SHA[0:10] of the run, e.g. chrome@abcdef0123, or just the platform for the latest run
+//     run_id: (optional) ID of the run, instead of the run param
+//     format: 'chromium' or 'gecko'
+func apiExpectationsCompareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := appengine.NewContext(r)
+	format, err := parseExpectationsFormatParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	run, ok := loadRunSpecParam(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	var expectations []Expectation
+	if format == ExpectationsFormatChromium {
+		expectations, err = ParseChromiumExpectations(r.Body)
+	} else {
+		expectations, err = ParseGeckoMetadataArchive(r.Body)
+	}
+	if err != nil {
+		http.Error(w, "Failed to parse expectations: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	summary, err := fetchRunResultsSummary(ctx, r, run)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	comparisonBytes, err := json.Marshal(CompareExpectations(expectations, summary))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(comparisonBytes)
+}
+
+// parseExpectationsFormatParam parses the 'format' param, which is required, as one of ExpectationsFormats.
+func parseExpectationsFormatParam(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if !containsString(ExpectationsFormats, format) {
+		return "", fmt.Errorf("invalid format '%s' (must be one of %s)", format, strings.Join(ExpectationsFormats, ", "))
+	}
+	return format, nil
+}
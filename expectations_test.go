@@ -0,0 +1,198 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestParseChromiumExpectations(t *testing.T) {
+	expectations, err := ParseChromiumExpectations(strings.NewReader(`# tags: [ Linux Mac Win ]
+crbug.com/123 [ Linux ] external/wpt/dom/a.html [ Failure Timeout ]
+crbug.com/1 crbug.com/2 external/wpt/dom/events/ [ Skip ]
+
+external/wpt/html/b.html [ Slow ] # A comment
+fast/dom/c.html [ Failure ]
+external/wpt/css/d.html [ Pass Failure ]
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Expectation{
+		{Test: "/dom/a.html", Results: []string{"FAIL", "TIMEOUT"}, Conditional: true, Bugs: []string{"crbug.com/123"}},
+		{Test: "/dom/events/", Results: []string{"SKIP"}, Bugs: []string{"crbug.com/1", "crbug.com/2"}},
+		{Test: "/html/b.html", Results: []string{"PASS"}},
+		{Test: "/css/d.html", Results: []string{"PASS", "FAIL"}},
+	}, expectations)
+
+	for _, invalid := range []string{
+		"external/wpt/dom/a.html",
+		"crbug.com/1 [ Linux external/wpt/dom/a.html",
+		"crbug.com/1 [ Linux ]",
+	} {
+		_, err = ParseChromiumExpectations(strings.NewReader(invalid))
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParseGeckoMetadata(t *testing.T) {
+	expectations, err := ParseGeckoMetadata("dom/a.html.ini", strings.NewReader(`[a.html]
+  expected: TIMEOUT
+  bug: 1234
+  [First \] subtest]
+    expected:
+      if os == "linux": FAIL
+      PASS
+
+  [Second subtest]
+    expected: [PASS, FAIL]
+
+  [Third subtest]
+    bug: https://bugzil.la/1
+
+[a.html?variant]
+  disabled: Crashes the browser
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Expectation{
+		{Test: "/dom/a.html", Results: []string{"TIMEOUT"}, Bugs: []string{"1234"}},
+		{Test: "/dom/a.html", Subtest: "First ] subtest", Results: []string{"FAIL", "PASS"}, Conditional: true},
+		{Test: "/dom/a.html", Subtest: "Second subtest", Results: []string{"PASS", "FAIL"}},
+		{Test: "/dom/a.html?variant", Results: []string{"SKIP"}},
+	}, expectations)
+
+	expectations, err = ParseGeckoMetadata("dom/events/__dir__.ini", strings.NewReader(`disabled:
+  if debug: slow
+lsan-allowed: [Alloc]
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Expectation{{Test: "/dom/events/", Results: []string{"SKIP"}, Conditional: true}}, expectations)
+
+	_, err = ParseGeckoMetadata("a.html.ini", strings.NewReader("expected: FAIL\n"))
+	assert.NotNil(t, err)
+	_, err = ParseGeckoMetadata("a.html", strings.NewReader(""))
+	assert.NotNil(t, err)
+}
+
+func TestCompareExpectations(t *testing.T) {
+	summary := NewResultsSummary(map[string][]int{
+		"/dom/a.html":        {3, 3},
+		"/dom/b.html":        {1, 3},
+		"/dom/c.html":        {0, 1},
+		"/dom/events/d.html": {0, 2},
+		"/html/e.html":       {2, 2},
+	})
+	expectations := []Expectation{
+		{Test: "/dom/a.html", Subtest: "First subtest", Results: []string{"FAIL"}},
+		{Test: "/dom/b.html", Results: []string{"FAIL"}},
+		{Test: "/dom/events/", Results: []string{"SKIP"}},
+		{Test: "/html/e.html", Results: []string{"FAIL"}, Conditional: true},
+		{Test: "/html/f.html", Results: []string{"FAIL"}},
+	}
+	comparison := CompareExpectations(expectations, summary)
+	assert.Equal(t, 5, comparison.Expectations)
+	assert.Equal(t, []Expectation{expectations[0]}, comparison.Stale)
+	assert.Equal(t, map[string][]int{"/dom/c.html": {0, 1}}, comparison.Unexpected.ToMap())
+}
+
+func TestGenerateExpectations(t *testing.T) {
+	results := []WPTReportResult{
+		{Test: "/dom/a.html", Status: "OK", Subtests: []WPTReportSubtest{
+			{Name: "Passes", Status: "PASS"},
+			{Name: "Fails]", Status: "FAIL"},
+		}},
+		{Test: "/dom/a.html?variant", Status: "TIMEOUT"},
+		{Test: "/dom/b.html", Status: "OK"},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteChromiumExpectations(&buf, results))
+	assert.Equal(t, "external/wpt/dom/a.html [ Failure ]\nexternal/wpt/dom/a.html?variant [ Timeout ]\n", buf.String())
+
+	files := GenerateGeckoMetadata(results)
+	assert.Len(t, files, 1)
+	assert.Equal(t, `[a.html]
+  [Fails\]]
+    expected: FAIL
+[a.html?variant]
+  expected: TIMEOUT
+`, string(files["dom/a.html.ini"]))
+
+	// Generated metadata parses as the expected statuses.
+	parsed, err := ParseGeckoMetadata("dom/a.html.ini", bytes.NewReader(files["dom/a.html.ini"]))
+	assert.Nil(t, err)
+	assert.Equal(t, []Expectation{
+		{Test: "/dom/a.html", Subtest: "Fails]", Results: []string{"FAIL"}},
+		{Test: "/dom/a.html?variant", Results: []string{"TIMEOUT"}},
+	}, parsed)
+}
+
+func TestGeckoMetadataArchive(t *testing.T) {
+	files := map[string][]byte{
+		"dom/a.html.ini":  []byte("[a.html]\n  expected: ERROR\n"),
+		"css/__dir__.ini": []byte("disabled: true\n"),
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, writeGeckoMetadataArchive(&buf, files, time.Now()))
+
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	header, err := tar.NewReader(zr).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "css/__dir__.ini", header.Name)
+
+	expectations, err := ParseGeckoMetadataArchive(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, []Expectation{
+		{Test: "/css/", Results: []string{"SKIP"}},
+		{Test: "/dom/a.html", Results: []string{"ERROR"}},
+	}, expectations)
+}
+
+func TestLoadFailingResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expectations")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	resultsDir := filepath.Join(dir, "abcdef0123", "chrome-63.0-linux", "dom")
+	assert.Nil(t, os.MkdirAll(resultsDir, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(resultsDir, "b.html"),
+		[]byte(`{"status": "OK", "subtests": [{"name": "Fails", "status": "FAIL"}]}`), 0644))
+
+	run := TestRun{
+		Revision:   "abcdef0123",
+		ResultsURL: "https://storage.googleapis.com/wptd/abcdef0123/chrome-63.0-linux-summary.json.gz",
+	}
+	summary := NewResultsSummary(map[string][]int{
+		"/dom/a.html": {1, 1},
+		"/dom/b.html": {1, 2},
+		"/dom/c.html": {0, 1},
+	})
+	results, err := LoadFailingResults(context.Background(), localResultsStore{dir: dir}, run, summary)
+	assert.Nil(t, err)
+	assert.Equal(t, []WPTReportResult{{
+		Test:     "/dom/b.html",
+		Status:   "OK",
+		Subtests: []WPTReportSubtest{{Name: "Fails", Status: "FAIL"}},
+	}}, results)
+}
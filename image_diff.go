@@ -0,0 +1,119 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"image"
+	"image/color"
+)
+
+// diffColor marks the differing pixels in a diff image.
+var diffColor = color.NRGBA{R: 255, A: 255}
+
+// ImageDiff is the result of comparing two images, e.g. the screenshots of a reftest and its reference.
+type ImageDiff struct {
+	// Image is the first image, faded, with the differing pixels marked in red. It spans both images' bounds.
+	Image *image.NRGBA `json:"-"`
+
+	// DifferingPixels is the number of pixels which differ by more than the tolerance, including those within the
+	// bounds of only one of the images.
+	DifferingPixels int `json:"differing_pixels"`
+
+	// MaxDifference is the largest difference in any color channel of any pixel (0-255), as in reftest fuzzy
+	// matching.
+	MaxDifference int `json:"max_difference"`
+
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// DiffImages compares the images pixel by pixel. Pixels differ when any of their (non-premultiplied) color
+// channels differ by more than the tolerance.
+func DiffImages(a, b image.Image, tolerance int) ImageDiff {
+	bounds := a.Bounds().Union(b.Bounds())
+	diff := ImageDiff{
+		Image:  image.NewNRGBA(bounds),
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+	atA, atB := pixelReader(a), pixelReader(b)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := image.Pt(x, y)
+			inA, inB := p.In(a.Bounds()), p.In(b.Bounds())
+			if !inA || !inB {
+				diff.DifferingPixels++
+				diff.MaxDifference = 255
+				diff.Image.SetNRGBA(x, y, diffColor)
+				continue
+			}
+			ca, cb := atA(x, y), atB(x, y)
+			d := channelDifference(ca, cb)
+			diff.MaxDifference = max(diff.MaxDifference, d)
+			if d > tolerance {
+				diff.DifferingPixels++
+				diff.Image.SetNRGBA(x, y, diffColor)
+			} else {
+				diff.Image.SetNRGBA(x, y, fade(ca))
+			}
+		}
+	}
+	return diff
+}
+
+// pixelReader returns a function which reads the (non-premultiplied) color of a pixel of the image. The pixels of
+// the types which PNGs usually decode to are read from their buffers directly, since converting the color.Color
+// returned by At allocates for every pixel.
+func pixelReader(img image.Image) func(x, y int) color.NRGBA {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		return func(x, y int) color.NRGBA {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+4 : i+4]
+			return color.NRGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+		}
+	case *image.RGBA:
+		return func(x, y int) color.NRGBA {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+4 : i+4]
+			c := color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+			if c.A == 0xff {
+				// Opaque, so already non-premultiplied.
+				return color.NRGBA(c)
+			}
+			return color.NRGBAModel.Convert(c).(color.NRGBA)
+		}
+	}
+	return func(x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+}
+
+// channelDifference returns the largest difference between any of the colors' channels.
+func channelDifference(a, b color.NRGBA) int {
+	d := abs(int(a.R) - int(b.R))
+	d = max(d, abs(int(a.G)-int(b.G)))
+	d = max(d, abs(int(a.B)-int(b.B)))
+	return max(d, abs(int(a.A)-int(b.A)))
+}
+
+// fade returns a pale grey version of the color, as the background for the differing pixels of a diff image.
+func fade(c color.NRGBA) color.NRGBA {
+	// Luma (ITU-R BT.601), composited over white, then mixed 1:3 with white.
+	y := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+	y = (y*int(c.A) + 255*(255-int(c.A))) / 255
+	grey := uint8((y + 3*255) / 4)
+	return color.NRGBA{R: grey, G: grey, B: grey, A: 255}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestImage(width, height int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestDiffImages_Identical(t *testing.T) {
+	a := newTestImage(4, 3, color.White)
+	diff := DiffImages(a, newTestImage(4, 3, color.White), 0)
+	assert.Equal(t, 0, diff.DifferingPixels)
+	assert.Equal(t, 0, diff.MaxDifference)
+	assert.Equal(t, 4, diff.Width)
+	assert.Equal(t, 3, diff.Height)
+	assert.NotEqual(t, diffColor, diff.Image.NRGBAAt(0, 0))
+}
+
+func TestDiffImages_Different(t *testing.T) {
+	a := newTestImage(4, 3, color.White)
+	b := newTestImage(4, 3, color.White)
+	b.Set(1, 1, color.NRGBA{R: 250, G: 255, B: 255, A: 255})
+	b.Set(2, 2, color.Black)
+
+	diff := DiffImages(a, b, 0)
+	assert.Equal(t, 2, diff.DifferingPixels)
+	assert.Equal(t, 255, diff.MaxDifference)
+	assert.Equal(t, diffColor, diff.Image.NRGBAAt(1, 1))
+	assert.Equal(t, diffColor, diff.Image.NRGBAAt(2, 2))
+	assert.NotEqual(t, diffColor, diff.Image.NRGBAAt(0, 0))
+
+	// Pixels within the tolerance match.
+	diff = DiffImages(a, b, 5)
+	assert.Equal(t, 1, diff.DifferingPixels)
+	assert.NotEqual(t, diffColor, diff.Image.NRGBAAt(1, 1))
+}
+
+func TestDiffImages_DifferentSizes(t *testing.T) {
+	diff := DiffImages(newTestImage(4, 3, color.White), newTestImage(2, 4, color.White), 0)
+	assert.Equal(t, 4, diff.Width)
+	assert.Equal(t, 4, diff.Height)
+	// 4x4 - 2x3 pixels are outside one of the images.
+	assert.Equal(t, 10, diff.DifferingPixels)
+	assert.Equal(t, diffColor, diff.Image.NRGBAAt(3, 0))
+	assert.Equal(t, diffColor, diff.Image.NRGBAAt(0, 3))
+}
+
+func TestPixelReader(t *testing.T) {
+	colors := []color.Color{
+		color.White,
+		color.NRGBA{R: 10, G: 20, B: 30, A: 255},
+		color.NRGBA{R: 200, G: 100, B: 50, A: 128},
+		color.Transparent,
+	}
+	rect := image.Rect(1, 1, 1+len(colors), 2)
+	nrgba, rgba, gray := image.NewNRGBA(rect), image.NewRGBA(rect), image.NewGray(rect)
+	for i, c := range colors {
+		nrgba.Set(1+i, 1, c)
+		rgba.Set(1+i, 1, c)
+		gray.Set(1+i, 1, c)
+	}
+
+	// The buffers are read directly, with the same result as converting the color returned by At.
+	for _, img := range []image.Image{nrgba, rgba, gray} {
+		at := pixelReader(img)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			assert.Equal(t, color.NRGBAModel.Convert(img.At(x, 1)), at(x, 1))
+		}
+	}
+}
+
+func TestChannelDifference(t *testing.T) {
+	assert.Equal(t, 0, channelDifference(color.NRGBA{1, 2, 3, 4}, color.NRGBA{1, 2, 3, 4}))
+	assert.Equal(t, 7, channelDifference(color.NRGBA{10, 2, 3, 4}, color.NRGBA{5, 2, 10, 4}))
+	assert.Equal(t, 255, channelDifference(color.NRGBA{A: 255}, color.NRGBA{}))
+}
+
+func TestFade(t *testing.T) {
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, fade(color.NRGBA{255, 255, 255, 255}))
+	assert.Equal(t, color.NRGBA{255, 255, 255, 255}, fade(color.NRGBA{}))
+	black := fade(color.NRGBA{A: 255})
+	assert.Equal(t, uint8(191), black.R)
+	assert.Equal(t, black.R, black.G)
+}
@@ -0,0 +1,378 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// The statuses of a Job. Queued jobs are claimed by runners, which hold a lease on the job until they complete it
+// (done or failed), or stop sending heartbeats, in which case it's queued again. Jobs still queued when a newer
+// revision is scheduled for their platform are superseded.
+const (
+	JobStatusQueued     = "queued"
+	JobStatusClaimed    = "claimed"
+	JobStatusDone       = "done"
+	JobStatusFailed     = "failed"
+	JobStatusSuperseded = "superseded"
+)
+
+// JobStatuses are all of the valid Job statuses.
+var JobStatuses = []string{JobStatusQueued, JobStatusClaimed, JobStatusDone, JobStatusFailed, JobStatusSuperseded}
+
+// jobLeaseDuration is how long a claimed job is leased to its runner after each heartbeat.
+const jobLeaseDuration = 30 * time.Minute
+
+// maxJobAttempts is the number of times a job is claimed before it's failed, rather than queued again, when its
+// runner fails (or its lease expires).
+const maxJobAttempts = 3
+
+// maxJobsScanned is the number of queued jobs considered by each claim, so that a runner for a platform without
+// jobs doesn't read every queued job.
+const maxJobsScanned = 100
+
+// githubAPIURL is the base URL of the GitHub API, used to find the latest WPT revision.
+const githubAPIURL = "https://api.github.com"
+
+// wptRepo is the GitHub repository of web-platform-tests.
+const wptRepo = "w3c/web-platform-tests"
+
+// fullSHARegex matches a full (40 character) SHA1 of a git commit.
+var fullSHARegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+var errNoJob = errors.New("no job available")
+
+// jobNotFoundError is returned for a Job which doesn't exist.
+type jobNotFoundError string
+
+func (id jobNotFoundError) Error() string {
+	return fmt.Sprintf("job %s not found", string(id))
+}
+
+// jobLeaseError is returned when a runner updates a job it doesn't hold the lease on, e.g. because the lease expired
+// and the job was claimed by another runner. The runner should abandon the job.
+type jobLeaseError string
+
+func (err jobLeaseError) Error() string {
+	return string(err)
+}
+
+// jobID returns the ID (key name) of the job for the given platform and SHA[0:10].
+func jobID(platform, revision string) string {
+	return platform + "@" + revision
+}
+
+// jobKey returns the key of the Job with the given ID.
+func jobKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, "Job", id, 0, nil)
+}
+
+// newJob returns a queued job for the platform, at the given (full) SHA.
+func newJob(platform, sha string, now time.Time) Job {
+	return Job{
+		ID:        jobID(platform, sha[:10]),
+		Platform:  platform,
+		Revision:  sha[:10],
+		SHA:       sha,
+		Status:    JobStatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// claim leases the (queued) job to the runner.
+func (job *Job) claim(runner string, now time.Time) error {
+	if job.Status != JobStatusQueued {
+		return jobLeaseError(fmt.Sprintf("job %s is %s", job.ID, job.Status))
+	}
+	job.Status = JobStatusClaimed
+	job.Runner = runner
+	job.Attempts++
+	job.LeaseExpiresAt = now.Add(jobLeaseDuration)
+	job.UpdatedAt = now
+	return nil
+}
+
+// checkLease returns a jobLeaseError unless the job is claimed by the runner. Leases which have expired, but
+// haven't been requeued yet (see expire), are still held.
+func (job *Job) checkLease(runner string) error {
+	if job.Status != JobStatusClaimed || job.Runner != runner {
+		return jobLeaseError(fmt.Sprintf("job %s isn't claimed by %s", job.ID, runner))
+	}
+	return nil
+}
+
+// heartbeat extends the runner's lease on the job.
+func (job *Job) heartbeat(runner string, now time.Time) error {
+	if err := job.checkLease(runner); err != nil {
+		return err
+	}
+	job.LeaseExpiresAt = now.Add(jobLeaseDuration)
+	job.UpdatedAt = now
+	return nil
+}
+
+// complete finishes the runner's job, recording the ID of the uploaded run. A failed job is queued again, unless
+// it has been attempted maxJobAttempts times.
+func (job *Job) complete(runner string, runID int64, failed bool, now time.Time) error {
+	if err := job.checkLease(runner); err != nil {
+		return err
+	}
+	if failed {
+		job.release()
+	} else {
+		job.Status = JobStatusDone
+		job.RunID = runID
+	}
+	job.UpdatedAt = now
+	return nil
+}
+
+// release queues the (claimed) job again, or fails it if it has been attempted maxJobAttempts times.
+func (job *Job) release() {
+	job.Status = JobStatusQueued
+	if job.Attempts >= maxJobAttempts {
+		job.Status = JobStatusFailed
+	}
+	job.Runner = ""
+	job.LeaseExpiresAt = time.Time{}
+}
+
+// expire releases the job if it's claimed and its lease has expired, returning whether it did.
+func (job *Job) expire(now time.Time) bool {
+	if job.Status != JobStatusClaimed || now.Before(job.LeaseExpiresAt) {
+		return false
+	}
+	job.release()
+	job.UpdatedAt = now
+	return true
+}
+
+// supersede marks the job superseded if it's queued, returning whether it did.
+func (job *Job) supersede(now time.Time) bool {
+	if job.Status != JobStatusQueued {
+		return false
+	}
+	job.Status = JobStatusSuperseded
+	job.UpdatedAt = now
+	return true
+}
+
+// updateJob applies the update to the job with the given ID in a transaction, returning the updated job.
+func updateJob(ctx context.Context, id string, update func(job *Job) error) (job Job, err error) {
+	key := jobKey(ctx, id)
+	err = datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		job = Job{}
+		if err := datastore.Get(ctx, key, &job); err == datastore.ErrNoSuchEntity {
+			return jobNotFoundError(id)
+		} else if err != nil {
+			return err
+		}
+		job.ID = id
+		if err := update(&job); err != nil {
+			return err
+		}
+		_, err := datastore.Put(ctx, key, &job)
+		return err
+	}, nil)
+	job.ID = id
+	return job, err
+}
+
+// getScheduledPlatforms returns the IDs of the platforms flagged currently_run in the browser registry, in order.
+func getScheduledPlatforms(registry map[string]Browser) []string {
+	var platforms []string
+	for platform, browser := range registry {
+		if browser.CurrentlyRun {
+			platforms = append(platforms, platform)
+		}
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// scheduleJobs creates a queued job at the given (full) SHA for each of the platforms which doesn't already have
+// one, superseding the platforms' queued jobs at other revisions. It returns the jobs created. Since it's run
+// repeatedly at the same revision, that also supersedes jobs queued again since (see supersedeIfOutdated).
+func scheduleJobs(ctx context.Context, sha string, platforms []string, now time.Time) ([]Job, error) {
+	var created []Job
+	for _, platform := range platforms {
+		job := newJob(platform, sha, now)
+		key := jobKey(ctx, job.ID)
+		var isNew bool
+		err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+			var existing Job
+			err := datastore.Get(ctx, key, &existing)
+			if isNew = err == datastore.ErrNoSuchEntity; !isNew {
+				return err
+			}
+			_, err = datastore.Put(ctx, key, &job)
+			return err
+		}, nil)
+		if err != nil {
+			return created, err
+		}
+		if isNew {
+			created = append(created, job)
+		}
+		if err = supersedeJobs(ctx, platform, job.Revision, now); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// supersedeJobs marks the platform's queued jobs at revisions other than the given one as superseded, so runners
+// always run the latest scheduled revision.
+func supersedeJobs(ctx context.Context, platform, revision string, now time.Time) error {
+	keys, err := datastore.NewQuery("Job").
+		Filter("Platform =", platform).
+		Filter("Status =", JobStatusQueued).
+		KeysOnly().
+		GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		_, err := updateJob(ctx, key.StringID(), func(job *Job) error {
+			if job.Revision != revision {
+				job.supersede(now)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// supersedeIfOutdated supersedes the job if it's queued and a newer job has been scheduled for its platform, e.g.
+// once a job at an old revision is released by its runner (see Job.release), returning the updated job.
+func supersedeIfOutdated(ctx context.Context, job Job, now time.Time) (Job, error) {
+	if job.Status != JobStatusQueued {
+		return job, nil
+	}
+	newer, err := datastore.NewQuery("Job").
+		Filter("Platform =", job.Platform).
+		Filter("CreatedAt >", job.CreatedAt).
+		KeysOnly().
+		Limit(1).
+		GetAll(ctx, nil)
+	if err != nil || len(newer) == 0 {
+		return job, err
+	}
+	return updateJob(ctx, job.ID, func(job *Job) error {
+		job.supersede(now)
+		return nil
+	})
+}
+
+// claimJob leases the oldest queued job for any of the given platforms (or any platform, if there are none) to
+// the runner. It returns errNoJob if there isn't one.
+func claimJob(ctx context.Context, runner string, platforms []string, now time.Time) (Job, error) {
+	var jobs []Job
+	keys, err := datastore.NewQuery("Job").
+		Filter("Status =", JobStatusQueued).
+		Order("CreatedAt").
+		Limit(maxJobsScanned).
+		GetAll(ctx, &jobs)
+	if err != nil {
+		return Job{}, err
+	}
+	for i, candidate := range jobs {
+		if len(platforms) > 0 && !containsString(platforms, candidate.Platform) {
+			continue
+		}
+		job, err := updateJob(ctx, keys[i].StringID(), func(job *Job) error {
+			return job.claim(runner, now)
+		})
+		if _, ok := err.(jobLeaseError); ok {
+			// Claimed by another runner in the meantime.
+			continue
+		}
+		return job, err
+	}
+	return Job{}, errNoJob
+}
+
+// requeueExpiredJobs releases the claimed jobs whose leases have expired (see Job.expire), returning the number
+// released.
+func requeueExpiredJobs(ctx context.Context, now time.Time) (int, error) {
+	var jobs []Job
+	keys, err := datastore.NewQuery("Job").Filter("Status =", JobStatusClaimed).GetAll(ctx, &jobs)
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for i, job := range jobs {
+		if now.Before(job.LeaseExpiresAt) {
+			continue
+		}
+		// Check again in the transaction, in case the runner sent a heartbeat in the meantime.
+		expired := false
+		job, err := updateJob(ctx, keys[i].StringID(), func(job *Job) error {
+			expired = job.expire(now)
+			return nil
+		})
+		if err != nil {
+			return released, err
+		}
+		if expired {
+			released++
+			if _, err = supersedeIfOutdated(ctx, job, now); err != nil {
+				return released, err
+			}
+		}
+	}
+	return released, nil
+}
+
+// fetchWPTHead returns the SHA of the latest commit on the master branch of web-platform-tests, from the GitHub
+// API at the given base URL.
+func fetchWPTHead(client *http.Client, api string) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s/commits/master", api, wptRepo), nil)
+	if err != nil {
+		return "", err
+	}
+	// Only fetch the SHA, rather than the whole commit.
+	req.Header.Set("Accept", "application/vnd.github.VERSION.sha")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	} else if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned HTTP status %d:\n%s", req.URL, resp.StatusCode, string(body))
+	}
+	sha := strings.TrimSpace(string(body))
+	if !fullSHARegex.MatchString(sha) {
+		return "", fmt.Errorf("invalid SHA from %s: %s", req.URL, sha)
+	}
+	return sha, nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// apiJobsHandler is responsible for emitting the most recently scheduled jobs (see Job), newest first.
+//
+// URL Params:
+//     status: (optional) Status of the jobs to include (see JobStatuses)
+//     platform: (optional) Platform ID of the jobs to include
+//     max-count: (optional) Maximum number of jobs to emit (default 100)
+func apiJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "This endpoint only supports GET.", http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	maxCount, err := ParseMaxCountParamWithDefault(r, 100)
+	if err != nil {
+		http.Error(w, "Invalid max-count param: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := appengine.NewContext(r)
+	query := datastore.NewQuery("Job").Order("-CreatedAt")
+	if status := params.Get("status"); status != "" {
+		if !containsString(JobStatuses, status) {
+			http.Error(w, "Invalid 'status' param: "+status, http.StatusBadRequest)
+			return
+		}
+		query = query.Filter("Status =", status)
+	}
+	// Filter platforms in memory, to avoid an index for each combination of filters; there are few jobs per day.
+	platform := params.Get("platform")
+	jobs := []Job{}
+	it := query.Run(ctx)
+	for len(jobs) < maxCount {
+		var job Job
+		key, err := it.Next(&job)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if platform == "" || job.Platform == platform {
+			job.ID = key.StringID()
+			jobs = append(jobs, job)
+		}
+	}
+
+	jobsBytes, err := json.Marshal(jobs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jobsBytes)
+}
+
+// apiJobClaimHandler is responsible for (POST) requests from runners to claim the oldest queued job for their
+// platforms, emitting it, or responding 204 No Content when there isn't one. The runner holds the job's lease
+// until it completes the job, as long as it sends heartbeats. Requires the upload token, supplied in the 'secret'
+// param.
+//
+// URL Params:
+//     runner: Name of the runner, e.g. 'chrome-linux-1'
+//     platforms: (optional) Comma-separated platform IDs the runner can run; any platform by default
+func apiJobClaimHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, runner, ok := parseJobRequest(w, r, false)
+	if !ok {
+		return
+	}
+	noteRunnerSeen(ctx, runner)
+	var platforms []string
+	if param := r.URL.Query().Get("platforms"); param != "" {
+		platforms = strings.Split(param, ",")
+	}
+
+	job, err := claimJob(ctx, runner, platforms, time.Now())
+	if err == errNoJob {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJob(w, job)
+}
+
+// apiJobHeartbeatHandler is responsible for (POST) heartbeats from runners, which extend their leases on their
+// jobs. It responds 409 Conflict if the runner no longer holds the lease, in which case it should abandon the job.
+// Requires the upload token, supplied in the 'secret' param.
+//
+// URL Params:
+//     job_id: ID of the job
+//     runner: Name of the runner which claimed the job
+func apiJobHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, runner, ok := parseJobRequest(w, r, true)
+	if !ok {
+		return
+	}
+	noteRunnerSeen(ctx, runner)
+	now := time.Now()
+	job, err := updateJob(ctx, r.URL.Query().Get("job_id"), func(job *Job) error {
+		return job.heartbeat(runner, now)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), jobErrorStatus(err))
+		return
+	}
+	writeJob(w, job)
+}
+
+// apiJobCompleteHandler is responsible for (POST) requests from runners to complete their jobs, once the run has
+// been uploaded (see apiTestRunPostHandler), or to report that they failed, in which case the job is queued again
+// (or superseded, if a newer revision has been scheduled for its platform; see supersedeIfOutdated). Requires the
+// upload token, supplied in the 'secret' param.
+//
+// URL Params:
+//     job_id: ID of the job
+//     runner: Name of the runner which claimed the job
+//     run_id: (optional) ID of the TestRun uploaded for the job
+//     failed: (optional) True if the runner failed to run the job
+func apiJobCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, runner, ok := parseJobRequest(w, r, true)
+	if !ok {
+		return
+	}
+	noteRunnerSeen(ctx, runner)
+	var runID int64
+	if param := r.URL.Query().Get("run_id"); param != "" {
+		var err error
+		if runID, err = strconv.ParseInt(param, 10, 64); err != nil || runID <= 0 {
+			http.Error(w, "Invalid 'run_id' param", http.StatusBadRequest)
+			return
+		}
+	}
+	failed := ParseBooleanParam(r, "failed")
+
+	now := time.Now()
+	job, err := updateJob(ctx, r.URL.Query().Get("job_id"), func(job *Job) error {
+		return job.complete(runner, runID, failed, now)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), jobErrorStatus(err))
+		return
+	}
+	if job, err = supersedeIfOutdated(ctx, job, now); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJob(w, job)
+}
+
+// cronScheduleJobsHandler schedules jobs at the latest WPT revision for each platform flagged currently_run in
+// the browser registry (see scheduleJobs). It's run by the App Engine cron service (see cron.yaml).
+func cronScheduleJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkCronRequest(w, r) {
+		return
+	}
+	ctx := appengine.NewContext(r)
+	sha, err := fetchWPTHead(urlfetch.Client(ctx), githubAPIURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	registry, err := GetBrowsers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	created, err := scheduleJobs(ctx, sha, getScheduledPlatforms(registry), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof(ctx, "Scheduled %d jobs at %s", len(created), sha)
+}
+
+// cronRequeueJobsHandler queues the jobs whose leases have expired again (see requeueExpiredJobs). It's run by
+// the App Engine cron service (see cron.yaml).
+func cronRequeueJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkCronRequest(w, r) {
+		return
+	}
+	ctx := appengine.NewContext(r)
+	released, err := requeueExpiredJobs(ctx, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof(ctx, "Released %d jobs with expired leases", released)
+}
+
+// parseJobRequest checks that the request is a POST with the upload token and a valid 'runner' param (and
+// 'job_id' param, if needed). If not, an error response is written and false is returned.
+func parseJobRequest(w http.ResponseWriter, r *http.Request, needsJob bool) (ctx context.Context, runner string,
+	ok bool) {
+	ctx = appengine.NewContext(r)
+	if !checkUploadToken(ctx, w, r) {
+		return ctx, "", false
+	}
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return ctx, "", false
+	}
+	runner = r.URL.Query().Get("runner")
+	if err := validateRunnerName(runner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return ctx, "", false
+	}
+	if needsJob && r.URL.Query().Get("job_id") == "" {
+		http.Error(w, "Missing 'job_id' param", http.StatusBadRequest)
+		return ctx, "", false
+	}
+	return ctx, runner, true
+}
+
+// jobErrorStatus returns the HTTP status for an error from updating a job.
+func jobErrorStatus(err error) int {
+	switch err.(type) {
+	case jobNotFoundError:
+		return http.StatusNotFound
+	case jobLeaseError:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJob(w http.ResponseWriter, job Job) {
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(jobBytes)
+}
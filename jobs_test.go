@@ -0,0 +1,152 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFullSHA = "abcdef0123456789abcdef0123456789abcdef01"
+
+func TestNewJob(t *testing.T) {
+	now := time.Date(2018, 1, 1, 7, 0, 0, 0, time.UTC)
+	job := newJob("chrome-63.0-linux", testFullSHA, now)
+	assert.Equal(t, "chrome-63.0-linux@abcdef0123", job.ID)
+	assert.Equal(t, "abcdef0123", job.Revision)
+	assert.Equal(t, testFullSHA, job.SHA)
+	assert.Equal(t, JobStatusQueued, job.Status)
+	assert.Equal(t, now, job.CreatedAt)
+}
+
+func TestJob_Lifecycle(t *testing.T) {
+	now := time.Date(2018, 1, 1, 7, 0, 0, 0, time.UTC)
+	job := newJob("chrome-63.0-linux", testFullSHA, now)
+
+	assert.Nil(t, job.claim("runner-1", now))
+	assert.Equal(t, JobStatusClaimed, job.Status)
+	assert.Equal(t, "runner-1", job.Runner)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, now.Add(jobLeaseDuration), job.LeaseExpiresAt)
+	assert.IsType(t, jobLeaseError(""), job.claim("runner-2", now))
+
+	later := now.Add(time.Minute)
+	assert.Nil(t, job.heartbeat("runner-1", later))
+	assert.Equal(t, later.Add(jobLeaseDuration), job.LeaseExpiresAt)
+	assert.IsType(t, jobLeaseError(""), job.heartbeat("runner-2", later))
+	assert.False(t, job.expire(later))
+
+	assert.IsType(t, jobLeaseError(""), job.complete("runner-2", 123, false, later))
+	assert.Nil(t, job.complete("runner-1", 123, false, later))
+	assert.Equal(t, JobStatusDone, job.Status)
+	assert.Equal(t, int64(123), job.RunID)
+	assert.IsType(t, jobLeaseError(""), job.heartbeat("runner-1", later))
+}
+
+func TestJob_Requeue(t *testing.T) {
+	now := time.Date(2018, 1, 1, 7, 0, 0, 0, time.UTC)
+	job := newJob("chrome-63.0-linux", testFullSHA, now)
+
+	// A failed attempt queues the job again.
+	assert.Nil(t, job.claim("runner-1", now))
+	assert.Nil(t, job.complete("runner-1", 0, true, now))
+	assert.Equal(t, JobStatusQueued, job.Status)
+	assert.Equal(t, "", job.Runner)
+
+	// So does an expired lease.
+	assert.Nil(t, job.claim("runner-2", now))
+	assert.True(t, job.expire(now.Add(jobLeaseDuration)))
+	assert.Equal(t, JobStatusQueued, job.Status)
+	assert.IsType(t, jobLeaseError(""), job.heartbeat("runner-2", now))
+
+	// Until the job has been attempted maxJobAttempts times.
+	assert.Nil(t, job.claim("runner-3", now))
+	assert.Equal(t, maxJobAttempts, job.Attempts)
+	assert.True(t, job.expire(now.Add(2*jobLeaseDuration)))
+	assert.Equal(t, JobStatusFailed, job.Status)
+	assert.False(t, job.expire(now.Add(3*jobLeaseDuration)))
+}
+
+func TestJob_Supersede(t *testing.T) {
+	now := time.Date(2018, 1, 1, 7, 0, 0, 0, time.UTC)
+	job := newJob("chrome-63.0-linux", testFullSHA, now)
+
+	assert.Nil(t, job.claim("runner-1", now))
+	assert.False(t, job.supersede(now))
+	assert.Equal(t, JobStatusClaimed, job.Status)
+
+	assert.True(t, job.expire(now.Add(jobLeaseDuration)))
+	assert.True(t, job.supersede(now.Add(jobLeaseDuration)))
+	assert.Equal(t, JobStatusSuperseded, job.Status)
+	assert.IsType(t, jobLeaseError(""), job.claim("runner-2", now))
+}
+
+func TestGetScheduledPlatforms(t *testing.T) {
+	registry := map[string]Browser{
+		"firefox-57.0-linux": {BrowserName: "firefox", CurrentlyRun: true},
+		"chrome-63.0-linux":  {BrowserName: "chrome", CurrentlyRun: true},
+		"edge-15":            {BrowserName: "edge"},
+	}
+	assert.Equal(t, []string{"chrome-63.0-linux", "firefox-57.0-linux"}, getScheduledPlatforms(registry))
+}
+
+func TestFetchWPTHead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/w3c/web-platform-tests/commits/master", r.URL.Path)
+		assert.Equal(t, "application/vnd.github.VERSION.sha", r.Header.Get("Accept"))
+		w.Write([]byte(testFullSHA + "\n"))
+	}))
+	defer server.Close()
+
+	sha, err := fetchWPTHead(http.DefaultClient, server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, testFullSHA, sha)
+}
+
+func TestFetchWPTHead_Error(t *testing.T) {
+	for _, handler := range []http.HandlerFunc{
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "rate limited", http.StatusForbidden)
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html>"))
+		},
+	} {
+		server := httptest.NewServer(handler)
+		_, err := fetchWPTHead(http.DefaultClient, server.URL)
+		assert.NotNil(t, err)
+		server.Close()
+	}
+}
+
+func TestJobErrorStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, jobErrorStatus(jobNotFoundError("chrome@abcdef0123")))
+	assert.Equal(t, http.StatusConflict, jobErrorStatus(jobLeaseError("lost")))
+	assert.Equal(t, http.StatusInternalServerError, jobErrorStatus(errNoJob))
+}
+
+func TestCheckCronRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/cron/jobs/schedule", nil)
+	w := httptest.NewRecorder()
+	assert.False(t, checkCronRequest(w, r))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	r.Header.Set("X-AppEngine-Cron", "true")
+	assert.True(t, checkCronRequest(httptest.NewRecorder(), r))
+}
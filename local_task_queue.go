@@ -0,0 +1,158 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// localTaskBatchSize is the maximum number of tasks run by each run of cronRunTasksHandler.
+const localTaskBatchSize = 10
+
+// localTaskLease is how long a task is leased to the request running it, after which it's due again (e.g. if the
+// instance died mid-task).
+const localTaskLease = 10 * time.Minute
+
+// taskStore persists the tasks of a localTaskQueue.
+type taskStore interface {
+	// put creates or updates the task, setting its ID when it's new.
+	put(ctx context.Context, task *Task) error
+	delete(ctx context.Context, id int64) error
+	// due returns up to limit tasks which haven't been abandoned, and whose NextAttemptAt isn't after now.
+	due(ctx context.Context, now time.Time, limit int) ([]Task, error)
+}
+
+// datastoreTaskStore is a taskStore which stores tasks as Datastore "Task" entities.
+type datastoreTaskStore struct{}
+
+func (datastoreTaskStore) put(ctx context.Context, task *Task) error {
+	key := datastore.NewKey(ctx, "Task", "", task.ID, nil)
+	key, err := datastore.Put(ctx, key, task)
+	if err != nil {
+		return err
+	}
+	task.ID = key.IntID()
+	return nil
+}
+
+func (datastoreTaskStore) delete(ctx context.Context, id int64) error {
+	return datastore.Delete(ctx, datastore.NewKey(ctx, "Task", "", id, nil))
+}
+
+func (datastoreTaskStore) due(ctx context.Context, now time.Time, limit int) ([]Task, error) {
+	var tasks []Task
+	keys, err := datastore.NewQuery("Task").
+		Filter("Abandoned =", false).
+		Filter("NextAttemptAt <=", now).
+		Order("NextAttemptAt").
+		Limit(limit).
+		GetAll(ctx, &tasks)
+	for i := range keys {
+		tasks[i].ID = keys[i].IntID()
+	}
+	return tasks, err
+}
+
+// localTaskQueue is a TaskQueue which stores tasks in the Datastore, and runs them in-process from
+// cronRunTasksHandler, retrying failures with exponential backoff. Tasks are deleted once they succeed, and those
+// that run out of attempts are kept (abandoned, with their last error) for inspection. Tasks only ever run within a
+// (cron) request, so pending tasks carry on from where they were after a restart.
+type localTaskQueue struct {
+	store   taskStore
+	backoff time.Duration
+}
+
+func newLocalTaskQueue(store taskStore) *localTaskQueue {
+	return &localTaskQueue{store: store, backoff: taskInitialBackoff}
+}
+
+func (q *localTaskQueue) Enqueue(ctx context.Context, name string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	task := Task{Name: name, Payload: body, CreatedAt: now, NextAttemptAt: now}
+	return q.store.put(ctx, &task)
+}
+
+// runDue runs the tasks which are due at the given time (see taskStore.due), on behalf of the request r, returning
+// the number run and how many of those failed. Each failure is recorded on the task, which is retried after a
+// backoff, or abandoned once it runs out of attempts.
+func (q *localTaskQueue) runDue(ctx context.Context, r *http.Request, now time.Time) (ran int, failed int, err error) {
+	tasks, err := q.store.due(ctx, now, localTaskBatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Lease the tasks before running any of them, so that an overlapping run doesn't pick them up too.
+	for i := range tasks {
+		tasks[i].NextAttemptAt = now.Add(localTaskLease)
+		if err = q.store.put(ctx, &tasks[i]); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for _, task := range tasks {
+		ran++
+		attemptErr := q.attempt(ctx, r, task)
+		if attemptErr == nil {
+			if err = q.store.delete(ctx, task.ID); err != nil {
+				return ran, failed, err
+			}
+			continue
+		}
+
+		failed++
+		task.Attempts++
+		task.LastError = attemptErr.Error()
+		if task.Attempts >= taskMaxAttempts {
+			task.Abandoned = true
+		} else {
+			task.NextAttemptAt = now.Add(q.backoff << uint(task.Attempts-1))
+		}
+		if err = q.store.put(ctx, &task); err != nil {
+			return ran, failed, err
+		}
+	}
+	return ran, failed, nil
+}
+
+func (q *localTaskQueue) attempt(ctx context.Context, r *http.Request, task Task) (err error) {
+	handler := getTaskHandler(task.Name)
+	if handler == nil {
+		return fmt.Errorf("unknown task %s", task.Name)
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("task panicked: %v", recovered)
+		}
+	}()
+	// Synthesize the request that the App Engine queue would have sent, to the same host.
+	taskRequest := &http.Request{
+		Method: "POST",
+		URL:    &url.URL{Path: tasksPath + task.Name},
+		Host:   r.Host,
+		TLS:    r.TLS,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+	}
+	return handler(ctx, taskRequest, task.Payload)
+}
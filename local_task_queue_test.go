@@ -0,0 +1,187 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// memoryTaskStore is a taskStore which keeps tasks in memory.
+type memoryTaskStore struct {
+	mutex  sync.Mutex
+	nextID int64
+	tasks  map[int64]Task
+}
+
+func newMemoryTaskStore() *memoryTaskStore {
+	return &memoryTaskStore{tasks: make(map[int64]Task)}
+}
+
+func (s *memoryTaskStore) put(ctx context.Context, task *Task) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if task.ID == 0 {
+		s.nextID++
+		task.ID = s.nextID
+	}
+	s.tasks[task.ID] = *task
+	return nil
+}
+
+func (s *memoryTaskStore) delete(ctx context.Context, id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tasks, id)
+	return nil
+}
+
+func (s *memoryTaskStore) due(ctx context.Context, now time.Time, limit int) (tasks []Task, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, task := range s.tasks {
+		if !task.Abandoned && !task.NextAttemptAt.After(now) {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].NextAttemptAt.Before(tasks[j].NextAttemptAt) })
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks, nil
+}
+
+func newTestLocalTaskQueue() (*localTaskQueue, *memoryTaskStore) {
+	store := newMemoryTaskStore()
+	q := newLocalTaskQueue(store)
+	q.backoff = time.Minute
+	return q, store
+}
+
+var cronRequest = httptest.NewRequest("GET", "http://wpt.fyi/cron/tasks/run", nil)
+
+func TestLocalTaskQueue_Succeeds(t *testing.T) {
+	var payloads []string
+	registerTask("test-succeeds", func(ctx context.Context, r *http.Request, payload []byte) error {
+		assert.Equal(t, tasksPath+"test-succeeds", r.URL.Path)
+		assert.Equal(t, "wpt.fyi", r.Host)
+		payloads = append(payloads, string(payload))
+		return nil
+	})
+
+	q, store := newTestLocalTaskQueue()
+	assert.Nil(t, q.Enqueue(context.Background(), "test-succeeds", map[string]int{"run_id": 1}))
+	assert.Empty(t, payloads)
+
+	ran, failed, err := q.runDue(context.Background(), cronRequest, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ran)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, []string{`{"run_id":1}`}, payloads)
+	assert.Empty(t, store.tasks)
+}
+
+func TestLocalTaskQueue_Retries(t *testing.T) {
+	attempts := 0
+	registerTask("test-retries", func(ctx context.Context, r *http.Request, payload []byte) error {
+		if attempts++; attempts < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+
+	q, store := newTestLocalTaskQueue()
+	assert.Nil(t, q.Enqueue(context.Background(), "test-retries", nil))
+	now := time.Now()
+	_, failed, err := q.runDue(context.Background(), cronRequest, now)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, "not yet", store.tasks[1].LastError)
+
+	// Not due again until the backoff has elapsed, which doubles after each failure.
+	ran, _, _ := q.runDue(context.Background(), cronRequest, now.Add(30*time.Second))
+	assert.Equal(t, 0, ran)
+	q.runDue(context.Background(), cronRequest, now.Add(time.Minute))
+	assert.Equal(t, 2, attempts)
+	ran, _, _ = q.runDue(context.Background(), cronRequest, now.Add(2*time.Minute))
+	assert.Equal(t, 0, ran)
+	q.runDue(context.Background(), cronRequest, now.Add(3*time.Minute))
+	assert.Equal(t, 3, attempts)
+	assert.Empty(t, store.tasks)
+}
+
+func TestLocalTaskQueue_GivesUp(t *testing.T) {
+	registerTask("test-gives-up", func(ctx context.Context, r *http.Request, payload []byte) error {
+		panic("broken")
+	})
+
+	q, store := newTestLocalTaskQueue()
+	assert.Nil(t, q.Enqueue(context.Background(), "test-gives-up", nil))
+	now := time.Now()
+	for i := 0; i < taskMaxAttempts; i++ {
+		now = now.Add(time.Hour)
+		q.runDue(context.Background(), cronRequest, now)
+	}
+	if assert.Len(t, store.tasks, 1) {
+		task := store.tasks[1]
+		assert.Equal(t, taskMaxAttempts, task.Attempts)
+		assert.True(t, task.Abandoned)
+		assert.Equal(t, "task panicked: broken", task.LastError)
+	}
+	due, _ := store.due(context.Background(), now.Add(time.Hour), localTaskBatchSize)
+	assert.Empty(t, due)
+}
+
+func TestLocalTaskQueue_Lease(t *testing.T) {
+	attempts := 0
+	registerTask("test-lease", func(ctx context.Context, r *http.Request, payload []byte) error {
+		attempts++
+		return nil
+	})
+
+	// A task leased by a request which never finished it is only run again once the lease has expired.
+	q, store := newTestLocalTaskQueue()
+	now := time.Now()
+	store.put(context.Background(), &Task{Name: "test-lease", NextAttemptAt: now.Add(localTaskLease)})
+	ran, _, _ := q.runDue(context.Background(), cronRequest, now)
+	assert.Equal(t, 0, ran)
+	ran, _, _ = q.runDue(context.Background(), cronRequest, now.Add(localTaskLease))
+	assert.Equal(t, 1, ran)
+	assert.Equal(t, 1, attempts)
+	assert.Empty(t, store.tasks)
+}
+
+func TestLocalTaskQueue_BatchSize(t *testing.T) {
+	registerTask("test-batch", func(ctx context.Context, r *http.Request, payload []byte) error {
+		return nil
+	})
+
+	q, store := newTestLocalTaskQueue()
+	for i := 0; i < localTaskBatchSize+1; i++ {
+		assert.Nil(t, q.Enqueue(context.Background(), "test-batch", i))
+	}
+	ran, _, err := q.runDue(context.Background(), cronRequest, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, localTaskBatchSize, ran)
+	assert.Len(t, store.tasks, 1)
+}
@@ -16,17 +16,86 @@ package wptdashboard
 
 import (
 	"html/template"
+	"io"
 	"net/http"
+	"sync"
+
+	"google.golang.org/appengine"
 )
 
-var templates = template.Must(template.ParseGlob("templates/*.html"))
+// templates are parsed on first use, so that the package can be imported from other directories (e.g. by cmd/wptd).
+var templates = &lazyTemplates{pattern: "templates/*.html"}
+
+// lazyTemplates are the templates matching a glob pattern, parsed on first use.
+type lazyTemplates struct {
+	pattern   string
+	once      sync.Once
+	templates *template.Template
+}
+
+// ExecuteTemplate applies the named template to the data, writing the output to w.
+func (t *lazyTemplates) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	t.once.Do(func() {
+		t.templates = template.Must(template.ParseGlob(t.pattern))
+	})
+	return t.templates.ExecuteTemplate(w, name, data)
+}
 
 func init() {
-	http.HandleFunc("/test-runs", testRunsHandler)
-	http.HandleFunc("/about", aboutHandler)
-	http.HandleFunc("/api/diff", apiDiffHandler)
-	http.HandleFunc("/api/runs", apiTestRunsHandler)
-	http.HandleFunc("/api/run", apiTestRunHandler)
-	http.HandleFunc("/results", resultsRedirectHandler)
-	http.HandleFunc("/", testHandler)
+	handleFunc("/test-runs", testRunsHandler)
+	handleFunc("/runners", runnersHandler)
+	handleFunc("/about", aboutHandler)
+	handleFunc("/api/admin/browsers", apiAdminBrowsersHandler)
+	handleFunc("/api/admin/browsers/import", apiAdminBrowsersImportHandler)
+	handleFunc("/api/admin/metadata", apiAdminTestMetadataHandler)
+	handleFunc("/api/admin/metadata/import", apiAdminTestMetadataImportHandler)
+	handleFunc("/api/admin/owners/import", apiAdminOwnersImportHandler)
+	handleFunc("/api/admin/revisions/backfill", apiAdminRevisionsBackfillHandler)
+	handleFunc("/api/admin/webhooks", apiAdminWebhooksHandler)
+	handleFunc("/api/browsers", apiBrowsersHandler)
+	handleFunc("/api/diff", apiDiffHandler)
+	handleFunc("/api/expectations", apiExpectationsHandler)
+	handleFunc("/api/expectations/compare", apiExpectationsCompareHandler)
+	handleFunc("/api/jobs", apiJobsHandler)
+	handleFunc("/api/jobs/claim", apiJobClaimHandler)
+	handleFunc("/api/jobs/heartbeat", apiJobHeartbeatHandler)
+	handleFunc("/api/jobs/complete", apiJobCompleteHandler)
+	handleFunc("/api/runners", apiRunnersHandler)
+	handleFunc("/api/runners/heartbeat", apiRunnerHeartbeatHandler)
+	handleFunc("/api/runs", apiTestRunsHandler)
+	handleFunc("/api/runs/export", apiTestRunsExportHandler)
+	handleFunc("/api/run", apiTestRunHandler)
+	handleFunc("/api/run/archive", apiTestRunArchiveHandler)
+	handleFunc("/api/run/artifact", apiRunArtifactHandler)
+	handleFunc("/api/run/changes", apiTestRunChangesHandler)
+	handleFunc("/api/run/screenshot", apiScreenshotHandler)
+	handleFunc("/api/run/screenshot/diff", apiScreenshotDiffHandler)
+	handleFunc("/api/run/session", apiRunSessionHandler)
+	handleFunc("/api/run/session/shard", apiRunSessionShardHandler)
+	handleFunc("/api/run/session/finalize", apiRunSessionFinalizeHandler)
+	handleFunc("/api/run/status", apiRunStatusHandler)
+	handleFunc("/api/metadata", apiTestMetadataHandler)
+	handleFunc("/api/metadata/export", apiTestMetadataExportHandler)
+	handleFunc("/api/owners", apiOwnersHandler)
+	handleFunc("/api/owners/", apiOwnerReportHandler)
+	handleFunc("/api/permalink", apiPermalinkHandler)
+	handleFunc("/api/platforms", apiPlatformsHandler)
+	handleFunc("/api/revisions", apiRevisionsHandler)
+	handleFunc("/results", resultsRedirectHandler)
+	handleFunc(tasksPath, tasksHandler)
+	handleFunc("/cron/jobs/schedule", cronScheduleJobsHandler)
+	handleFunc("/cron/jobs/requeue", cronRequeueJobsHandler)
+	handleFunc("/cron/run-sessions/expire", cronExpireRunSessionsHandler)
+	handleFunc("/cron/runs/fail-stale", cronFailStaleRunsHandler)
+	handleFunc("/cron/tasks/run", cronRunTasksHandler)
+	handleFunc("/", testHandler)
+}
+
+// handleFunc registers the handler for the given pattern, making sure the browser registry is up to date
+// (see browserRegistry.refresh) before each request is handled.
+func handleFunc(pattern string, handler http.HandlerFunc) {
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		browsers.refresh(appengine.NewContext(r))
+		handler(w, r)
+	})
 }
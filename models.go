@@ -20,6 +20,9 @@ import (
 
 // TestRun stores metadata for a test run (produced by run/run.py)
 type TestRun struct {
+	// ID is the (integer) ID of the TestRun's Datastore key. It isn't stored as a property.
+	ID int64 `json:"id" datastore:"-"`
+
 	// Platform information
 	BrowserName    string `json:"browser_name"`
 	BrowserVersion string `json:"browser_version"`
@@ -32,7 +35,187 @@ type TestRun struct {
 	// Results URL
 	ResultsURL string `json:"results_url"`
 
+	// Labels are arbitrary tags supplied by the uploader, e.g. "stable" or "experimental".
+	Labels []string `json:"labels,omitempty"`
+
+	// Paths is the scope of a partial run, i.e. the test paths (files or directories, e.g. "/css/") which were run.
+	// It's empty for a run of all of the tests. Partial runs are excluded when resolving "latest" runs, and diffs
+	// involving them only compare the tests in the shared scope (see SharedScopeFilter).
+	Paths []string `json:"paths,omitempty"`
+
+	// Status of the run's lifecycle: pending, running, complete or failed (see run_status.go). Runs stored before
+	// statuses existed have none, and are complete. Only complete runs are included in results by default.
+	Status string `json:"status,omitempty"`
+
+	// Progress of a pending or running run, as last reported by its runner.
+	Progress RunProgress `json:"progress"`
+
+	// Runner is the name of the (registered) runner which produced the run, if known (see Runner).
+	Runner string `json:"runner,omitempty"`
+
+	// Artifacts uploaded for the run, e.g. the runner's logs and browser crash logs (see artifacts.go).
+	Artifacts []RunArtifact `json:"artifacts,omitempty"`
+
+	// CreatedAt is when the run was uploaded, or (for runs created before they finished) when it became complete.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Changes since the previous run of the same browser, computed in the background after upload.
+	Changes RunChanges `json:"changes"`
+
+	// ArchiveURL is the URL of the run's archive of results files, once it's been built (see run_archive.go).
+	ArchiveURL string `json:"archive_url,omitempty" datastore:",noindex"`
+
+	// ArchiveRequestedAt is when the run's archive was last requested to be built.
+	ArchiveRequestedAt time.Time `json:"-" datastore:",noindex"`
+}
+
+// RunArtifact is a file uploaded alongside a TestRun to help explain its results, e.g. the runner's stdout, a
+// browser crash log, or a test's stack trace. The file itself is stored (gzipped) with the run's results files.
+type RunArtifact struct {
+	// Name of the artifact, unique within the run, e.g. "stderr.log".
+	Name string `json:"name" datastore:",noindex"`
+
+	// Kind is log, crash or stack.
+	Kind string `json:"kind" datastore:",noindex"`
+
+	// Test the artifact relates to, if any, e.g. for a stack trace.
+	Test string `json:"test,omitempty" datastore:",noindex"`
+
+	// Size of the (decompressed) artifact, in bytes.
+	Size int64 `json:"size" datastore:",noindex"`
+
+	// URL the artifact is served from.
+	URL string `json:"url" datastore:",noindex"`
+
+	UploadedAt time.Time `json:"uploaded_at" datastore:",noindex"`
+}
+
+// RunProgress is the number of tests an in-progress TestRun has completed, out of the number expected.
+type RunProgress struct {
+	Completed int       `json:"completed"`
+	Expected  int       `json:"expected"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RunChanges counts the tests that changed between a TestRun and the previous run of the same browser.
+type RunChanges struct {
+	// Computed is false until the changes have been computed (see computeRunChanges).
+	Computed bool `json:"computed"`
+
+	// PreviousRunID is the ID of the run compared against, or 0 if there wasn't one.
+	PreviousRunID int64 `json:"previous_run_id,omitempty"`
+
+	// Tests which were added or deleted, and tests present in both runs with fewer or more passing results.
+	Added     int `json:"added"`
+	Deleted   int `json:"deleted"`
+	Regressed int `json:"regressed"`
+	Improved  int `json:"improved"`
+}
+
+// TestRunChanges stores the detail of a TestRun's RunChanges (see RunChangesDetail) as gzipped JSON. It's keyed by
+// the ID of the TestRun.
+type TestRunChanges struct {
+	PreviousRunID int64
+	Detail        []byte `datastore:",noindex"`
+}
+
+// Revision records which platforms have (full) runs for a WPT revision, and when it became complete, i.e. when every
+// initially-loaded browser had a run for it. It's keyed by the SHA, and maintained as runs are uploaded.
+type Revision struct {
+	// The first 10 characters of the SHA1 of the WPT revision
+	SHA string `json:"sha"`
+
+	// Platforms (e.g. "chrome-63.0-linux-4.4") and browser names with runs for the revision, in order.
+	Platforms    []string `json:"platforms"`
+	BrowserNames []string `json:"browser_names"`
+
+	// CreatedAt times of the earliest and the most recent runs for the revision.
+	FirstRunAt  time.Time `json:"first_run_at"`
+	LatestRunAt time.Time `json:"latest_run_at"`
+
+	// Complete is set once every initially-loaded browser has a run for the revision, at CompletedAt.
+	Complete    bool      `json:"complete"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// RunSession is a TestRun being uploaded in shards, e.g. by runners which split the tests across machines. The
+// summaries of the shards (RunSessionShard entities, children of the session) are merged into the run's summary
+// when the session is finalized (see run_sessions.go). Sessions expire a day after they're opened.
+type RunSession struct {
+	ID int64 `json:"id" datastore:"-"`
+
+	// Run is the metadata of the TestRun to create; its results URL is set when the session is finalized.
+	Run TestRun `json:"run"`
+
+	// Shards is the number of shards expected, or 0 if it isn't known in advance.
+	Shards int `json:"shards,omitempty"`
+
+	// Uploaded are the (1-based) numbers of the shards uploaded so far, in order.
+	Uploaded []int `json:"uploaded"`
+
+	// Finalized is set once the TestRun has been created, with the ID RunID.
+	Finalized bool  `json:"finalized"`
+	RunID     int64 `json:"run_id,omitempty"`
+
 	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RunSessionShard stores the results summary of one shard of a RunSession, as gzipped JSON. It's keyed by the shard
+// number, under the session.
+type RunSessionShard struct {
+	Summary    []byte `datastore:",noindex"`
+	Tests      int
+	UploadedAt time.Time
+}
+
+// Job is a run of the tests at a WPT revision on a platform, scheduled for runners to claim (see jobs.go). It's keyed
+// by "<platform>@<revision>", so that each is only scheduled once.
+type Job struct {
+	ID string `json:"id" datastore:"-"`
+
+	// Platform ID (a key of browsers.json) to run the tests on.
+	Platform string `json:"platform"`
+
+	// The first 10 characters of the SHA1 of the WPT revision, and the full SHA1 to check out.
+	Revision string `json:"revision"`
+	SHA      string `json:"sha"`
+
+	// Status is queued, claimed, done, failed or superseded (see jobs.go).
+	Status string `json:"status"`
+
+	// Runner which claimed the job, whose lease expires at LeaseExpiresAt unless it sends a heartbeat.
+	Runner         string    `json:"runner,omitempty"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+
+	// Attempts is the number of times the job has been claimed.
+	Attempts int `json:"attempts"`
+
+	// RunID is the ID of the TestRun uploaded for the job, once it's done.
+	RunID int64 `json:"run_id,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Runner is a machine which runs the tests and uploads TestRuns, registered so that its health can be monitored
+// (see runners.go). It's keyed by its name.
+type Runner struct {
+	Name string `json:"name" datastore:"-"`
+
+	// Platforms (IDs from browsers.json) the runner runs.
+	Platforms []string `json:"platforms"`
+
+	// Host metadata, as reported by the runner: its hostname, operating system, and the version of the runner
+	// itself (e.g. the revision of run/run.py).
+	Hostname string `json:"hostname,omitempty"`
+	OS       string `json:"os,omitempty"`
+	Version  string `json:"version,omitempty"`
+
+	RegisteredAt time.Time `json:"registered_at"`
+
+	// LastSeenAt is the time of the runner's latest heartbeat, job update or upload.
+	LastSeenAt time.Time `json:"last_seen_at"`
 }
 
 // Browser holds objects that appear in browsers.json
@@ -46,6 +229,87 @@ type Browser struct {
 	Sauce           bool   `json:"sauce"`
 }
 
+// Webhook is a registered endpoint that receives signed JSON notifications about test runs.
+type Webhook struct {
+	ID int64 `json:"id" datastore:"-"`
+
+	// URL that notifications are POSTed to.
+	URL string `json:"url"`
+
+	// Secret used to sign notification bodies (HMAC-SHA256). Never emitted by the API.
+	Secret string `json:"secret,omitempty" datastore:",noindex"`
+
+	// Events the hook is subscribed to, e.g. "run-uploaded" (see webhooks.go).
+	Events []string `json:"events"`
+
+	// Browsers restricts notifications to runs of the given browser names (all browsers when empty).
+	Browsers []string `json:"browsers,omitempty"`
+
+	// Paths restricts regression notifications to tests under the given path prefixes (all tests when empty).
+	Paths []string `json:"paths,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery records that a notification of a run was delivered to a webhook, so that it isn't delivered again
+// when the run's processing is retried (see notifyWebhooks). Its key is "<run ID>/<webhook ID>/<event>".
+type WebhookDelivery struct {
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// TestMetadata links the failures of a test (or of all the tests under a directory), or of one of its subtests, to
+// a bug-tracker issue, optionally on a single platform, so that they can be marked as known and tracked.
+type TestMetadata struct {
+	ID int64 `json:"id" yaml:"-" datastore:"-"`
+
+	// Test is the path of a test, e.g. "/dom/a.html", or a directory prefix ending in "/", e.g. "/dom/events/".
+	Test string `json:"test" yaml:"test"`
+
+	// Subtest is the name of one of the test's subtests (all of its subtests when empty).
+	Subtest string `json:"subtest,omitempty" yaml:"subtest,omitempty" datastore:",noindex"`
+
+	// Platform is the name of the browser the metadata applies to, e.g. "chrome" (all browsers when empty).
+	Platform string `json:"platform,omitempty" yaml:"platform,omitempty"`
+
+	BugURL string `json:"bug_url" yaml:"bug_url" datastore:",noindex"`
+	Notes  string `json:"notes,omitempty" yaml:"notes,omitempty" datastore:",noindex"`
+
+	UpdatedAt time.Time `json:"updated_at" yaml:"-"`
+}
+
+// DirectoryOwners are the owners of a WPT directory, and of everything under it, as named by the directory's OWNERS
+// or META.yml file.
+type DirectoryOwners struct {
+	// Directory is the path of the directory, e.g. "/dom/events/" (the key of the entity).
+	Directory string `json:"directory" datastore:"-"`
+
+	// Owners are the (GitHub) usernames of the owners, without the leading @.
+	Owners []string `json:"owners"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Task is a queued task, as persisted by localTaskQueue (see tasks.go).
+type Task struct {
+	ID int64 `json:"id" datastore:"-"`
+
+	// Name of the task's handler (see registerTask).
+	Name    string `json:"name"`
+	Payload []byte `json:"payload" datastore:",noindex"`
+
+	// Attempts made so far, and the error returned by the last of them.
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty" datastore:",noindex"`
+
+	// Abandoned is set once the task has run out of attempts.
+	Abandoned bool `json:"abandoned"`
+
+	// NextAttemptAt is when the task is next due to run (or when its current attempt's lease expires).
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // Token is used for test result uploads.
 type Token struct {
 	Secret string `json:"secret"`
@@ -0,0 +1,284 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// ownerNameRegex matches valid owner (GitHub user) names.
+var ownerNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-]*$`)
+
+// ParseOwnersFile parses a WPT OWNERS file, which lists an owner per line as @username. Comments (#) and blank
+// lines are ignored.
+func ParseOwnersFile(r io.Reader) ([]string, error) {
+	var owners []string
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		owner := strings.TrimPrefix(line, "@")
+		if !ownerNameRegex.MatchString(owner) {
+			return nil, fmt.Errorf("line %d: invalid owner %s", lineNumber, line)
+		}
+		owners = appendOwner(owners, owner)
+	}
+	return owners, scanner.Err()
+}
+
+// ParseMetaYML parses the owners of a directory from a WPT META.yml file, i.e. its suggested_reviewers. Its other
+// keys (e.g. spec) are ignored.
+func ParseMetaYML(r io.Reader) ([]string, error) {
+	var meta struct {
+		SuggestedReviewers []string `yaml:"suggested_reviewers"`
+	}
+	if err := yaml.NewDecoder(r).Decode(&meta); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var owners []string
+	for _, reviewer := range meta.SuggestedReviewers {
+		owner := strings.TrimPrefix(strings.TrimSpace(reviewer), "@")
+		if !ownerNameRegex.MatchString(owner) {
+			return nil, fmt.Errorf("invalid suggested reviewer %s", reviewer)
+		}
+		owners = appendOwner(owners, owner)
+	}
+	return owners, nil
+}
+
+// appendOwner appends the owner to owners, unless it's already listed.
+func appendOwner(owners []string, owner string) []string {
+	if containsString(owners, owner) {
+		return owners
+	}
+	return append(owners, owner)
+}
+
+// validateDirectoryOwners checks that the directory is a clean path starting and ending with /, and that it has
+// owners with valid names.
+func validateDirectoryOwners(directory string, owners []string) error {
+	if !strings.HasPrefix(directory, "/") || !strings.HasSuffix(directory, "/") ||
+		(directory != "/" && path.Clean(directory)+"/" != directory) {
+		return fmt.Errorf("invalid directory %s (must be a path starting and ending with /)", directory)
+	}
+	if len(owners) == 0 {
+		return fmt.Errorf("directory %s has no owners", directory)
+	}
+	for _, owner := range owners {
+		if !ownerNameRegex.MatchString(owner) {
+			return fmt.Errorf("invalid owner %s of directory %s", owner, directory)
+		}
+	}
+	return nil
+}
+
+// ownersRootKey is the parent of all DirectoryOwners entities, so that they can be read with a strongly consistent
+// (ancestor) query straight after they are imported.
+func ownersRootKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "OwnersRoot", "default", 0, nil)
+}
+
+func directoryOwnersKey(ctx context.Context, directory string) *datastore.Key {
+	return datastore.NewKey(ctx, "DirectoryOwners", directory, 0, ownersRootKey(ctx))
+}
+
+// loadDirectoryOwners loads all of the DirectoryOwners entities, ordered by directory.
+func loadDirectoryOwners(ctx context.Context) ([]DirectoryOwners, error) {
+	var owners []DirectoryOwners
+	keys, err := datastore.NewQuery("DirectoryOwners").Ancestor(ownersRootKey(ctx)).GetAll(ctx, &owners)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		owners[i].Directory = keys[i].StringID()
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].Directory < owners[j].Directory })
+	return owners, nil
+}
+
+// replaceDirectoryOwners replaces all of the DirectoryOwners entities with the given map of directory to owners
+// (e.g. imported from a WPT checkout), returning them as stored. As with replaceTestMetadata, it's done in batches
+// rather than in a single transaction; a failed import should be retried.
+func replaceDirectoryOwners(ctx context.Context, imported map[string][]string, now time.Time) (
+	[]DirectoryOwners, error) {
+	existing, err := datastore.NewQuery("DirectoryOwners").Ancestor(ownersRootKey(ctx)).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var removed []*datastore.Key
+	for _, key := range existing {
+		if _, ok := imported[key.StringID()]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	stored := make([]DirectoryOwners, 0, len(imported))
+	for directory, owners := range imported {
+		stored = append(stored, DirectoryOwners{Directory: directory, Owners: owners, UpdatedAt: now})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Directory < stored[j].Directory })
+	keys := make([]*datastore.Key, len(stored))
+	for i := range stored {
+		keys[i] = directoryOwnersKey(ctx, stored[i].Directory)
+	}
+
+	for start := 0; start < len(keys); start += maxDatastoreBatch {
+		end := start + maxDatastoreBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if _, err = datastore.PutMulti(ctx, keys[start:end], stored[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	for start := 0; start < len(removed); start += maxDatastoreBatch {
+		end := start + maxDatastoreBatch
+		if end > len(removed) {
+			end = len(removed)
+		}
+		if err = datastore.DeleteMulti(ctx, removed[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return stored, nil
+}
+
+// getOwnedDirectories returns the directories the owner is listed in, in order.
+func getOwnedDirectories(all []DirectoryOwners, owner string) []string {
+	var directories []string
+	for _, owners := range all {
+		if containsString(owners.Owners, owner) {
+			directories = append(directories, owners.Directory)
+		}
+	}
+	return directories
+}
+
+// getTestOwners returns the owners of the test: those of the deepest directory containing it which has owners.
+func getTestOwners(all []DirectoryOwners, test string) []string {
+	var owners []string
+	deepest := ""
+	for _, candidate := range all {
+		if strings.HasPrefix(test, candidate.Directory) && len(candidate.Directory) > len(deepest) {
+			owners, deepest = candidate.Owners, candidate.Directory
+		}
+	}
+	return owners
+}
+
+// isTestOwner determines whether the owner is one of the test's owners (see getTestOwners).
+func isTestOwner(all []DirectoryOwners, owner, test string) bool {
+	return containsString(getTestOwners(all, test), owner)
+}
+
+// groupTestsByOwner returns the tests in the summary, keyed by each of their owners (see getTestOwners). Tests
+// without owners are omitted.
+func groupTestsByOwner(all []DirectoryOwners, summary *ResultsSummary) map[string][]string {
+	grouped := make(map[string][]string)
+	for i := 0; i < summary.Len(); i++ {
+		test, _ := summary.At(i)
+		for _, owner := range getTestOwners(all, test) {
+			grouped[owner] = append(grouped[owner], test)
+		}
+	}
+	return grouped
+}
+
+// computeOwnersVersion returns a hash of the owned directories, for the ETags of responses which depend on them.
+func computeOwnersVersion(all []DirectoryOwners) string {
+	hash := sha1.New()
+	for _, owners := range all {
+		fmt.Fprintf(hash, "%s:%s:%d;", owners.Directory, strings.Join(owners.Owners, ","), owners.UpdatedAt.UnixNano())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// OwnerReport is the report of the tests an owner covers, i.e. the tests they're an owner of (see getTestOwners and
+// /api/owners/<name>/report).
+type OwnerReport struct {
+	Owner       string           `json:"owner"`
+	Directories []string         `json:"directories"`
+	Runs        []OwnerRunReport `json:"runs"`
+}
+
+// OwnerRunReport is the pass rate of an owner's tests in a run, and their regressions since the previous run of the
+// same browser.
+type OwnerRunReport struct {
+	RunID       int64  `json:"run_id"`
+	BrowserName string `json:"browser_name"`
+	Revision    string `json:"revision"`
+
+	// Tests is the number of test files, and Passed and Total are the counts of (sub)tests, as in summaries.
+	Tests    int     `json:"tests"`
+	Passed   int     `json:"passed"`
+	Total    int     `json:"total"`
+	PassRate float64 `json:"pass_rate"`
+
+	// Regressions are the tests with [count-newly-failing, total-tests] counts (see RegressionsBetween), which
+	// are nil when there's no previous run to compare with.
+	PreviousRunID int64           `json:"previous_run_id,omitempty"`
+	Regressions   *ResultsSummary `json:"regressions"`
+}
+
+// computeOwnerRunReport computes the report of the owner's tests in the run. These are the tests regressions are
+// routed to the owner for, so subdirectories with owners of their own are excluded.
+func computeOwnerRunReport(ctx context.Context, r *http.Request, run TestRun, all []DirectoryOwners, owner string) (
+	report OwnerRunReport, err error) {
+	report = OwnerRunReport{RunID: run.ID, BrowserName: run.BrowserName, Revision: run.Revision}
+	owned := func(test string) bool { return isTestOwner(all, owner, test) }
+
+	summary, err := fetchRunResultsSummary(ctx, r, run)
+	if err != nil {
+		return report, err
+	}
+	summary = summary.Filter(owned)
+	report.Tests = summary.Len()
+	for i := 0; i < summary.Len(); i++ {
+		_, counts := summary.At(i)
+		report.Passed += int(counts[0])
+		report.Total += int(counts[1])
+	}
+	if report.Total > 0 {
+		report.PassRate = float64(report.Passed) / float64(report.Total)
+	}
+
+	previous, regressions, err := getRegressionsSincePreviousRun(ctx, r, run)
+	if err != nil {
+		return report, err
+	} else if regressions != nil {
+		report.PreviousRunID = previous.ID
+		report.Regressions = regressions.Filter(owned)
+	}
+	return report, nil
+}
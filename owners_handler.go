@@ -0,0 +1,171 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+)
+
+// apiOwnersHandler emits the owners of WPT directories (see DirectoryOwners), in directory order.
+//
+// URL Params:
+//     owner: (optional) Only emit the directories the given owner is listed in
+func apiOwnersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "This endpoint only supports GET.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := appengine.NewContext(r)
+	all, err := loadDirectoryOwners(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	owners := []DirectoryOwners{}
+	owner := r.URL.Query().Get("owner")
+	for _, directoryOwners := range all {
+		if owner == "" || containsString(directoryOwners.Owners, owner) {
+			owners = append(owners, directoryOwners)
+		}
+	}
+	writeDirectoryOwners(w, owners)
+}
+
+// apiAdminOwnersImportHandler replaces the owners of WPT directories with those in the JSON body of a POST request,
+// a list of DirectoryOwners as emitted by apiOwnersHandler, or as read from a WPT checkout by wptd owners. It
+// requires an admin of the app (see checkAdminRequest).
+func apiAdminOwnersImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := appengine.NewContext(r)
+	if !checkAdminRequest(ctx, w, r) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var list []DirectoryOwners
+	if err = json.Unmarshal(body, &list); err != nil {
+		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	imported := make(map[string][]string, len(list))
+	for _, owners := range list {
+		if err = validateDirectoryOwners(owners.Directory, owners.Owners); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if _, ok := imported[owners.Directory]; ok {
+			http.Error(w, fmt.Sprintf("directory %s is listed more than once", owners.Directory),
+				http.StatusBadRequest)
+			return
+		}
+		imported[owners.Directory] = owners.Owners
+	}
+
+	stored, err := replaceDirectoryOwners(ctx, imported, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeDirectoryOwners(w, stored)
+}
+
+// apiOwnerReportHandler emits the report of the tests an owner covers, i.e. those under the directories they're
+// listed in (see OwnerReport), at /api/owners/<name>/report: their pass rate in each of the selected runs, and
+// their regressions since the previous run of the same browser.
+//
+// URL Params:
+//     The same params as /api/runs to select the runs (the latest complete run of each browser by default)
+func apiOwnerReportHandler(w http.ResponseWriter, r *http.Request) {
+	pieces := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/owners/"), "/")
+	if len(pieces) != 2 || pieces[1] != "report" || !ownerNameRegex.MatchString(pieces[0]) {
+		http.NotFound(w, r)
+		return
+	} else if r.Method != "GET" {
+		http.Error(w, "This endpoint only supports GET.", http.StatusMethodNotAllowed)
+		return
+	}
+	owner := pieces[0]
+	selection, err := ParseTestRunSelection(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := appengine.NewContext(r)
+	all, err := loadDirectoryOwners(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report := OwnerReport{Owner: owner, Directories: getOwnedDirectories(all, owner)}
+	if len(report.Directories) == 0 {
+		http.Error(w, fmt.Sprintf("%s doesn't own any directories", owner), http.StatusNotFound)
+		return
+	}
+
+	testRuns, _, err := loadTestRuns(ctx, selection)
+	if _, ok := err.(testRunNotFoundError); ok {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The previous runs (and so the regressions) can change as runs are uploaded, so the report is never pinned.
+	if checkETag(w, r, computeRunsETag(testRuns, "owner="+owner, computeOwnersVersion(all)), false) {
+		return
+	}
+
+	report.Runs = make([]OwnerRunReport, len(testRuns))
+	err = runConcurrently(ctx, len(testRuns), maxConcurrentQueries, func(ctx context.Context, i int) (err error) {
+		report.Runs[i], err = computeOwnerRunReport(ctx, r, testRuns[i], all, owner)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(reportBytes)
+}
+
+// writeDirectoryOwners writes the owners of directories to the response as JSON.
+func writeDirectoryOwners(w http.ResponseWriter, owners []DirectoryOwners) {
+	bytes, err := json.Marshal(owners)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
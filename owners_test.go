@@ -0,0 +1,102 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOwnersFile(t *testing.T) {
+	owners, err := ParseOwnersFile(strings.NewReader("# Reviewers\n@alice\n\n@bob # Lead\n@alice\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, owners)
+
+	_, err = ParseOwnersFile(strings.NewReader("@alice\nnot an owner\n"))
+	assert.NotNil(t, err)
+}
+
+func TestParseMetaYML(t *testing.T) {
+	owners, err := ParseMetaYML(strings.NewReader(`spec: https://dom.spec.whatwg.org/
+suggested_reviewers:
+  - alice
+  - "@bob"
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, owners)
+
+	owners, err = ParseMetaYML(strings.NewReader("spec: https://dom.spec.whatwg.org/\n"))
+	assert.Nil(t, err)
+	assert.Len(t, owners, 0)
+
+	_, err = ParseMetaYML(strings.NewReader("suggested_reviewers: [alice, 'not an owner']\n"))
+	assert.NotNil(t, err)
+}
+
+func TestValidateDirectoryOwners(t *testing.T) {
+	assert.Nil(t, validateDirectoryOwners("/dom/events/", []string{"alice"}))
+	assert.Nil(t, validateDirectoryOwners("/", []string{"alice"}))
+	assert.NotNil(t, validateDirectoryOwners("/dom/events", []string{"alice"}))
+	assert.NotNil(t, validateDirectoryOwners("dom/", []string{"alice"}))
+	assert.NotNil(t, validateDirectoryOwners("/dom/../html/", []string{"alice"}))
+	assert.NotNil(t, validateDirectoryOwners("/dom/", nil))
+	assert.NotNil(t, validateDirectoryOwners("/dom/", []string{"@alice"}))
+}
+
+func TestDirectoryOwners(t *testing.T) {
+	all := []DirectoryOwners{
+		{Directory: "/dom/", Owners: []string{"alice", "bob"}},
+		{Directory: "/dom/events/", Owners: []string{"carol"}},
+		{Directory: "/html/", Owners: []string{"alice"}},
+	}
+	assert.Equal(t, []string{"/dom/", "/html/"}, getOwnedDirectories(all, "alice"))
+	assert.Nil(t, getOwnedDirectories(all, "dave"))
+
+	assert.Equal(t, []string{"alice", "bob"}, getTestOwners(all, "/dom/a.html"))
+	assert.Equal(t, []string{"carol"}, getTestOwners(all, "/dom/events/b.html"))
+	assert.Nil(t, getTestOwners(all, "/css/c.html"))
+
+	// Owners of a directory aren't owners of its subdirectories with owners of their own.
+	assert.True(t, isTestOwner(all, "alice", "/dom/a.html"))
+	assert.False(t, isTestOwner(all, "alice", "/dom/events/b.html"))
+	assert.True(t, isTestOwner(all, "carol", "/dom/events/b.html"))
+	assert.False(t, isTestOwner(all, "carol", "/css/c.html"))
+
+	regressions := NewResultsSummary(map[string][]int{
+		"/dom/a.html":        {1, 2},
+		"/dom/events/b.html": {1, 1},
+		"/css/c.html":        {1, 1},
+	})
+	assert.Equal(t, map[string][]string{
+		"alice": {"/dom/a.html"},
+		"bob":   {"/dom/a.html"},
+		"carol": {"/dom/events/b.html"},
+	}, groupTestsByOwner(all, regressions))
+	assert.Len(t, groupTestsByOwner(nil, regressions), 0)
+}
+
+func TestComputeOwnersVersion(t *testing.T) {
+	now := time.Now()
+	all := []DirectoryOwners{{Directory: "/dom/", Owners: []string{"alice"}, UpdatedAt: now}}
+	version := computeOwnersVersion(all)
+	assert.Equal(t, version, computeOwnersVersion(all))
+
+	all[0].Owners = []string{"bob"}
+	assert.NotEqual(t, version, computeOwnersVersion(all))
+	assert.NotEqual(t, version, computeOwnersVersion(nil))
+}
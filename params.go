@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // MaxCountDefaultValue is the default value returned by ParseMaxCountParam for the max-count param.
@@ -135,15 +136,20 @@ type DiffFilterParam struct {
 	Changed bool
 }
 
-// ParseDiffFilterParam splits the filter param into the differences to include.
-// The filter param is inspired by Git's --diff-filter flag.
+// ParseDiffFilterParam splits the filter param into the differences to include (see ParseDiffFilter).
 func ParseDiffFilterParam(r *http.Request) (param DiffFilterParam, err error) {
+	return ParseDiffFilter(r.URL.Query().Get("filter"))
+}
+
+// ParseDiffFilter splits a filter string, of the letters A(dded), D(eleted) and C(hanged), into the differences
+// to include. The filter is inspired by Git's --diff-filter flag. An empty filter includes all differences.
+func ParseDiffFilter(filter string) (param DiffFilterParam, err error) {
 	param = DiffFilterParam{
 		true,
 		true,
 		true,
 	}
-	if filter := r.URL.Query().Get("filter"); filter != "" {
+	if filter != "" {
 		param = DiffFilterParam{}
 		for _, char := range filter {
 			switch char {
@@ -160,3 +166,142 @@ func ParseDiffFilterParam(r *http.Request) (param DiffFilterParam, err error) {
 	}
 	return param, nil
 }
+
+// ParseRunIDsParam parses the 'run_ids' param (a comma-separated list of TestRun IDs), and also checks for the
+// (repeatable) 'run_id' param. It returns nil if neither is present.
+func ParseRunIDsParam(r *http.Request) (ids []int64, err error) {
+	idParams := r.URL.Query()["run_id"]
+	if idsParam := r.URL.Query().Get("run_ids"); idsParam != "" {
+		idParams = append(idParams, strings.Split(idsParam, ",")...)
+	}
+	for _, idParam := range idParams {
+		if idParam == "" {
+			continue
+		}
+		var id int64
+		if id, err = strconv.ParseInt(idParam, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid run id %s", idParam)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseLabelsParam parses the 'labels' param (a comma-separated list of TestRun labels), and also checks for the
+// (repeatable) 'label' param. It returns nil if neither is present.
+func ParseLabelsParam(r *http.Request) (labels []string) {
+	labelParams := r.URL.Query()["label"]
+	if labelsParam := r.URL.Query().Get("labels"); labelsParam != "" {
+		labelParams = append(labelParams, strings.Split(labelsParam, ",")...)
+	}
+	for _, label := range labelParams {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// ParseStatusParam parses the 'status' param, a comma-separated list of TestRun statuses (see RunStatuses), or
+// 'all' for every status. It returns nil if the param is absent, which means only complete runs.
+func ParseStatusParam(r *http.Request) (statuses []string, err error) {
+	param := r.URL.Query().Get("status")
+	if param == "" {
+		return nil, nil
+	} else if param == "all" {
+		return RunStatuses, nil
+	}
+	for _, status := range strings.Split(param, ",") {
+		if status = strings.TrimSpace(status); !isValidRunStatus(status) {
+			return nil, fmt.Errorf("invalid 'status' param: %s", status)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// ParseBooleanParam parses the named param as a boolean, returning false when it is absent or invalid.
+func ParseBooleanParam(r *http.Request, name string) bool {
+	value, err := strconv.ParseBool(r.URL.Query().Get(name))
+	return err == nil && value
+}
+
+// TestRunSelection holds the params which select the TestRuns shown on the results pages and by /api/runs.
+type TestRunSelection struct {
+	// SHA is the SHA[0:10] of the runs, or "latest".
+	SHA string
+
+	// Complete means 'latest' should resolve to the most recent revision with runs for all (initially-loaded)
+	// browsers.
+	Complete bool
+
+	// BrowserNames to include.
+	BrowserNames []string
+
+	// MaxCount is the maximum number of runs to include per browser.
+	MaxCount int
+
+	// IDs of specific runs to load. When present, all other fields are ignored.
+	IDs []int64
+
+	// Aligned means 'latest' should resolve to the run of each browser closest in time to Anchor (see
+	// getAlignedRuns). This is also the fallback for Complete when no revision has runs for all browsers.
+	Aligned bool
+
+	// Anchor is the time to align runs with. Zero means the oldest of the browsers' latest runs.
+	Anchor time.Time
+
+	// Partial means partial runs (see TestRun.Paths) are included, rather than only runs of all of the tests.
+	Partial bool
+
+	// Statuses of the runs to include (see RunStatuses). Empty means only complete runs.
+	Statuses []string
+}
+
+// ParseTestRunSelection parses the 'sha', 'complete', 'aligned', 'anchor', 'partial', 'status', 'browser(s)',
+// 'max-count' and 'run_id(s)' params.
+func ParseTestRunSelection(r *http.Request) (selection TestRunSelection, err error) {
+	if selection.SHA, err = ParseSHAParam(r); err != nil {
+		return selection, err
+	}
+	selection.Complete = ParseBooleanParam(r, "complete")
+	selection.Aligned = ParseBooleanParam(r, "aligned")
+	selection.Partial = ParseBooleanParam(r, "partial")
+	if selection.Anchor, err = ParseAnchorParam(r); err != nil {
+		return selection, err
+	}
+	if selection.BrowserNames, err = ParseBrowsersParam(r); err != nil {
+		return selection, err
+	}
+	if selection.MaxCount, err = ParseMaxCountParam(r); err != nil {
+		return selection, fmt.Errorf("invalid 'max-count' param: %s", err.Error())
+	}
+	if selection.IDs, err = ParseRunIDsParam(r); err != nil {
+		return selection, err
+	}
+	if selection.Statuses, err = ParseStatusParam(r); err != nil {
+		return selection, err
+	}
+	return selection, nil
+}
+
+// ParseAnchorParam parses the 'anchor' param (see ParseDateTimeParam), returning the zero time when it's absent.
+func ParseAnchorParam(r *http.Request) (anchor time.Time, err error) {
+	return ParseDateTimeParam(r, "anchor")
+}
+
+// ParseDateTimeParam parses the named param as either an RFC 3339 timestamp, or a (UTC) date in the format
+// YYYY-MM-DD. It returns the zero time when the param is absent.
+func ParseDateTimeParam(r *http.Request, name string) (t time.Time, err error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return t, nil
+	}
+	if t, err = time.Parse(time.RFC3339, param); err == nil {
+		return t, nil
+	}
+	if t, err = time.Parse("2006-01-02", param); err == nil {
+		return t, nil
+	}
+	return t, fmt.Errorf("invalid '%s' param: %s", name, param)
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestParseSHAParam(t *testing.T) {
@@ -217,3 +218,100 @@ func TestParseDiffFilterParam_Invalid(t *testing.T) {
 	_, err := ParseDiffFilterParam(r)
 	assert.NotNil(t, err)
 }
+
+func TestParseRunIDsParam(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs", nil)
+	ids, err := ParseRunIDsParam(r)
+	assert.Nil(t, err)
+	assert.Nil(t, ids)
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs?run_ids=1,2,,3&run_id=4", nil)
+	ids, err = ParseRunIDsParam(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{4, 1, 2, 3}, ids)
+}
+
+func TestParseRunIDsParam_Invalid(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs?run_ids=1,abc", nil)
+	_, err := ParseRunIDsParam(r)
+	assert.NotNil(t, err)
+}
+
+func TestParseTestRunSelection(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs?complete=true&browsers=chrome", nil)
+	selection, err := ParseTestRunSelection(r)
+	assert.Nil(t, err)
+	assert.Equal(t, TestRunSelection{
+		SHA:          "latest",
+		Complete:     true,
+		BrowserNames: []string{"chrome"},
+		MaxCount:     MaxCountDefaultValue,
+	}, selection)
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs?run_ids=1", nil)
+	selection, err = ParseTestRunSelection(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1}, selection.IDs)
+}
+
+func TestParseAnchorParam(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs", nil)
+	anchor, err := ParseAnchorParam(r)
+	assert.Nil(t, err)
+	assert.True(t, anchor.IsZero())
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs?aligned=true&anchor=2018-01-02T03:04:05Z", nil)
+	selection, err := ParseTestRunSelection(r)
+	assert.Nil(t, err)
+	assert.True(t, selection.Aligned)
+	assert.Equal(t, time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), selection.Anchor)
+}
+
+func TestParseAnchorParam_Invalid(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs?anchor=yesterday", nil)
+	_, err := ParseAnchorParam(r)
+	assert.NotNil(t, err)
+}
+
+func TestParseDateTimeParam(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs/export?from=2018-01-02&to=2018-02-03T04:05:06%2B01:00", nil)
+	from, err := ParseDateTimeParam(r, "from")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC), from)
+	to, err := ParseDateTimeParam(r, "to")
+	assert.Nil(t, err)
+	assert.True(t, time.Date(2018, 2, 3, 3, 5, 6, 0, time.UTC).Equal(to))
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs/export?from=01/02/2018", nil)
+	_, err = ParseDateTimeParam(r, "from")
+	assert.NotNil(t, err)
+}
+
+func TestParseLabelsParam(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs/export", nil)
+	assert.Nil(t, ParseLabelsParam(r))
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs/export?labels=stable,,experimental&label=sauce", nil)
+	assert.Equal(t, []string{"sauce", "stable", "experimental"}, ParseLabelsParam(r))
+}
+
+func TestParseStatusParam(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs", nil)
+	statuses, err := ParseStatusParam(r)
+	assert.Nil(t, err)
+	assert.Nil(t, statuses)
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs?status=running,failed", nil)
+	statuses, err = ParseStatusParam(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RunStatusRunning, RunStatusFailed}, statuses)
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs?status=all", nil)
+	statuses, err = ParseStatusParam(r)
+	assert.Nil(t, err)
+	assert.Equal(t, RunStatuses, statuses)
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs?status=running,done", nil)
+	_, err = ParseStatusParam(r)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// IsPartial returns whether the run only covers a subset of the tests, i.e. has a Paths scope.
+func (run TestRun) IsPartial() bool {
+	return len(run.Paths) > 0
+}
+
+// Covers returns whether the given test path is within the run's scope.
+func (run TestRun) Covers(test string) bool {
+	if !run.IsPartial() {
+		return true
+	}
+	for _, scope := range run.Paths {
+		if pathCovers(scope, test) {
+			return true
+		}
+	}
+	return false
+}
+
+// CoversScope returns whether the run's scope includes all of the other run's scope.
+func (run TestRun) CoversScope(other TestRun) bool {
+	if !run.IsPartial() {
+		return true
+	} else if !other.IsPartial() {
+		return false
+	}
+	for _, scope := range other.Paths {
+		if !run.Covers(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathCovers returns whether the test path is the scope path, or under it (when it's a directory).
+func pathCovers(scope, test string) bool {
+	return test == scope || strings.HasPrefix(test, strings.TrimSuffix(scope, "/")+"/")
+}
+
+// SharedScopeFilter returns a filter for the tests within the scope of every one of the given runs, so that diffs
+// involving partial runs only compare the tests which all of the runs could have run. It returns nil when all of
+// the runs are full runs.
+func SharedScopeFilter(runs ...TestRun) func(test string) bool {
+	var partial []TestRun
+	for _, run := range runs {
+		if run.IsPartial() {
+			partial = append(partial, run)
+		}
+	}
+	if len(partial) == 0 {
+		return nil
+	}
+	return func(test string) bool {
+		for _, run := range partial {
+			if !run.Covers(test) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// filterToSharedScope filters the summaries of a pair of runs to the runs' shared scope (see SharedScopeFilter).
+func filterToSharedScope(beforeRun, afterRun TestRun, before, after *ResultsSummary) (
+	*ResultsSummary, *ResultsSummary) {
+	if filter := SharedScopeFilter(beforeRun, afterRun); filter != nil {
+		return before.Filter(filter), after.Filter(filter)
+	}
+	return before, after
+}
+
+// normalizeRunPaths validates the Paths scope of an uploaded run, returning it cleaned and sorted, without paths
+// that are under others. A scope which includes "/" is a full run, so it's normalized to nil.
+func normalizeRunPaths(paths []string) ([]string, error) {
+	var cleaned []string
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("invalid path %s (must start with /)", p)
+		}
+		clean := path.Clean(p)
+		if clean == "/" {
+			return nil, nil
+		}
+		if strings.HasSuffix(p, "/") {
+			clean += "/"
+		}
+		cleaned = append(cleaned, clean)
+	}
+	sort.Strings(cleaned)
+	var normalized []string
+	for _, p := range cleaned {
+		if n := len(normalized); n > 0 && pathCovers(normalized[n-1], p) {
+			continue
+		}
+		normalized = append(normalized, p)
+	}
+	return normalized, nil
+}
+
+// maxRunsScanned is the maximum number of TestRun entities read by getTestRuns to find the runs it includes, so that
+// runs it skips (e.g. partial and in-progress runs uploaded since the latest full run) can't make a request read an
+// unbounded number of entities.
+const maxRunsScanned = 1000
+
+// getTestRuns returns up to limit runs matching the (ordered, unlimited) query for which include returns true,
+// or simply the first limit runs when include is nil (see getRunsFilter). Partial runs can't be excluded by the
+// query itself, since runs stored before Paths existed don't have the property. It returns an error if it scans
+// maxRunsScanned runs without finding limit runs to include.
+func getTestRuns(ctx context.Context, query *datastore.Query, limit int, include func(TestRun) bool) (
+	[]TestRun, error) {
+	if include == nil {
+		var testRuns []TestRun
+		keys, err := query.Limit(limit).GetAll(ctx, &testRuns)
+		if err != nil {
+			return nil, err
+		}
+		setTestRunIDs(keys, testRuns)
+		return testRuns, nil
+	}
+
+	var testRuns []TestRun
+	it := query.Limit(maxRunsScanned).Run(ctx)
+	for scanned := 0; len(testRuns) < limit; scanned++ {
+		var testRun TestRun
+		key, err := it.Next(&testRun)
+		if err == datastore.Done {
+			if scanned == maxRunsScanned {
+				return nil, fmt.Errorf("found only %d matching runs in the first %d runs scanned", len(testRuns),
+					maxRunsScanned)
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if include(testRun) {
+			testRun.ID = key.IntID()
+			testRuns = append(testRuns, testRun)
+		}
+	}
+	return testRuns, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestTestRun_Covers(t *testing.T) {
+	full := TestRun{}
+	assert.False(t, full.IsPartial())
+	assert.True(t, full.Covers("/css/a.html"))
+
+	css := TestRun{Paths: []string{"/css/", "/dom/nodes/Node-cloneNode.html"}}
+	assert.True(t, css.IsPartial())
+	assert.True(t, css.Covers("/css/a.html"))
+	assert.True(t, css.Covers("/dom/nodes/Node-cloneNode.html"))
+	assert.False(t, css.Covers("/dom/nodes/Node-contains.html"))
+	assert.False(t, css.Covers("/css-typed-om/a.html"))
+
+	// Directories match whether or not they have a trailing slash, but only at a path boundary.
+	cssNoSlash := TestRun{Paths: []string{"/css"}}
+	assert.True(t, cssNoSlash.Covers("/css/a.html"))
+	assert.False(t, cssNoSlash.Covers("/css-typed-om/a.html"))
+}
+
+func TestTestRun_CoversScope(t *testing.T) {
+	full := TestRun{}
+	css := TestRun{Paths: []string{"/css/"}}
+	cssGrid := TestRun{Paths: []string{"/css/css-grid/"}}
+	dom := TestRun{Paths: []string{"/dom/"}}
+
+	assert.True(t, full.CoversScope(css))
+	assert.True(t, full.CoversScope(full))
+	assert.False(t, css.CoversScope(full))
+	assert.True(t, css.CoversScope(cssGrid))
+	assert.False(t, cssGrid.CoversScope(css))
+	assert.False(t, css.CoversScope(dom))
+}
+
+func TestSharedScopeFilter(t *testing.T) {
+	assert.Nil(t, SharedScopeFilter(TestRun{}, TestRun{}))
+
+	filter := SharedScopeFilter(TestRun{}, TestRun{Paths: []string{"/css/"}})
+	assert.True(t, filter("/css/a.html"))
+	assert.False(t, filter("/dom/a.html"))
+
+	filter = SharedScopeFilter(TestRun{Paths: []string{"/css/", "/dom/"}}, TestRun{Paths: []string{"/dom/", "/html/"}})
+	assert.False(t, filter("/css/a.html"))
+	assert.True(t, filter("/dom/a.html"))
+	assert.False(t, filter("/html/a.html"))
+}
+
+func TestFilterToSharedScope(t *testing.T) {
+	fullRun := TestRun{}
+	cssRun := TestRun{Paths: []string{"/css/"}}
+	full := NewResultsSummary(map[string][]int{"/css/a.html": {1, 1}, "/dom/b.html": {1, 1}})
+	css := NewResultsSummary(map[string][]int{"/css/a.html": {0, 1}})
+
+	// Without the scope, /dom/b.html would be deleted.
+	before, after := filterToSharedScope(fullRun, cssRun, full, css)
+	assert.Equal(t, map[string][]int{"/css/a.html": {1, 1}}, before.ToMap())
+	assert.Equal(t, map[string][]int{"/css/a.html": {1, 1}},
+		DiffResultsSummaries(before, after, DiffFilterParam{true, true, true}).ToMap())
+
+	before, after = filterToSharedScope(fullRun, fullRun, full, css)
+	assert.Equal(t, full, before)
+	assert.Equal(t, css, after)
+}
+
+func TestWriteRunsDiff_PartialRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	os.Setenv(resultsDirEnvVar, dir)
+	defer os.Unsetenv(resultsDirEnvVar)
+
+	const partialSHA = "0123456789"
+	os.MkdirAll(filepath.Join(dir, sha), 0755)
+	os.MkdirAll(filepath.Join(dir, partialSHA), 0755)
+	ioutil.WriteFile(filepath.Join(dir, sha, "chrome-summary.json.gz"),
+		gzipBytes(`{"/css/a.html":[1,1],"/css/b.html":[1,1],"/dom/c.html":[1,1]}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, partialSHA, "chrome-summary.json.gz"),
+		gzipBytes(`{"/css/a.html":[0,1],"/css/b.html":[1,1],"/css/new.html":[1,1]}`), 0644)
+	fullRun := TestRun{
+		ID:         1,
+		Revision:   sha,
+		ResultsURL: "https://storage.googleapis.com/wptd/" + sha + "/chrome-summary.json.gz",
+	}
+	partialRun := TestRun{
+		ID:         2,
+		Revision:   partialSHA,
+		ResultsURL: "https://storage.googleapis.com/wptd/" + partialSHA + "/chrome-summary.json.gz",
+		Paths:      []string{"/css/"},
+	}
+
+	// /dom/c.html wasn't in the partial run's scope, so isn't reported as deleted.
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/diff?before=chrome@"+sha+"&after=chrome@"+partialSHA, nil)
+	w := httptest.NewRecorder()
+	writeRunsDiff(context.Background(), w, r, fullRun, partialRun, DiffFilterParam{true, true, true}, false, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"/css/a.html":[1,1],"/css/new.html":[1,1]}`, w.Body.String())
+}
+
+func TestNormalizeRunPaths(t *testing.T) {
+	paths, err := normalizeRunPaths(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, paths)
+
+	paths, err = normalizeRunPaths([]string{"/dom/", "/css/css-grid/", "/css/", "/dom/./nodes/a.html", "/html"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/css/", "/dom/", "/html"}, paths)
+
+	paths, err = normalizeRunPaths([]string{"/css/", "/"})
+	assert.Nil(t, err)
+	assert.Nil(t, paths)
+
+	_, err = normalizeRunPaths([]string{"css/"})
+	assert.NotNil(t, err)
+}
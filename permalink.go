@@ -0,0 +1,120 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/appengine"
+)
+
+// runSelectionParams are the params which select the runs shown by a page or API, and which a permalink replaces
+// with the concrete runs they resolved to.
+var runSelectionParams = []string{
+	"sha", "run", "complete", "aligned", "anchor", "partial", "status", "browser", "browsers", "max-count", "run_id", "run_ids", "permalink",
+}
+
+// apiPermalinkHandler emits JSON containing the permanent URL for a results page, i.e. the page URL with
+// 'latest' (and complete=true) resolved to the IDs, and SHA, of the runs it currently shows.
+//
+// URL Params:
+//     path: (optional) Path of the results page, e.g. "/css/" (defaults to "/")
+//     sha, complete, browser(s): (optional) The runs shown by the page (see apiTestRunsHandler)
+//     run_ids: (optional) Comma-separated IDs of the runs to link to, e.g. for a mixed-revision view
+func apiPermalinkHandler(w http.ResponseWriter, r *http.Request) {
+	selection, err := ParseTestRunSelection(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := &url.URL{Path: r.URL.Query().Get("path")}
+	if !strings.HasPrefix(page.Path, "/") {
+		page.Path = "/" + page.Path
+	}
+
+	ctx := appengine.NewContext(r)
+	testRuns, _, err := loadTestRuns(ctx, selection)
+	if _, ok := err.(testRunNotFoundError); ok {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(testRuns) == 0 {
+		http.Error(w, "No runs found", http.StatusNotFound)
+		return
+	}
+
+	permalink := getPermalinkURL(page, testRuns)
+	permalink.Host = r.Host
+	permalink.Scheme = "https"
+	if appengine.IsDevAppServer() {
+		permalink.Scheme = "http"
+	}
+
+	data := struct {
+		URL      string    `json:"url"`
+		TestRuns []TestRun `json:"test_runs"`
+	}{
+		permalink.String(),
+		testRuns,
+	}
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(dataBytes)
+}
+
+// getPermalinkURL returns a copy of the given URL with its run selection params replaced by the IDs of the given
+// (resolved) runs, plus their SHA if they all share the same revision.
+func getPermalinkURL(u *url.URL, testRuns []TestRun) *url.URL {
+	params := u.Query()
+	for _, param := range runSelectionParams {
+		params.Del(param)
+	}
+
+	ids := make([]string, len(testRuns))
+	sha := ""
+	for i, run := range testRuns {
+		ids[i] = strconv.FormatInt(run.ID, 10)
+		if i == 0 {
+			sha = run.Revision
+		} else if sha != run.Revision {
+			sha = ""
+		}
+	}
+	if sha != "" {
+		params.Set("sha", sha)
+	}
+	params.Set("run_ids", strings.Join(ids, ","))
+
+	permalink := *u
+	// Commas are valid in a query, and much more readable in a pasted link.
+	permalink.RawQuery = strings.Replace(params.Encode(), "%2C", ",", -1)
+	return &permalink
+}
+
+// redirectToPermalink redirects a request for the given runs to its permanent URL (see getPermalinkURL).
+func redirectToPermalink(w http.ResponseWriter, r *http.Request, testRuns []TestRun) {
+	http.Redirect(w, r, getPermalinkURL(r.URL, testRuns).RequestURI(), http.StatusFound)
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPermalinkURL_SameRevision(t *testing.T) {
+	u, _ := url.Parse("/css/?sha=latest&complete=true&browsers=chrome,firefox")
+	runs := []TestRun{
+		{ID: 1, Revision: "abcdef0123"},
+		{ID: 2, Revision: "abcdef0123"},
+	}
+	assert.Equal(t, "/css/?run_ids=1,2&sha=abcdef0123", getPermalinkURL(u, runs).String())
+}
+
+func TestGetPermalinkURL_MixedRevisions(t *testing.T) {
+	u, _ := url.Parse("/?permalink=true")
+	runs := []TestRun{
+		{ID: 1, Revision: "abcdef0123"},
+		{ID: 2, Revision: "0123456789"},
+	}
+	assert.Equal(t, "/?run_ids=1,2", getPermalinkURL(u, runs).String())
+}
+
+func TestGetPermalinkURL_KeepsOtherParams(t *testing.T) {
+	u, _ := url.Parse("/results?platform=chrome&test=/dom/a.html&permalink=1")
+	runs := []TestRun{{ID: 5, Revision: "abcdef0123"}}
+	assert.Equal(t,
+		"/results?platform=chrome&run_ids=5&sha=abcdef0123&test=%2Fdom%2Fa.html",
+		getPermalinkURL(u, runs).String())
+}
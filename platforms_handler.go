@@ -0,0 +1,145 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// Platform is an entry of the browser registry (browsers.json), along with its ID and most recent run.
+type Platform struct {
+	ID string `json:"id"`
+	Browser
+
+	// Details of the platform's most recent TestRun, if it has any.
+	LatestRunID       int64      `json:"latest_run_id,omitempty"`
+	LatestRunRevision string     `json:"latest_run_revision,omitempty"`
+	LatestRunAt       *time.Time `json:"latest_run_at,omitempty"`
+}
+
+// apiBrowsersHandler emits JSON for the list of browser names, i.e. the valid values for the 'browser' params.
+//
+// URL Params:
+//     browser(s): (optional) Browser names to filter by (see ParseBrowsersParam)
+func apiBrowsersHandler(w http.ResponseWriter, r *http.Request) {
+	browserNames, err := ParseBrowsersParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if browserNames == nil {
+		browserNames = []string{}
+	}
+	bytes, err := json.Marshal(browserNames)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
+
+// apiPlatformsHandler emits JSON for every platform in the browser registry, along with its most recent run.
+//
+// URL Params:
+//     browser(s): (optional) Browser names to filter by (see ParseBrowsersParam); all platforms by default
+func apiPlatformsHandler(w http.ResponseWriter, r *http.Request) {
+	registry, err := GetBrowsers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var browserNames []string
+	if hasBrowsersParam(r) {
+		if browserNames, err = ParseBrowsersParam(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	platforms := getPlatforms(registry, browserNames)
+
+	ctx := appengine.NewContext(r)
+	ctx, cancel := context.WithTimeout(ctx, datastoreQueryTimeout)
+	defer cancel()
+	err = runConcurrently(ctx, len(platforms), maxConcurrentQueries, func(ctx context.Context, i int) error {
+		return loadPlatformLatestRun(ctx, &platforms[i])
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(platforms)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
+
+// hasBrowsersParam determines whether the request has any 'browser' or 'browsers' params.
+func hasBrowsersParam(r *http.Request) bool {
+	return len(r.URL.Query()["browser"]) > 0 || r.URL.Query().Get("browsers") != ""
+}
+
+// getPlatforms returns the registry's platforms, ordered by ID, and filtered to the given browser names
+// (unless browserNames is nil).
+func getPlatforms(registry map[string]Browser, browserNames []string) []Platform {
+	include := make(map[string]bool)
+	for _, name := range browserNames {
+		include[name] = true
+	}
+	platforms := []Platform{}
+	for id, browser := range registry {
+		if browserNames != nil && !include[browser.BrowserName] {
+			continue
+		}
+		platforms = append(platforms, Platform{ID: id, Browser: browser})
+	}
+	sort.Slice(platforms, func(i, j int) bool {
+		return platforms[i].ID < platforms[j].ID
+	})
+	return platforms
+}
+
+// loadPlatformLatestRun fills in the details of the most recent (full) TestRun for the given platform.
+func loadPlatformLatestRun(ctx context.Context, platform *Platform) error {
+	query := datastore.
+		NewQuery("TestRun").
+		Order("-CreatedAt").
+		Filter("BrowserName =", platform.BrowserName).
+		Filter("BrowserVersion =", platform.BrowserVersion).
+		Filter("OSName =", platform.OSName)
+	// A wildcard OS version matches runs on any version of the OS.
+	if platform.OSVersion != "*" {
+		query = query.Filter("OSVersion =", platform.OSVersion)
+	}
+
+	latest, err := getFirstTestRun(ctx, query, isDefaultRun)
+	if err != nil || latest == nil {
+		return err
+	}
+	platform.LatestRunID = latest.ID
+	platform.LatestRunRevision = latest.Revision
+	platform.LatestRunAt = &latest.CreatedAt
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var mockRegistry = map[string]Browser{
+	"firefox-57.0-linux": {InitiallyLoaded: true, BrowserName: "firefox", BrowserVersion: "57.0"},
+	"chrome-63.0-linux":  {InitiallyLoaded: true, BrowserName: "chrome", BrowserVersion: "63.0"},
+	"chrome-62.0-linux":  {InitiallyLoaded: false, BrowserName: "chrome", BrowserVersion: "62.0"},
+}
+
+func TestGetPlatforms(t *testing.T) {
+	platforms := getPlatforms(mockRegistry, nil)
+	assert.Len(t, platforms, 3)
+	assert.Equal(t, "chrome-62.0-linux", platforms[0].ID)
+	assert.Equal(t, "chrome-63.0-linux", platforms[1].ID)
+	assert.Equal(t, "firefox-57.0-linux", platforms[2].ID)
+	assert.Equal(t, mockRegistry["firefox-57.0-linux"], platforms[2].Browser)
+}
+
+func TestGetPlatforms_Filtered(t *testing.T) {
+	platforms := getPlatforms(mockRegistry, []string{"chrome"})
+	assert.Len(t, platforms, 2)
+	assert.Equal(t, "chrome-62.0-linux", platforms[0].ID)
+	assert.Equal(t, "chrome-63.0-linux", platforms[1].ID)
+
+	assert.Empty(t, getPlatforms(mockRegistry, []string{}))
+}
+
+func TestPlatform_JSON(t *testing.T) {
+	bytes, err := json.Marshal(Platform{ID: "chrome-63.0-linux", Browser: mockRegistry["chrome-63.0-linux"]})
+	assert.Nil(t, err)
+	var fields map[string]interface{}
+	assert.Nil(t, json.Unmarshal(bytes, &fields))
+	assert.Equal(t, "chrome-63.0-linux", fields["id"])
+	assert.Equal(t, "chrome", fields["browser_name"])
+	assert.Equal(t, true, fields["initially_loaded"])
+	assert.NotContains(t, fields, "latest_run_at")
+}
+
+func TestHasBrowsersParam(t *testing.T) {
+	assert.False(t, hasBrowsersParam(httptest.NewRequest("GET", "http://wpt.fyi/api/platforms", nil)))
+	assert.True(t, hasBrowsersParam(httptest.NewRequest("GET", "http://wpt.fyi/api/platforms?browser=chrome", nil)))
+	assert.True(t, hasBrowsersParam(httptest.NewRequest("GET", "http://wpt.fyi/api/platforms?browsers=a,b", nil)))
+}
@@ -35,7 +35,10 @@ import (
 // Params:
 //   platform: Browser (and OS) of the run, e.g. "chrome-63.0" or "safari"
 //   (optional) run: SHA[0:10] of the test run, or "latest" (latest is the default)
-//   (optional) test: Path of the test, e.g. "/css/css-images-3/gradient-button.html"
+//   (optional) test: Path of the test, e.g. "/css/css-images-3/gradient-button.html" (the latest run may then be
+//       a partial run, if its scope includes the test)
+//   (optional) run_id: ID of the TestRun, which takes precedence over platform and run
+//   (optional) permalink: Redirect to the equivalent URL for the concrete run, rather than to the results
 func resultsRedirectHandler(w http.ResponseWriter, r *http.Request) {
 	params, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
@@ -43,28 +46,52 @@ func resultsRedirectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	platform := params.Get("platform")
-	if platform == "" {
-		http.Error(w, "Param 'platform' missing", http.StatusBadRequest)
+	runIDs, err := ParseRunIDsParam(r)
+	if err != nil || len(runIDs) > 1 {
+		http.Error(w, "Invalid 'run_id' param", http.StatusBadRequest)
 		return
 	}
 
-	runSHA := params.Get("sha")
-	if runSHA == "" {
-		// Legacy name, in case still present in scripts/local stores.
-		runSHA = params.Get("run")
-	}
-	if runSHA == "" {
-		runSHA = "latest"
-	}
+	var run TestRun
+	if len(runIDs) == 1 {
+		var testRuns []TestRun
+		testRuns, err = loadTestRunsByID(appengine.NewContext(r), runIDs)
+		if _, ok := err.(testRunNotFoundError); ok {
+			http.Error(w, "404 - "+err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		run = testRuns[0]
+	} else {
+		platform := params.Get("platform")
+		if platform == "" {
+			http.Error(w, "Param 'platform' missing", http.StatusBadRequest)
+			return
+		}
 
-	run, err := getRun(r, runSHA, platform)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		runSHA := params.Get("sha")
+		if runSHA == "" {
+			// Legacy name, in case still present in scripts/local stores.
+			runSHA = params.Get("run")
+		}
+		if runSHA == "" {
+			runSHA = "latest"
+		}
+
+		if run, err = getRun(r, runSHA, platform, params.Get("test")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if run.ID == 0 {
+			http.Error(w, fmt.Sprintf("404 - Test run '%s' not found", runSHA), http.StatusNotFound)
+			return
+		}
 	}
-	if (TestRun{}) == run {
-		http.Error(w, fmt.Sprintf("404 - Test run '%s' not found", runSHA), http.StatusNotFound)
+
+	if ParseBooleanParam(r, "permalink") {
+		redirectToPermalink(w, r, []TestRun{run})
 		return
 	}
 
@@ -74,7 +101,9 @@ func resultsRedirectHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, resultsURL, http.StatusFound)
 }
 
-func getRun(r *http.Request, run string, platform string) (latest TestRun, err error) {
+// getRun returns the most recent run of the platform at the given SHA (or "latest"), which must be a full run,
+// unless a test is given, in which case it may be a partial run whose scope includes the test.
+func getRun(r *http.Request, run string, platform string, test string) (latest TestRun, err error) {
 	platformPieces := strings.Split(platform, "-")
 	if len(platformPieces) < 1 || len(platformPieces) > 4 {
 		err = errors.New("Invalid path")
@@ -82,9 +111,8 @@ func getRun(r *http.Request, run string, platform string) (latest TestRun, err e
 	}
 
 	ctx := appengine.NewContext(r)
-	baseQuery := datastore.NewQuery("TestRun").Order("-CreatedAt").Limit(1)
+	baseQuery := datastore.NewQuery("TestRun").Order("-CreatedAt")
 
-	var testRunResults []TestRun
 	query := baseQuery.Filter("BrowserName =", platformPieces[0])
 	if run != "" && run != "latest" {
 		query = query.Filter("Revision =", run)
@@ -98,14 +126,18 @@ func getRun(r *http.Request, run string, platform string) (latest TestRun, err e
 	if len(platformPieces) > 3 {
 		query = query.Filter("OSVersion =", platformPieces[3])
 	}
-	_, err = query.GetAll(ctx, &testRunResults)
-	if err != nil {
-		return
+	include := isDefaultRun
+	if test != "" && test != "/" {
+		test = "/" + strings.TrimPrefix(test, "/")
+		include = func(testRun TestRun) bool {
+			return testRun.IsComplete() && testRun.Covers(test)
+		}
 	}
-	if len(testRunResults) > 0 {
-		latest = testRunResults[0]
+	testRun, err := getFirstTestRun(ctx, query, include)
+	if err != nil || testRun == nil {
+		return
 	}
-	return
+	return *testRun, nil
 }
 
 func getResultsURL(run TestRun, testFile string) (resultsURL string) {
@@ -113,10 +145,16 @@ func getResultsURL(run TestRun, testFile string) (resultsURL string) {
 	if testFile != "" && testFile != "/" {
 		// Assumes that result files are under a directory named SHA[0:10].
 		resultsBase := strings.SplitAfter(resultsURL, "/"+run.Revision)[0]
-		resultsPieces := strings.Split(resultsURL, "/")
-		re := regexp.MustCompile("(-summary)?\\.json\\.gz$")
-		platform := re.ReplaceAllString(resultsPieces[len(resultsPieces)-1], "")
-		resultsURL = fmt.Sprintf("%s/%s/%s", resultsBase, platform, testFile)
+		testFile = strings.TrimPrefix(testFile, "/")
+		resultsURL = fmt.Sprintf("%s/%s/%s", resultsBase, getResultsPlatform(run), testFile)
 	}
 	return resultsURL
 }
+
+// getResultsPlatform returns the platform name used in the run's results file names, e.g. "chrome-63.0-linux" for
+// the summary file chrome-63.0-linux-summary.json.gz.
+func getResultsPlatform(run TestRun) string {
+	resultsPieces := strings.Split(run.ResultsURL, "/")
+	re := regexp.MustCompile("(-summary)?\\.json\\.gz$")
+	return re.ReplaceAllString(resultsPieces[len(resultsPieces)-1], "")
+}
@@ -69,6 +69,19 @@ func TestGetResultsURL_TrailingSlash(t *testing.T) {
 		})
 }
 
+func TestGetResultsURL_LeadingSlash(t *testing.T) {
+	checkResult(
+		t,
+		Case{
+			TestRun{
+				ResultsURL: resultsURL,
+				Revision:   sha,
+			},
+			"/dom/a.html",
+			resultsURLBase + platform + "/dom/a.html",
+		})
+}
+
 func checkResult(t *testing.T, c Case) {
 	got := getResultsURL(c.testRun, c.testFile)
 	if got != c.expected {
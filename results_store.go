@@ -0,0 +1,309 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// resultsDirEnvVar names a local directory to read results files from (see localResultsStore), instead of
+// fetching them from the runs' results URLs.
+const resultsDirEnvVar = "WPTD_RESULTS_DIR"
+
+// resultsBucketEnvVar names the Google Cloud Storage bucket which results files are written to (see
+// gcsResultsWriter), instead of the default.
+const resultsBucketEnvVar = "WPTD_RESULTS_BUCKET"
+
+// defaultResultsBucket is the bucket which results files are written to by default.
+const defaultResultsBucket = "wptd"
+
+// gcsAPIURL is the base URL of the Google Cloud Storage JSON API.
+const gcsAPIURL = "https://www.googleapis.com"
+
+// gcsWriteScope is the OAuth scope needed to write objects to Google Cloud Storage.
+const gcsWriteScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// errResultsNotFound is returned by a ResultsStore for a results file which doesn't exist.
+var errResultsNotFound = errors.New("results not found")
+
+// ResultsStore provides the results files of TestRuns, i.e. each run's summary and its per-test results.
+type ResultsStore interface {
+	// Open opens the (decompressed) results JSON of the run for the given test path, or the run's summary when
+	// test is empty. It returns errResultsNotFound if there is no such file.
+	Open(ctx context.Context, run TestRun, test string) (io.ReadCloser, error)
+}
+
+// ResultsWriter stores results files, e.g. the summaries of runs assembled from shards (see run_sessions.go).
+type ResultsWriter interface {
+	// Write stores the gzipped results file at the given path, relative to the root of the results bucket (i.e.
+	// starting with the run's SHA), returning its URL.
+	Write(ctx context.Context, name string, gzipped []byte) (string, error)
+}
+
+// getResultsWriter returns the local results store named by the WPTD_RESULTS_DIR environment variable, if set, and
+// otherwise a writer for the results bucket (named by WPTD_RESULTS_BUCKET, or wptd).
+func getResultsWriter() ResultsWriter {
+	if dir := os.Getenv(resultsDirEnvVar); dir != "" {
+		return localResultsStore{dir: dir}
+	}
+	bucket := os.Getenv(resultsBucketEnvVar)
+	if bucket == "" {
+		bucket = defaultResultsBucket
+	}
+	return gcsResultsWriter{
+		bucket: bucket,
+		api:    gcsAPIURL,
+		client: urlfetch.Client,
+		token: func(ctx context.Context) (string, error) {
+			token, _, err := appengine.AccessToken(ctx, gcsWriteScope)
+			return token, err
+		},
+	}
+}
+
+// getResultsStore returns the local results store named by the WPTD_RESULTS_DIR environment variable, if set, and
+// otherwise the store which fetches the runs' results URLs (relative to the given request's host).
+func getResultsStore(ctx context.Context, r *http.Request) ResultsStore {
+	if dir := os.Getenv(resultsDirEnvVar); dir != "" {
+		return localResultsStore{dir: dir}
+	}
+	store := httpResultsStore{client: urlfetch.Client}
+	if r != nil && r.Host != "" {
+		store.base = &url.URL{Scheme: "https", Host: r.Host}
+		if r.TLS == nil {
+			store.base.Scheme = "http"
+		}
+	}
+	return store
+}
+
+// NewHTTPResultsStore returns a ResultsStore which fetches the runs' results URLs with the given client, resolving
+// relative URLs against base (when non-nil).
+func NewHTTPResultsStore(client *http.Client, base *url.URL) ResultsStore {
+	return httpResultsStore{
+		client: func(ctx context.Context) *http.Client { return client },
+		base:   base,
+	}
+}
+
+// NewLocalResultsStore returns a ResultsStore which reads results files from the given directory, laid out in the
+// same way as the results bucket (see localResultsStore).
+func NewLocalResultsStore(dir string) ResultsStore {
+	return localResultsStore{dir: dir}
+}
+
+// httpResultsStore is a ResultsStore which fetches results files from the runs' results URLs (see getResultsURL),
+// e.g. from Google Cloud Storage.
+type httpResultsStore struct {
+	// client returns the HTTP client for the given context.
+	client func(ctx context.Context) *http.Client
+
+	// base resolves relative results URLs (e.g. for runs in the development server's static dir), when non-nil.
+	base *url.URL
+}
+
+func (s httpResultsStore) Open(ctx context.Context, run TestRun, test string) (io.ReadCloser, error) {
+	resultsURL, err := url.Parse(strings.TrimSpace(getResultsURL(run, test)))
+	if err != nil {
+		return nil, err
+	}
+	if s.base != nil {
+		resultsURL = s.base.ResolveReference(resultsURL)
+	}
+
+	resp, err := s.client(ctx).Get(resultsURL.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errResultsNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned HTTP status %d:\n%s", resultsURL, resp.StatusCode, string(body))
+	}
+	return maybeGunzip(resp.Body)
+}
+
+// gcsResultsWriter is a ResultsWriter which uploads results files to a Google Cloud Storage bucket, through the
+// JSON API, authenticated as the app's service account.
+type gcsResultsWriter struct {
+	bucket string
+
+	// api is the base URL of the JSON API.
+	api string
+
+	// client returns the HTTP client for the given context.
+	client func(ctx context.Context) *http.Client
+
+	// token returns an OAuth access token with the gcsWriteScope.
+	token func(ctx context.Context) (string, error)
+}
+
+func (w gcsResultsWriter) Write(ctx context.Context, name string, gzipped []byte) (string, error) {
+	token, err := w.token(ctx)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{}
+	params.Set("uploadType", "media")
+	params.Set("name", name)
+	params.Set("contentEncoding", "gzip")
+	uploadURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", w.api, url.PathEscape(w.bucket), params.Encode())
+	req, err := http.NewRequest("POST", uploadURL, bytes.NewReader(gzipped))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", getResultsContentType(name))
+	resp, err := w.client(ctx).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to write gs://%s/%s (HTTP status %d):\n%s", w.bucket, name, resp.StatusCode,
+			string(body))
+	}
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", w.bucket, name), nil
+}
+
+// getResultsContentType returns the content type of a stored results file: runs' archives (see getArchiveFile) are
+// tars, and everything else is JSON.
+func getResultsContentType(name string) string {
+	if strings.HasSuffix(name, ".tar") {
+		return "application/x-tar"
+	}
+	return "application/json"
+}
+
+// localResultsStore is a ResultsStore which reads results files from a local directory, laid out in the same way
+// as the results bucket, i.e. <dir>/<sha>/<platform>-summary.json.gz and <dir>/<sha>/<platform>/<test>. Files may
+// be gzipped or not.
+type localResultsStore struct {
+	dir string
+}
+
+func (s localResultsStore) Open(ctx context.Context, run TestRun, test string) (io.ReadCloser, error) {
+	resultsPath, err := getResultsPath(run, test)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(resultsPath)))
+	if os.IsNotExist(err) {
+		return nil, errResultsNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return maybeGunzip(f)
+}
+
+// Write stores the file under the directory, returning its path (relative to the directory) as an absolute URL
+// path, e.g. /abcdef0123/chrome-63.0-linux-summary.json.gz.
+func (s localResultsStore) Write(ctx context.Context, name string, gzipped []byte) (string, error) {
+	clean := path.Clean("/" + name)
+	file := filepath.Join(s.dir, filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(file, gzipped, 0644); err != nil {
+		return "", err
+	}
+	return clean, nil
+}
+
+// getResultsPath returns the path of the results file for the given run and test (or summary, when test is empty)
+// relative to the root of the results bucket, i.e. starting with the run's SHA.
+func getResultsPath(run TestRun, test string) (string, error) {
+	resultsURL, err := url.Parse(strings.TrimSpace(getResultsURL(run, test)))
+	if err != nil {
+		return "", err
+	}
+	// Clean before splitting, so that the path can't escape the SHA's directory.
+	resultsPath := path.Clean("/" + resultsURL.Path)
+	shaDir := "/" + run.Revision + "/"
+	i := strings.Index(resultsPath, shaDir)
+	if run.Revision == "" || i < 0 {
+		return "", fmt.Errorf("results URL %s isn't under a directory named %s", run.ResultsURL, run.Revision)
+	}
+	return resultsPath[i+1:], nil
+}
+
+// gzipData returns the gzipped data, e.g. to store with a ResultsWriter.
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gzipReadCloser closes both the gzip reader and the underlying reader.
+type gzipReadCloser struct {
+	*gzip.Reader
+	underlying io.Closer
+}
+
+func (r gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.underlying.Close()
+}
+
+// bufferedReadCloser reads through a buffer, closing the underlying reader.
+type bufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// maybeGunzip returns a reader of the decompressed content, if the reader's content is gzipped.
+func maybeGunzip(rc io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(rc)
+	magic, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return bufferedReadCloser{buffered, rc}, nil
+	}
+	zr, err := gzip.NewReader(buffered)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return gzipReadCloser{zr, rc}, nil
+}
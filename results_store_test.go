@@ -0,0 +1,164 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func gzipBytes(data string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func readAllString(t *testing.T, store ResultsStore, run TestRun, test string) string {
+	f, err := store.Open(context.Background(), run, test)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestGetResultsPath(t *testing.T) {
+	run := TestRun{ResultsURL: resultsURL, Revision: sha}
+	summaryPath, err := getResultsPath(run, "")
+	assert.Nil(t, err)
+	assert.Equal(t, sha+"/"+platform+"-summary.json.gz", summaryPath)
+
+	testPath, err := getResultsPath(run, "/dom/a.html")
+	assert.Nil(t, err)
+	assert.Equal(t, sha+"/"+platform+"/dom/a.html", testPath)
+
+	_, err = getResultsPath(TestRun{ResultsURL: "https://example.com/results.json", Revision: sha}, "")
+	assert.NotNil(t, err)
+}
+
+func TestLocalResultsStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, sha, platform, "dom"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, sha, platform+"-summary.json.gz"), gzipBytes(`{"/dom/a.html":[1,1]}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, sha, platform, "dom", "a.html"), []byte(`{"test":"/dom/a.html"}`), 0644)
+
+	store := localResultsStore{dir: dir}
+	run := TestRun{ResultsURL: resultsURL, Revision: sha}
+	assert.Equal(t, `{"/dom/a.html":[1,1]}`, readAllString(t, store, run, ""))
+	assert.Equal(t, `{"test":"/dom/a.html"}`, readAllString(t, store, run, "/dom/a.html"))
+
+	_, err = store.Open(context.Background(), run, "/dom/missing.html")
+	assert.Equal(t, errResultsNotFound, err)
+	_, err = store.Open(context.Background(), run, "/../../../etc/passwd")
+	assert.NotNil(t, err)
+}
+
+func TestHTTPResultsStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/static/" + sha + "/" + platform + "-summary.json.gz":
+			w.Write(gzipBytes(`{"/a.html":[0,1]}`))
+		case "/static/" + sha + "/" + platform + "/a.html":
+			w.Write([]byte(`{}`))
+		case "/static/" + sha + "/" + platform + "/error.html":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	store := httpResultsStore{
+		client: func(ctx context.Context) *http.Client { return http.DefaultClient },
+		base:   base,
+	}
+	run := TestRun{ResultsURL: "/static/" + sha + "/" + platform + "-summary.json.gz", Revision: sha}
+	assert.Equal(t, `{"/a.html":[0,1]}`, readAllString(t, store, run, ""))
+	assert.Equal(t, `{}`, readAllString(t, store, run, "/a.html"))
+
+	_, err := store.Open(context.Background(), run, "/missing.html")
+	assert.Equal(t, errResultsNotFound, err)
+	_, err = store.Open(context.Background(), run, "/error.html")
+	assert.NotNil(t, err)
+}
+
+func TestLocalResultsStore_Write(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := localResultsStore{dir: dir}
+	written, err := store.Write(context.Background(), sha+"/"+platform+"-summary.json.gz", gzipBytes(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "/"+sha+"/"+platform+"-summary.json.gz", written)
+	assert.Equal(t, `{}`, readAllString(t, store, TestRun{ResultsURL: written, Revision: sha}, ""))
+
+	// Paths can't escape the directory.
+	written, err = store.Write(context.Background(), "../../escaped.json.gz", gzipBytes(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "/escaped.json.gz", written)
+	_, err = os.Stat(filepath.Join(dir, "escaped.json.gz"))
+	assert.Nil(t, err)
+}
+
+func TestGCSResultsWriter(t *testing.T) {
+	var uploaded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/upload/storage/v1/b/bucket/o", r.URL.Path)
+		assert.Equal(t, sha+"/"+platform+"-summary.json.gz", r.URL.Query().Get("name"))
+		assert.Equal(t, "gzip", r.URL.Query().Get("contentEncoding"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		uploaded, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	writer := gcsResultsWriter{
+		bucket: "bucket",
+		api:    server.URL,
+		client: func(ctx context.Context) *http.Client { return http.DefaultClient },
+		token:  func(ctx context.Context) (string, error) { return "token", nil },
+	}
+	written, err := writer.Write(context.Background(), sha+"/"+platform+"-summary.json.gz", gzipBytes(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "https://storage.googleapis.com/bucket/"+sha+"/"+platform+"-summary.json.gz", written)
+	assert.Equal(t, gzipBytes(`{}`), uploaded)
+
+	writer.token = func(ctx context.Context) (string, error) { return "expired", nil }
+	_, err = writer.Write(context.Background(), sha+"/"+platform+"-summary.json.gz", gzipBytes(`{}`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,378 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// TestCounts is the pair of counts stored for a single test file. In a results summary, it is
+// [count-passed, total-tests]; in a diff, it is [count-different-tests, total-tests].
+type TestCounts [2]int32
+
+// ResultsSummary is a compact, read-only representation of a results summary file (test path to TestCounts).
+// Tests are kept sorted by path, in a pair of parallel slices, so lookups are a binary search and diffing two
+// summaries is a single merge pass. Path strings are interned across summaries, so holding summaries for many
+// runs (e.g. in the summary cache) only stores each test path once.
+type ResultsSummary struct {
+	tests  []string
+	counts []TestCounts
+}
+
+// maxInternedPaths bounds the size of the interned paths table, since summaries can be POSTed by anyone.
+// The WPT repo has fewer than 30k test files, so real summaries fit comfortably.
+const maxInternedPaths = 200000
+
+var internedPaths = struct {
+	sync.Mutex
+	paths map[string]string
+}{paths: make(map[string]string)}
+
+// internPath returns the canonical copy of the given test path.
+func internPath(path string) string {
+	internedPaths.Lock()
+	defer internedPaths.Unlock()
+	if interned, ok := internedPaths.paths[path]; ok {
+		return interned
+	}
+	if len(internedPaths.paths) < maxInternedPaths {
+		internedPaths.paths[path] = path
+	}
+	return path
+}
+
+// ParseResultsSummary decodes a results summary JSON object (test path to [count-passed, total-tests]) from the
+// given reader, without buffering the whole body or allocating a slice per test.
+func ParseResultsSummary(r io.Reader) (*ResultsSummary, error) {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, err
+	}
+	summary := &ResultsSummary{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		test, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected test path, got %v", token)
+		}
+		var counts TestCounts
+		if err = expectDelim(decoder, '['); err != nil {
+			return nil, err
+		}
+		for i := range counts {
+			if counts[i], err = decodeCount(decoder); err != nil {
+				return nil, fmt.Errorf("invalid results for %s: %s", test, err.Error())
+			}
+		}
+		if err = expectDelim(decoder, ']'); err != nil {
+			return nil, fmt.Errorf("invalid results for %s: %s", test, err.Error())
+		}
+		summary.tests = append(summary.tests, internPath(test))
+		summary.counts = append(summary.counts, counts)
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, err
+	}
+	summary.sort()
+	return summary, nil
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+func decodeCount(decoder *json.Decoder) (int32, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return 0, err
+	}
+	number, ok := token.(float64)
+	if !ok || number < 0 || number != float64(int32(number)) {
+		return 0, fmt.Errorf("expected count, got %v", token)
+	}
+	return int32(number), nil
+}
+
+// NewResultsSummary converts a map of test path to [count-passed, total-tests] into a ResultsSummary.
+func NewResultsSummary(results map[string][]int) *ResultsSummary {
+	summary := &ResultsSummary{
+		tests:  make([]string, 0, len(results)),
+		counts: make([]TestCounts, 0, len(results)),
+	}
+	for test, counts := range results {
+		summary.tests = append(summary.tests, internPath(test))
+		summary.counts = append(summary.counts, TestCounts{int32(counts[0]), int32(counts[1])})
+	}
+	summary.sort()
+	return summary
+}
+
+// ToMap converts the summary back into a map of test path to counts.
+func (s *ResultsSummary) ToMap() map[string][]int {
+	results := make(map[string][]int, s.Len())
+	if s == nil {
+		return results
+	}
+	for i, test := range s.tests {
+		results[test] = []int{int(s.counts[i][0]), int(s.counts[i][1])}
+	}
+	return results
+}
+
+// Len returns the number of tests in the summary. Like Get, Filter and ToMap, it treats a nil summary as empty, e.g.
+// the regressions of a browser's first run (see getRegressionsSincePreviousRun).
+func (s *ResultsSummary) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.tests)
+}
+
+// At returns the path and counts of the i'th test, in path order. It panics unless 0 <= i < s.Len().
+func (s *ResultsSummary) At(i int) (string, TestCounts) {
+	if s == nil {
+		panic(fmt.Sprintf("index %d out of range of empty ResultsSummary", i))
+	}
+	return s.tests[i], s.counts[i]
+}
+
+// Get returns the counts for the given test path, and whether the test is present in the summary.
+func (s *ResultsSummary) Get(test string) (TestCounts, bool) {
+	if s == nil {
+		return TestCounts{}, false
+	}
+	i := sort.SearchStrings(s.tests, test)
+	if i < len(s.tests) && s.tests[i] == test {
+		return s.counts[i], true
+	}
+	return TestCounts{}, false
+}
+
+// Filter returns the subset of the summary for which the given predicate is true.
+func (s *ResultsSummary) Filter(include func(test string) bool) *ResultsSummary {
+	filtered := &ResultsSummary{}
+	if s == nil {
+		return filtered
+	}
+	for i, test := range s.tests {
+		if include(test) {
+			filtered.add(test, s.counts[i])
+		}
+	}
+	return filtered
+}
+
+// FilterToTests returns the subset of the summary for the tests present in the given summary, e.g. to treat the
+// tests which a partial run didn't run as not run, rather than deleted.
+func (s *ResultsSummary) FilterToTests(tests *ResultsSummary) *ResultsSummary {
+	return s.Filter(func(test string) bool {
+		_, ok := tests.Get(test)
+		return ok
+	})
+}
+
+// MarshalJSON encodes the summary as a JSON object of test path to counts, in the same format it was parsed from.
+// A nil summary is encoded as null, as encoding/json does for nil pointers.
+func (s *ResultsSummary) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(s.Len() * 64)
+	buf.WriteByte('{')
+	for i, test := range s.tests {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(&buf, test)
+		buf.WriteString(":[")
+		buf.WriteString(strconv.Itoa(int(s.counts[i][0])))
+		buf.WriteByte(',')
+		buf.WriteString(strconv.Itoa(int(s.counts[i][1])))
+		buf.WriteByte(']')
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes the summary from a JSON object of test path to counts.
+func (s *ResultsSummary) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseResultsSummary(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	*s = *parsed
+	return nil
+}
+
+// writeJSONString writes the given string as a quoted JSON string.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf.WriteString(`\ufffd`)
+			} else if r == '\u2028' || r == '\u2029' {
+				buf.WriteString(`\u202`)
+				buf.WriteByte(hex[r&0xF])
+			} else {
+				buf.WriteString(s[i : i+size])
+			}
+			i += size
+			continue
+		}
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < 0x20 || c == '<' || c == '>' || c == '&':
+			buf.WriteString(`\u00`)
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&0xF])
+		default:
+			buf.WriteByte(c)
+		}
+		i++
+	}
+	buf.WriteByte('"')
+}
+
+func (s *ResultsSummary) add(test string, counts TestCounts) {
+	s.tests = append(s.tests, test)
+	s.counts = append(s.counts, counts)
+}
+
+// sort orders the tests by path, keeping only the last occurrence of any duplicated path (matching the behaviour
+// of json.Unmarshal into a map).
+func (s *ResultsSummary) sort() {
+	if sort.StringsAreSorted(s.tests) {
+		s.dedupe()
+		return
+	}
+	sort.Stable(summaryByPath{s})
+	s.dedupe()
+}
+
+func (s *ResultsSummary) dedupe() {
+	if len(s.tests) < 2 {
+		return
+	}
+	n := 0
+	for i := range s.tests {
+		if i+1 < len(s.tests) && s.tests[i] == s.tests[i+1] {
+			continue
+		}
+		s.tests[n], s.counts[n] = s.tests[i], s.counts[i]
+		n++
+	}
+	s.tests, s.counts = s.tests[:n], s.counts[:n]
+}
+
+type summaryByPath struct {
+	*ResultsSummary
+}
+
+func (s summaryByPath) Less(i, j int) bool {
+	return s.tests[i] < s.tests[j]
+}
+
+func (s summaryByPath) Swap(i, j int) {
+	s.tests[i], s.tests[j] = s.tests[j], s.tests[i]
+	s.counts[i], s.counts[j] = s.counts[j], s.counts[i]
+}
+
+// DiffResultsSummaries returns the tests which had different results counts between the two summaries, with
+// counts of [count-different-tests, total-tests] (see getResultsDiff). It walks both (sorted) summaries in a single
+// merge pass, so the only allocations are for the tests included in the diff.
+func DiffResultsSummaries(before, after *ResultsSummary, filter DiffFilterParam) *ResultsSummary {
+	diff := &ResultsSummary{}
+	i, j := 0, 0
+	for i < before.Len() || j < after.Len() {
+		switch {
+		case j >= after.Len() || (i < before.Len() && before.tests[i] < after.tests[j]):
+			// Missing? Then N / N tests are 'different'.
+			if filter.Deleted {
+				total := before.counts[i][1]
+				diff.add(before.tests[i], TestCounts{total, total})
+			}
+			i++
+		case i >= before.Len() || after.tests[j] < before.tests[i]:
+			// Missing? Then N / N tests are 'different'.
+			if filter.Added {
+				total := after.counts[j][1]
+				diff.add(after.tests[j], TestCounts{total, total})
+			}
+			j++
+		default:
+			if filter.Changed {
+				resultsBefore, resultsAfter := before.counts[i], after.counts[j]
+				passDiff := abs(int(resultsBefore[0] - resultsAfter[0]))
+				countDiff := abs(int(resultsBefore[1] - resultsAfter[1]))
+				// Changed tests is at most the number of different outcomes,
+				// but newly introduced tests should still be counted (e.g. 0/2 => 0/5)
+				if passDiff != 0 || countDiff != 0 {
+					diff.add(before.tests[i], TestCounts{
+						int32(max(passDiff, countDiff)),
+						int32(max(int(resultsBefore[1]), int(resultsAfter[1]))),
+					})
+				}
+			}
+			i++
+			j++
+		}
+	}
+	return diff
+}
+
+// RegressionsBetween returns the tests present in both summaries which have fewer passing results in the after
+// summary, with counts of [count-newly-failing, total-tests].
+func RegressionsBetween(before, after *ResultsSummary) *ResultsSummary {
+	regressions := &ResultsSummary{}
+	for i, j := 0, 0; i < before.Len() && j < after.Len(); {
+		switch {
+		case before.tests[i] < after.tests[j]:
+			i++
+		case after.tests[j] < before.tests[i]:
+			j++
+		default:
+			if lost := before.counts[i][0] - after.counts[j][0]; lost > 0 {
+				regressions.add(before.tests[i], TestCounts{lost, after.counts[j][1]})
+			}
+			i++
+			j++
+		}
+	}
+	return regressions
+}
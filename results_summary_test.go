@@ -0,0 +1,180 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResultsSummary(t *testing.T) {
+	summary, err := ParseResultsSummary(strings.NewReader(`{
+		"/b.html": [1, 2],
+		"/a.html": [0, 1],
+		"/c/d.html": [5, 10]
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, summary.Len())
+
+	test, counts := summary.At(0)
+	assert.Equal(t, "/a.html", test)
+	assert.Equal(t, TestCounts{0, 1}, counts)
+
+	counts, ok := summary.Get("/c/d.html")
+	assert.True(t, ok)
+	assert.Equal(t, TestCounts{5, 10}, counts)
+	_, ok = summary.Get("/missing.html")
+	assert.False(t, ok)
+}
+
+func TestParseResultsSummary_Empty(t *testing.T) {
+	summary, err := ParseResultsSummary(strings.NewReader(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, summary.Len())
+}
+
+func TestParseResultsSummary_DuplicateKeepsLast(t *testing.T) {
+	summary, err := ParseResultsSummary(strings.NewReader(`{"/a.html": [0, 1], "/b.html": [1, 1], "/a.html": [1, 1]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{"/a.html": {1, 1}, "/b.html": {1, 1}}, summary.ToMap())
+}
+
+func TestParseResultsSummary_Invalid(t *testing.T) {
+	for _, body := range []string{
+		``,
+		`[]`,
+		`{"/a.html": 1}`,
+		`{"/a.html": [1]}`,
+		`{"/a.html": [1, 2, 3]}`,
+		`{"/a.html": [1.5, 2]}`,
+		`{"/a.html": [-1, 2]}`,
+		`{"/a.html": ["1", 2]}`,
+		`{"/a.html": [1, 2]`,
+	} {
+		_, err := ParseResultsSummary(strings.NewReader(body))
+		assert.NotNil(t, err, body)
+	}
+}
+
+func TestResultsSummary_MarshalJSON(t *testing.T) {
+	results := map[string][]int{
+		"/a.html":          {0, 1},
+		"/quote\"<&>.html": {1, 1},
+		"/unicode/é.html":  {2, 3},
+		"/separator .html": {3, 3},
+	}
+	expected, _ := json.Marshal(results)
+	actual, err := json.Marshal(NewResultsSummary(results))
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(actual))
+
+	var roundTrip ResultsSummary
+	assert.Nil(t, json.Unmarshal(actual, &roundTrip))
+	assert.Equal(t, results, roundTrip.ToMap())
+}
+
+func TestResultsSummary_InternsPaths(t *testing.T) {
+	summary, _ := ParseResultsSummary(strings.NewReader(`{"/interned.html": [0, 1]}`))
+	path, _ := summary.At(0)
+	internedPaths.Lock()
+	interned, ok := internedPaths.paths[path]
+	internedPaths.Unlock()
+	assert.True(t, ok)
+	assert.Equal(t, path, interned)
+}
+
+func TestResultsSummary_Filter(t *testing.T) {
+	summary := NewResultsSummary(map[string][]int{
+		"/dom/a.html": {1, 2},
+		"/css/b.html": {1, 2},
+	})
+	filtered := summary.Filter(func(test string) bool { return strings.HasPrefix(test, "/dom/") })
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}}, filtered.ToMap())
+}
+
+func TestResultsSummary_Nil(t *testing.T) {
+	var summary *ResultsSummary
+	assert.Equal(t, 0, summary.Len())
+	_, ok := summary.Get("/dom/a.html")
+	assert.False(t, ok)
+	assert.Equal(t, 0, summary.Filter(func(string) bool { return true }).Len())
+	assert.Equal(t, map[string][]int{}, summary.ToMap())
+	assert.Panics(t, func() { summary.At(0) })
+
+	bytes, err := summary.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, "null", string(bytes))
+}
+
+func TestResultsSummary_FilterToTests(t *testing.T) {
+	production := NewResultsSummary(map[string][]int{
+		"/dom/a.html": {1, 2},
+		"/dom/b.html": {1, 1},
+		"/css/c.html": {1, 2},
+	})
+	partial := NewResultsSummary(map[string][]int{
+		"/dom/a.html":   {0, 2},
+		"/dom/new.html": {0, 1},
+	})
+	filtered := production.FilterToTests(partial)
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}}, filtered.ToMap())
+
+	// Only the regression and the added test remain in the diff; the tests which weren't run aren't deleted.
+	diff := DiffResultsSummaries(filtered, partial, DiffFilterParam{true, true, true})
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}, "/dom/new.html": {1, 1}}, diff.ToMap())
+}
+
+func TestRegressionsBetween(t *testing.T) {
+	const regressedPath = "/mock/regressed.html"
+	const improvedPath = "/mock/improved.html"
+	const removedPath = "/mock/removed.html"
+	const addedPath = "/mock/added.html"
+
+	before := NewResultsSummary(map[string][]int{
+		regressedPath: {4, 5},
+		improvedPath:  {1, 5},
+		removedPath:   {1, 1},
+	})
+	after := NewResultsSummary(map[string][]int{
+		regressedPath: {2, 6},
+		improvedPath:  {5, 5},
+		addedPath:     {0, 1},
+	})
+	assert.Equal(t, map[string][]int{regressedPath: {2, 6}}, RegressionsBetween(before, after).ToMap())
+}
+
+func BenchmarkDiffResultsSummaries(b *testing.B) {
+	beforeMap := make(map[string][]int)
+	afterMap := make(map[string][]int)
+	for i := 0; i < 20000; i++ {
+		test := fmt.Sprintf("/dir%d/test%d.html", i%100, i)
+		beforeMap[test] = []int{i % 7, 7}
+		if i%10 != 0 {
+			afterMap[test] = []int{i % 5, 7}
+		}
+	}
+	before, after := NewResultsSummary(beforeMap), NewResultsSummary(afterMap)
+	filter := DiffFilterParam{true, true, true}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffResultsSummaries(before, after, filter)
+	}
+}
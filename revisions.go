@@ -0,0 +1,107 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// revisionKey returns the key of the Revision entity for the given SHA[0:10].
+func revisionKey(ctx context.Context, sha string) *datastore.Key {
+	return datastore.NewKey(ctx, "Revision", sha, 0, nil)
+}
+
+// getRunPlatform returns the platform string of the run, i.e. its browser (+ version) and OS (+ version),
+// e.g. "chrome-63.0-linux-4.4".
+func getRunPlatform(run TestRun) string {
+	var pieces []string
+	for _, piece := range []string{run.BrowserName, run.BrowserVersion, run.OSName, run.OSVersion} {
+		if piece != "" {
+			pieces = append(pieces, piece)
+		}
+	}
+	return strings.Join(pieces, "-")
+}
+
+// updateRevision records the given (newly stored) run on the Revision entity for its SHA, returning whether the
+// run made the revision complete. Partial and in-progress (or failed) runs aren't recorded, since they don't help
+// complete a revision.
+func updateRevision(ctx context.Context, run TestRun) (completed bool, err error) {
+	if run.Revision == "" || !isDefaultRun(run) {
+		return false, nil
+	}
+	browserNames, err := GetBrowserNames()
+	if err != nil {
+		return false, err
+	}
+	key := revisionKey(ctx, run.Revision)
+	err = datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var revision Revision
+		if err := datastore.Get(ctx, key, &revision); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		revision.SHA = run.Revision
+		completed = revision.addRun(run, browserNames)
+		_, err := datastore.Put(ctx, key, &revision)
+		return err
+	}, nil)
+	return completed, err
+}
+
+// addRun records the run's platform and time on the revision, returning true if that made the revision complete,
+// i.e. the run's browser was the last of the given browser names without a run for the revision.
+func (revision *Revision) addRun(run TestRun, browserNames []string) (completed bool) {
+	revision.Platforms = insertSorted(revision.Platforms, getRunPlatform(run))
+	revision.BrowserNames = insertSorted(revision.BrowserNames, run.BrowserName)
+	if revision.FirstRunAt.IsZero() || run.CreatedAt.Before(revision.FirstRunAt) {
+		revision.FirstRunAt = run.CreatedAt
+	}
+	if run.CreatedAt.After(revision.LatestRunAt) {
+		revision.LatestRunAt = run.CreatedAt
+	}
+	if revision.Complete || !revision.hasBrowsers(browserNames) {
+		return false
+	}
+	revision.Complete = true
+	revision.CompletedAt = run.CreatedAt
+	return true
+}
+
+// hasBrowsers determines whether the revision has runs for all of the given browser names.
+func (revision *Revision) hasBrowsers(browserNames []string) bool {
+	for _, name := range browserNames {
+		i := sort.SearchStrings(revision.BrowserNames, name)
+		if i == len(revision.BrowserNames) || revision.BrowserNames[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// insertSorted inserts the value into the sorted slice, unless it's already present.
+func insertSorted(values []string, value string) []string {
+	i := sort.SearchStrings(values, value)
+	if i < len(values) && values[i] == value {
+		return values
+	}
+	values = append(values, "")
+	copy(values[i+1:], values[i:])
+	values[i] = value
+	return values
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// revisionsMaxCountDefault is the default number of revisions emitted by /api/revisions.
+const revisionsMaxCountDefault = 100
+
+// revisionsBackfillBatchSize is the number of TestRuns processed by each /api/admin/revisions/backfill request.
+const revisionsBackfillBatchSize = 500
+
+// apiRevisionsHandler emits JSON for the most recent revisions (see Revision), i.e. which platforms have runs
+// for each, and whether they are complete.
+//
+// URL Params:
+//     complete: (optional) Only emit complete revisions, most recently completed first
+//     max-count: (optional) Maximum number of revisions to emit (default 100)
+func apiRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	maxCount, err := ParseMaxCountParamWithDefault(r, revisionsMaxCountDefault)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid 'max-count' param: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	query := datastore.NewQuery("Revision").Order("-LatestRunAt").Limit(maxCount)
+	if ParseBooleanParam(r, "complete") {
+		query = datastore.NewQuery("Revision").Filter("Complete =", true).Order("-CompletedAt").Limit(maxCount)
+	}
+	ctx := appengine.NewContext(r)
+	revisions := []Revision{}
+	if _, err = query.GetAll(ctx, &revisions); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(revisions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
+
+// revisionsBackfillProgress is the response of /api/admin/revisions/backfill.
+type revisionsBackfillProgress struct {
+	// Processed is the number of TestRuns processed by the request.
+	Processed int `json:"processed"`
+
+	// Cursor to pass to the next request, or empty once all TestRuns have been processed.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// apiAdminRevisionsBackfillHandler builds the Revision entities from the existing TestRuns, one batch per (POST)
+// request, in order of creation. It's idempotent, so it's safe to re-run, including alongside uploads.
+// Requires an admin of the app (see checkAdminRequest).
+//
+// URL Params:
+//     cursor: (optional) Cursor returned by the previous request, to process the next batch
+func apiAdminRevisionsBackfillHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkAdminRequest(ctx, w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := datastore.NewQuery("TestRun").Order("CreatedAt")
+	if param := r.URL.Query().Get("cursor"); param != "" {
+		cursor, err := datastore.DecodeCursor(param)
+		if err != nil {
+			http.Error(w, "invalid 'cursor' param: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = query.Start(cursor)
+	}
+
+	// Group the batch by revision, keeping each revision's runs in order of creation.
+	var progress revisionsBackfillProgress
+	var shas []string
+	runsBySHA := make(map[string][]TestRun)
+	it := query.Run(ctx)
+	for progress.Processed < revisionsBackfillBatchSize {
+		var testRun TestRun
+		_, err := it.Next(&testRun)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		progress.Processed++
+		if testRun.Revision == "" || !isDefaultRun(testRun) {
+			continue
+		}
+		if _, ok := runsBySHA[testRun.Revision]; !ok {
+			shas = append(shas, testRun.Revision)
+		}
+		runsBySHA[testRun.Revision] = append(runsBySHA[testRun.Revision], testRun)
+	}
+	if progress.Processed == revisionsBackfillBatchSize {
+		cursor, err := it.Cursor()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		progress.Cursor = cursor.String()
+	}
+
+	browserNames, err := GetBrowserNames()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = runConcurrently(ctx, len(shas), maxConcurrentQueries, func(ctx context.Context, i int) error {
+		return backfillRevision(ctx, shas[i], runsBySHA[shas[i]], browserNames)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(progress)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
+
+// backfillRevision records the given runs (of the same revision, in order of creation) on its Revision entity.
+func backfillRevision(ctx context.Context, sha string, testRuns []TestRun, browserNames []string) error {
+	key := revisionKey(ctx, sha)
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var revision Revision
+		if err := datastore.Get(ctx, key, &revision); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		revision.SHA = sha
+		for _, testRun := range testRuns {
+			revision.addRun(testRun, browserNames)
+		}
+		_, err := datastore.Put(ctx, key, &revision)
+		return err
+	}, nil)
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRunPlatform(t *testing.T) {
+	assert.Equal(t, "chrome-63.0-linux-4.4", getRunPlatform(TestRun{
+		BrowserName:    "chrome",
+		BrowserVersion: "63.0",
+		OSName:         "linux",
+		OSVersion:      "4.4",
+	}))
+	assert.Equal(t, "safari-11.0-macos", getRunPlatform(TestRun{
+		BrowserName:    "safari",
+		BrowserVersion: "11.0",
+		OSName:         "macos",
+	}))
+}
+
+func TestRevisionAddRun(t *testing.T) {
+	browserNames := []string{"chrome", "firefox"}
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	revision := Revision{SHA: "abcdef0123"}
+
+	assert.False(t, revision.addRun(TestRun{BrowserName: "firefox", BrowserVersion: "57.0", CreatedAt: start}, browserNames))
+	assert.False(t, revision.Complete)
+	assert.False(t, revision.addRun(TestRun{BrowserName: "firefox", BrowserVersion: "57.0", CreatedAt: start}, browserNames))
+	assert.Equal(t, []string{"firefox-57.0"}, revision.Platforms)
+
+	completedAt := start.Add(time.Hour)
+	assert.True(t, revision.addRun(TestRun{BrowserName: "chrome", BrowserVersion: "63.0", CreatedAt: completedAt}, browserNames))
+	assert.True(t, revision.Complete)
+	assert.Equal(t, completedAt, revision.CompletedAt)
+	assert.Equal(t, []string{"chrome", "firefox"}, revision.BrowserNames)
+	assert.Equal(t, []string{"chrome-63.0", "firefox-57.0"}, revision.Platforms)
+
+	// Already complete.
+	later := start.Add(2 * time.Hour)
+	assert.False(t, revision.addRun(TestRun{BrowserName: "chrome", BrowserVersion: "64.0", CreatedAt: later}, browserNames))
+	assert.Equal(t, completedAt, revision.CompletedAt)
+	assert.Equal(t, start, revision.FirstRunAt)
+	assert.Equal(t, later, revision.LatestRunAt)
+}
+
+func TestRevisionHasBrowsers(t *testing.T) {
+	revision := Revision{BrowserNames: []string{"chrome", "firefox"}}
+	assert.True(t, revision.hasBrowsers([]string{"firefox", "chrome"}))
+	assert.True(t, revision.hasBrowsers(nil))
+	assert.False(t, revision.hasBrowsers([]string{"chrome", "edge"}))
+}
+
+func TestInsertSorted(t *testing.T) {
+	var values []string
+	values = insertSorted(values, "b")
+	values = insertSorted(values, "a")
+	values = insertSorted(values, "c")
+	values = insertSorted(values, "b")
+	assert.Equal(t, []string{"a", "b", "c"}, values)
+}
@@ -0,0 +1,370 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// archiveRunTask is the name of the task which builds and stores the archive of a run (see buildRunArchive).
+const archiveRunTask = "archive-run"
+
+// archiveFetchParallelism is the maximum number of results files fetched concurrently while building an archive.
+const archiveFetchParallelism = 8
+
+// archiveFileTimeout is the deadline for fetching a single results file while building an archive.
+const archiveFileTimeout = summaryFetchTimeout
+
+// archiveRequestTimeout is how long after a run's archive was requested it's requested again, if it still hasn't
+// been stored (e.g. because the task ran out of attempts).
+const archiveRequestTimeout = time.Hour
+
+// archivePollInterval is the Retry-After of the response to a request for an archive which is being built.
+const archivePollInterval = time.Minute
+
+// archiveDir is the directory within a run's results directory which its archive is stored in. Like artifactsDir,
+// it's named so that it can't clash with a WPT directory.
+const archiveDir = "/__archive__"
+
+// archiveRunPayload is the payload of an archiveRunTask.
+type archiveRunPayload struct {
+	RunID int64 `json:"run_id"`
+}
+
+func init() {
+	registerTask(archiveRunTask, buildRunArchive)
+}
+
+// apiTestRunArchiveHandler serves a tar of all of a run's results files: its summary, as <platform>-summary.json,
+// and the results of each test in the summary, as <platform>/<test path>. Archives are built in the background
+// (see buildRunArchive) and stored with the run's results, so the first request for a run's archive responds with
+// 202 Accepted, and requests once it's stored are redirected to it (or, for a local results directory, served
+// from it).
+//
+// URL Params:
+//     run: platform@SHA[0:10] of the run, e.g. chrome@abcdef0123, or just the platform for the latest run
+//     run_id: (optional) ID of the run, instead of the run param
+func apiTestRunArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	run, ok := loadRunSpecParam(ctx, w, r)
+	if !ok {
+		return
+	} else if !run.IsComplete() {
+		http.Error(w, fmt.Sprintf("Run %d isn't complete", run.ID), http.StatusConflict)
+		return
+	}
+
+	if run.ArchiveURL == "" {
+		if err := requestRunArchive(ctx, run.ID, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(archivePollInterval.Seconds())))
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "The archive of run %d is being built; try again later.\n", run.ID)
+		return
+	}
+
+	if strings.HasPrefix(run.ArchiveURL, "https://") || strings.HasPrefix(run.ArchiveURL, "http://") {
+		http.Redirect(w, r, run.ArchiveURL, http.StatusFound)
+		return
+	}
+	// Stored in a local results directory (see localResultsStore), which isn't served.
+	data, err := readResultsFile(ctx, getResultsStore(ctx, r), run, getArchiveFile(run))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, path.Base(getArchiveFile(run))))
+	http.ServeContent(w, r, "", run.CreatedAt, bytes.NewReader(data))
+}
+
+// getArchiveFile returns the path of the run's archive relative to the run's results directory.
+func getArchiveFile(run TestRun) string {
+	return fmt.Sprintf("%s/%s-%s.tar", archiveDir, getResultsPlatform(run), run.Revision)
+}
+
+// requestArchive records that the run's archive was requested at the given time, returning whether it needs to
+// be built, i.e. it isn't stored and hasn't been requested in the last archiveRequestTimeout.
+func (run *TestRun) requestArchive(now time.Time) bool {
+	if run.ArchiveURL != "" || now.Sub(run.ArchiveRequestedAt) < archiveRequestTimeout {
+		return false
+	}
+	run.ArchiveRequestedAt = now
+	return true
+}
+
+// requestRunArchive enqueues an archiveRunTask for the run with the given ID, unless its archive is stored or
+// already requested (see requestArchive). The task is enqueued in the same transaction, so that concurrent requests
+// enqueue it once.
+func requestRunArchive(ctx context.Context, runID int64, now time.Time) error {
+	key := datastore.NewKey(ctx, "TestRun", "", runID, nil)
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var run TestRun
+		if err := datastore.Get(ctx, key, &run); err == datastore.ErrNoSuchEntity {
+			return testRunNotFoundError(runID)
+		} else if err != nil {
+			return err
+		}
+		if !run.requestArchive(now) {
+			return nil
+		}
+		if _, err := datastore.Put(ctx, key, &run); err != nil {
+			return err
+		}
+		return getTaskQueue().Enqueue(ctx, archiveRunTask, archiveRunPayload{RunID: runID})
+	}, &datastore.TransactionOptions{XG: true})
+}
+
+// buildRunArchive builds the archive of a run (see writeRunArchive), which is too slow to do during a request, stores
+// it gzipped with the run's results (see getArchiveFile), and records its URL on the run.
+func buildRunArchive(ctx context.Context, r *http.Request, payload []byte) error {
+	var task archiveRunPayload
+	if err := json.Unmarshal(payload, &task); err != nil {
+		return err
+	}
+	testRuns, err := loadTestRunsByID(ctx, []int64{task.RunID})
+	if _, ok := err.(testRunNotFoundError); ok {
+		// The run has since been deleted; there's nothing left to do.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	run := testRuns[0]
+	if run.ArchiveURL != "" {
+		return nil
+	}
+
+	resultsPath, err := getResultsPath(run, getArchiveFile(run))
+	if err != nil {
+		return err
+	}
+	var archive bytes.Buffer
+	if err = writeRunArchive(ctx, &archive, getResultsStore(ctx, r), run); err != nil {
+		return fmt.Errorf("failed to build archive of run %d: %s", run.ID, err.Error())
+	}
+	archiveURL, err := getResultsWriter().Write(ctx, resultsPath, archive.Bytes())
+	if err != nil {
+		return err
+	}
+
+	key := datastore.NewKey(ctx, "TestRun", "", run.ID, nil)
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var stored TestRun
+		if err := datastore.Get(ctx, key, &stored); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		stored.ArchiveURL = archiveURL
+		_, err := datastore.Put(ctx, key, &stored)
+		return err
+	}, nil)
+}
+
+// getArchiveRun loads the run identified by the 'run_id' or 'run' param.
+func getArchiveRun(ctx context.Context, r *http.Request) (TestRun, error) {
+	ids, err := ParseRunIDsParam(r)
+	if err != nil {
+		return TestRun{}, err
+	} else if len(ids) > 1 {
+		return TestRun{}, errors.New("only one 'run_id' param is allowed")
+	} else if len(ids) == 1 {
+		testRuns, err := loadTestRunsByID(ctx, ids)
+		if err != nil {
+			return TestRun{}, err
+		}
+		return testRuns[0], nil
+	}
+
+	param := r.URL.Query().Get("run")
+	if param == "" {
+		return TestRun{}, errors.New("missing 'run' param")
+	}
+	spec, err := parsePlatformAtRevisionSpec(param)
+	if err != nil {
+		return TestRun{}, err
+	}
+	return fetchRunForSpec(ctx, spec)
+}
+
+// loadRunSpecParam loads the run identified by the 'run_id' or 'run' param (see getArchiveRun). If it can't be
+// loaded, an error response is written and false is returned.
+func loadRunSpecParam(ctx context.Context, w http.ResponseWriter, r *http.Request) (TestRun, bool) {
+	run, err := getArchiveRun(ctx, r)
+	if _, ok := err.(testRunNotFoundError); ok {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return run, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return run, false
+	} else if run.ID == 0 {
+		http.Error(w, "Test run not found", http.StatusNotFound)
+		return run, false
+	}
+	return run, true
+}
+
+// writeRunArchive writes the tar.gz of the run's results files to w. Entry times are the run's CreatedAt, and tests
+// whose paths would escape the <platform>/ directory (e.g. /../x) are omitted, since the summary is uploaded.
+func writeRunArchive(ctx context.Context, w io.Writer, store ResultsStore, run TestRun) error {
+	summaryBytes, err := readResultsFile(ctx, store, run, "")
+	if err != nil {
+		return err
+	} else if summaryBytes == nil {
+		return fmt.Errorf("results summary of run %d not found", run.ID)
+	}
+	summary, err := ParseResultsSummary(bytes.NewReader(summaryBytes))
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	platform := getResultsPlatform(run)
+	writeFile := func(name string, data []byte) error {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: run.CreatedAt,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err = writeFile(platform+"-summary.json", summaryBytes); err != nil {
+		return err
+	}
+
+	fetch := func(ctx context.Context, i int) ([]byte, error) {
+		test, _ := summary.At(i)
+		if _, ok := getArchiveEntryName(platform, test); !ok {
+			return nil, nil
+		}
+		return readResultsFile(ctx, store, run, test)
+	}
+	emit := func(i int, data []byte) error {
+		if data == nil {
+			return nil
+		}
+		test, _ := summary.At(i)
+		name, _ := getArchiveEntryName(platform, test)
+		return writeFile(name, data)
+	}
+	if err = runOrdered(ctx, summary.Len(), archiveFetchParallelism, fetch, emit); err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// getArchiveEntryName returns the name of the archive entry for the test's results file, i.e. <platform>/<test
+// path>, and whether it's under the <platform>/ directory.
+func getArchiveEntryName(platform string, test string) (string, bool) {
+	name := path.Clean(platform + "/" + strings.TrimPrefix(test, "/"))
+	return name, strings.HasPrefix(name, platform+"/")
+}
+
+// readResultsFile reads the whole of the run's results file for the given test (or summary, for an empty test),
+// returning nil if it doesn't exist.
+func readResultsFile(ctx context.Context, store ResultsStore, run TestRun, test string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, archiveFileTimeout)
+	defer cancel()
+
+	f, err := store.Open(ctx, run, test)
+	if err == errResultsNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if data == nil && err == nil {
+		data = []byte{}
+	}
+	return data, err
+}
+
+// runOrdered calls fetch for each index in [0, n), with at most parallelism calls in flight (or fetched but not
+// yet emitted) at once, and calls emit with the results in index order. The first error is returned.
+func runOrdered(parent context.Context, n int, parallelism int,
+	fetch func(ctx context.Context, i int) ([]byte, error), emit func(i int, data []byte) error) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make([]chan result, n)
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	semaphore := make(chan struct{}, parallelism)
+	go func() {
+		for i := 0; i < n; i++ {
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int) {
+				data, err := fetch(ctx, i)
+				results[i] <- result{data, err}
+			}(i)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		select {
+		case result := <-results[i]:
+			<-semaphore
+			if result.err != nil {
+				return result.err
+			}
+			if err := emit(i, result.data); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
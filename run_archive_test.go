@@ -0,0 +1,184 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// memoryResultsStore is a ResultsStore of results files keyed by test path ("" for the summary).
+type memoryResultsStore map[string]string
+
+func (s memoryResultsStore) Open(ctx context.Context, run TestRun, test string) (io.ReadCloser, error) {
+	data, ok := s[test]
+	if !ok {
+		return nil, errResultsNotFound
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+var archiveRun = TestRun{
+	ID:         1,
+	ResultsURL: resultsURL,
+	Revision:   sha,
+	CreatedAt:  time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+var archiveStore = memoryResultsStore{
+	"":          `{"/b.html":[1,1],"/a/a.html":[0,2],"/missing.html":[0,1]}`,
+	"/b.html":   `{"test":"/b.html"}`,
+	"/a/a.html": `{"test":"/a/a.html"}`,
+}
+
+func readArchive(t *testing.T, archive []byte) (names []string, contents map[string]string) {
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	if !assert.Nil(t, err) {
+		return nil, nil
+	}
+	contents = make(map[string]string)
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if !assert.Nil(t, err) {
+			return nil, nil
+		}
+		data, _ := ioutil.ReadAll(tr)
+		names = append(names, header.Name)
+		contents[header.Name] = string(data)
+		assert.Equal(t, archiveRun.CreatedAt, header.ModTime.UTC())
+	}
+	return names, contents
+}
+
+func TestWriteRunArchive(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, writeRunArchive(context.Background(), &buf, archiveStore, archiveRun))
+
+	names, contents := readArchive(t, buf.Bytes())
+	assert.Equal(t, []string{platform + "-summary.json", platform + "/a/a.html", platform + "/b.html"}, names)
+	assert.Equal(t, archiveStore[""], contents[platform+"-summary.json"])
+	assert.Equal(t, archiveStore["/b.html"], contents[platform+"/b.html"])
+}
+
+func TestWriteRunArchive_EscapingPaths(t *testing.T) {
+	store := memoryResultsStore{
+		"":                `{"/../../x.html":[1,1],"/a/../../y.html":[1,1],"/a/./b.html":[1,1]}`,
+		"/../../x.html":   `{}`,
+		"/a/../../y.html": `{}`,
+		"/a/./b.html":     `{"test":"/a/b.html"}`,
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, writeRunArchive(context.Background(), &buf, store, archiveRun))
+
+	names, contents := readArchive(t, buf.Bytes())
+	assert.Equal(t, []string{platform + "-summary.json", platform + "/a/b.html"}, names)
+	assert.Equal(t, store["/a/./b.html"], contents[platform+"/a/b.html"])
+}
+
+func TestGetArchiveEntryName(t *testing.T) {
+	name, ok := getArchiveEntryName(platform, "/a/b.html")
+	assert.True(t, ok)
+	assert.Equal(t, platform+"/a/b.html", name)
+
+	for _, escaping := range []string{"/../x.html", "/a/../../x.html", "/", "", "/.."} {
+		_, ok = getArchiveEntryName(platform, escaping)
+		assert.False(t, ok, escaping)
+	}
+}
+
+func TestGetArchiveFile(t *testing.T) {
+	assert.Equal(t, "/__archive__/"+platform+"-"+sha+".tar", getArchiveFile(archiveRun))
+	resultsPath, err := getResultsPath(archiveRun, getArchiveFile(archiveRun))
+	assert.Nil(t, err)
+	assert.Equal(t, sha+"/"+platform+"/__archive__/"+platform+"-"+sha+".tar", resultsPath)
+	assert.Equal(t, "application/x-tar", getResultsContentType(resultsPath))
+}
+
+func TestTestRun_RequestArchive(t *testing.T) {
+	now := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	var run TestRun
+	assert.True(t, run.requestArchive(now))
+	assert.Equal(t, now, run.ArchiveRequestedAt)
+
+	// Requested once, until the request times out.
+	assert.False(t, run.requestArchive(now.Add(time.Minute)))
+	assert.True(t, run.requestArchive(now.Add(archiveRequestTimeout)))
+
+	run.ArchiveURL = "https://storage.googleapis.com/wptd/archive.tar"
+	assert.False(t, run.requestArchive(now.Add(2*archiveRequestTimeout)))
+}
+
+func TestWriteRunArchive_MissingSummary(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NotNil(t, writeRunArchive(context.Background(), &buf, memoryResultsStore{}, archiveRun))
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestRunOrdered(t *testing.T) {
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	fetch := func(ctx context.Context, i int) ([]byte, error) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		// Later indices finish first.
+		time.Sleep(time.Duration(20-i) * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		return []byte{byte(i)}, nil
+	}
+	var emitted []byte
+	emit := func(i int, data []byte) error {
+		emitted = append(emitted, data...)
+		return nil
+	}
+	assert.Nil(t, runOrdered(context.Background(), 20, 4, fetch, emit))
+	assert.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}, emitted)
+	assert.True(t, maxInFlight <= 4)
+}
+
+func TestRunOrdered_Error(t *testing.T) {
+	fetch := func(ctx context.Context, i int) ([]byte, error) {
+		if i == 3 {
+			return nil, errors.New("failed")
+		}
+		return []byte{byte(i)}, nil
+	}
+	var emitted []byte
+	emit := func(i int, data []byte) error {
+		emitted = append(emitted, data...)
+		return nil
+	}
+	assert.EqualError(t, runOrdered(context.Background(), 10, 2, fetch, emit), "failed")
+	assert.Equal(t, []byte{0, 1, 2}, emitted)
+}
@@ -0,0 +1,258 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// maxRunChangesDetailSize is the largest (gzipped) detail stored in a TestRunChanges entity, leaving headroom
+// under the Datastore's 1MB entity limit. Larger details are dropped, keeping only the counts.
+const maxRunChangesDetailSize = 900 * 1024
+
+// errRunChangesDetailTooLarge is returned when a run's changes detail exceeds maxRunChangesDetailSize.
+var errRunChangesDetailTooLarge = errors.New("changes detail too large to store")
+
+// RunChangesDetail is the set of tests that changed between two runs, by type of change.
+type RunChangesDetail struct {
+	// Added tests, with their [count-passed, total-tests] in the later run.
+	Added *ResultsSummary `json:"added"`
+
+	// Deleted tests, with their [count-passed, total-tests] in the earlier run.
+	Deleted *ResultsSummary `json:"deleted"`
+
+	// Regressed tests, with counts of [count-newly-failing, total-tests] (see RegressionsBetween).
+	Regressed *ResultsSummary `json:"regressed"`
+
+	// Improved tests, with counts of [count-newly-passing, total-tests].
+	Improved *ResultsSummary `json:"improved"`
+}
+
+// Counts returns the number of tests of each type of change.
+func (detail RunChangesDetail) Counts() RunChanges {
+	return RunChanges{
+		Computed:  true,
+		Added:     detail.Added.Len(),
+		Deleted:   detail.Deleted.Len(),
+		Regressed: detail.Regressed.Len(),
+		Improved:  detail.Improved.Len(),
+	}
+}
+
+// Disruption is the number of tests that changed in any way, for ranking runs by how disruptive they were.
+func (changes RunChanges) Disruption() int {
+	return changes.Added + changes.Deleted + changes.Regressed + changes.Improved
+}
+
+// ComputeRunChangesDetail returns the tests that changed between the summaries of two runs, in a single merge pass.
+func ComputeRunChangesDetail(before, after *ResultsSummary) RunChangesDetail {
+	detail := RunChangesDetail{
+		Added:     &ResultsSummary{},
+		Deleted:   &ResultsSummary{},
+		Regressed: &ResultsSummary{},
+		Improved:  &ResultsSummary{},
+	}
+	i, j := 0, 0
+	for i < before.Len() || j < after.Len() {
+		switch {
+		case j >= after.Len() || (i < before.Len() && before.tests[i] < after.tests[j]):
+			detail.Deleted.add(before.tests[i], before.counts[i])
+			i++
+		case i >= before.Len() || after.tests[j] < before.tests[i]:
+			detail.Added.add(after.tests[j], after.counts[j])
+			j++
+		default:
+			passed := after.counts[j][0] - before.counts[i][0]
+			if passed < 0 {
+				detail.Regressed.add(after.tests[j], TestCounts{-passed, after.counts[j][1]})
+			} else if passed > 0 {
+				detail.Improved.add(after.tests[j], TestCounts{passed, after.counts[j][1]})
+			}
+			i++
+			j++
+		}
+	}
+	return detail
+}
+
+// encodeRunChangesDetail encodes the detail as gzipped JSON.
+func encodeRunChangesDetail(detail RunChangesDetail) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(detail); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxRunChangesDetailSize {
+		return nil, errRunChangesDetailTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeRunChangesDetail decodes detail encoded by encodeRunChangesDetail.
+func decodeRunChangesDetail(encoded []byte) (detail RunChangesDetail, err error) {
+	zr, err := gzip.NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		return detail, err
+	}
+	defer zr.Close()
+	err = json.NewDecoder(zr).Decode(&detail)
+	return detail, err
+}
+
+// runChangesKey returns the key of the TestRunChanges entity for the TestRun with the given ID.
+func runChangesKey(ctx context.Context, runID int64) *datastore.Key {
+	return datastore.NewKey(ctx, "TestRunChanges", "", runID, nil)
+}
+
+// getPreviousRun returns the run of the same browser which preceded the given run, or nil if there isn't one.
+// Only complete runs covering the whole of the run's scope are considered, so a full run is compared with the
+// previous full run, and a partial run with the previous run that covered its paths.
+func getPreviousRun(ctx context.Context, run TestRun) (*TestRun, error) {
+	query := datastore.
+		NewQuery("TestRun").
+		Filter("BrowserName =", run.BrowserName).
+		Filter("CreatedAt <", run.CreatedAt).
+		Order("-CreatedAt")
+	return getFirstTestRun(ctx, query, func(previous TestRun) bool {
+		return previous.IsComplete() && previous.CoversScope(run)
+	})
+}
+
+// computeRunChanges diffs the given run against the previous run of the same browser, storing the counts on the
+// TestRun and the detail in a TestRunChanges entity.
+func computeRunChanges(ctx context.Context, r *http.Request, run TestRun) error {
+	changes := RunChanges{Computed: true}
+	var encoded []byte
+	previous, err := getPreviousRun(ctx, run)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		var before, after *ResultsSummary
+		if before, err = fetchRunResultsSummary(ctx, r, *previous); err != nil {
+			return err
+		}
+		if after, err = fetchRunResultsSummary(ctx, r, run); err != nil {
+			return err
+		}
+		before, after = filterToSharedScope(*previous, run, before, after)
+		detail := ComputeRunChangesDetail(before, after)
+		changes = detail.Counts()
+		changes.PreviousRunID = previous.ID
+		if encoded, err = encodeRunChangesDetail(detail); err == errRunChangesDetailTooLarge {
+			log.Warningf(ctx, "Not storing changes detail of run %d: %s", run.ID, err.Error())
+		} else if err != nil {
+			return err
+		}
+	}
+
+	runKey := datastore.NewKey(ctx, "TestRun", "", run.ID, nil)
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var stored TestRun
+		if err := datastore.Get(ctx, runKey, &stored); err != nil {
+			return err
+		}
+		stored.Changes = changes
+		if _, err := datastore.Put(ctx, runKey, &stored); err != nil {
+			return err
+		}
+		if encoded == nil {
+			return nil
+		}
+		detail := &TestRunChanges{PreviousRunID: changes.PreviousRunID, Detail: encoded}
+		_, err := datastore.Put(ctx, runChangesKey(ctx, run.ID), detail)
+		return err
+	}, &datastore.TransactionOptions{XG: true})
+}
+
+// runChanges is the response of /api/run/changes.
+type runChanges struct {
+	RunID   int64      `json:"run_id"`
+	Changes RunChanges `json:"changes"`
+
+	// Tests that changed, or nil when there was no previous run.
+	Tests *RunChangesDetail `json:"tests"`
+}
+
+// apiTestRunChangesHandler emits JSON for the tests which changed between a run and the previous run of the same
+// browser, i.e. the RunChanges counts along with the RunChangesDetail.
+//
+// URL Params:
+//     run_id: ID of the run
+func apiTestRunChangesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	run, ok := loadRunByIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !run.Changes.Computed {
+		http.Error(w, fmt.Sprintf("Changes of run %d haven't been computed yet", run.ID), http.StatusNotFound)
+		return
+	}
+
+	response := runChanges{RunID: run.ID, Changes: run.Changes}
+	if run.Changes.PreviousRunID != 0 {
+		var stored TestRunChanges
+		if err := datastore.Get(ctx, runChangesKey(ctx, run.ID), &stored); err == datastore.ErrNoSuchEntity {
+			http.Error(w, fmt.Sprintf("Changes of run %d were too large to store", run.ID), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		detail, err := decodeRunChangesDetail(stored.Detail)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Tests = &detail
+	}
+
+	if checkETag(w, r, computeRunsETag([]TestRun{run}), true) {
+		return
+	}
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(bytes)
+}
+
+// sortRunsByDisruption orders the runs by how disruptive they were (see RunChanges.Disruption), most disruptive
+// first. Runs whose changes haven't been computed go last, and ties keep their order.
+func sortRunsByDisruption(testRuns []TestRun) {
+	sort.SliceStable(testRuns, func(i, j int) bool {
+		a, b := testRuns[i].Changes, testRuns[j].Changes
+		if a.Computed != b.Computed {
+			return a.Computed
+		}
+		return a.Disruption() > b.Disruption()
+	})
+}
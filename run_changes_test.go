@@ -0,0 +1,96 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeRunChangesDetail(t *testing.T) {
+	before := NewResultsSummary(map[string][]int{
+		"/a.html": {2, 2},
+		"/b.html": {3, 4},
+		"/c.html": {1, 3},
+		"/d.html": {1, 1},
+	})
+	after := NewResultsSummary(map[string][]int{
+		"/a.html": {0, 2},
+		"/b.html": {3, 4},
+		"/c.html": {3, 3},
+		"/e.html": {4, 5},
+	})
+
+	detail := ComputeRunChangesDetail(before, after)
+	assert.Equal(t, map[string][]int{"/e.html": {4, 5}}, detail.Added.ToMap())
+	assert.Equal(t, map[string][]int{"/d.html": {1, 1}}, detail.Deleted.ToMap())
+	assert.Equal(t, map[string][]int{"/a.html": {2, 2}}, detail.Regressed.ToMap())
+	assert.Equal(t, map[string][]int{"/c.html": {2, 3}}, detail.Improved.ToMap())
+
+	counts := detail.Counts()
+	assert.Equal(t, RunChanges{Computed: true, Added: 1, Deleted: 1, Regressed: 1, Improved: 1}, counts)
+	assert.Equal(t, 4, counts.Disruption())
+}
+
+func TestComputeRunChangesDetail_Empty(t *testing.T) {
+	detail := ComputeRunChangesDetail(&ResultsSummary{}, &ResultsSummary{})
+	assert.Equal(t, RunChanges{Computed: true}, detail.Counts())
+}
+
+func TestEncodeRunChangesDetail(t *testing.T) {
+	detail := ComputeRunChangesDetail(
+		NewResultsSummary(map[string][]int{"/a.html": {1, 1}}),
+		NewResultsSummary(map[string][]int{"/a.html": {0, 1}, "/b.html": {1, 1}}))
+	encoded, err := encodeRunChangesDetail(detail)
+	assert.Nil(t, err)
+
+	decoded, err := decodeRunChangesDetail(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, detail.Counts(), decoded.Counts())
+	assert.Equal(t, detail.Regressed.ToMap(), decoded.Regressed.ToMap())
+	assert.Equal(t, detail.Added.ToMap(), decoded.Added.ToMap())
+}
+
+func TestRunChangesJSON(t *testing.T) {
+	detail := ComputeRunChangesDetail(
+		NewResultsSummary(map[string][]int{"/a.html": {1, 1}}),
+		NewResultsSummary(map[string][]int{"/a.html": {0, 1}}))
+	response := runChanges{RunID: 2, Changes: detail.Counts(), Tests: &detail}
+	response.Changes.PreviousRunID = 1
+	bytes, err := json.Marshal(response)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"run_id": 2,
+		"changes": {"computed": true, "previous_run_id": 1, "added": 0, "deleted": 0, "regressed": 1, "improved": 0},
+		"tests": {"added": {}, "deleted": {}, "regressed": {"/a.html": [1, 1]}, "improved": {}}
+	}`, string(bytes))
+}
+
+func TestSortRunsByDisruption(t *testing.T) {
+	testRuns := []TestRun{
+		{ID: 1},
+		{ID: 2, Changes: RunChanges{Computed: true, Added: 1}},
+		{ID: 3, Changes: RunChanges{Computed: true, Regressed: 5, Improved: 1}},
+		{ID: 4, Changes: RunChanges{Computed: true, Deleted: 1}},
+	}
+	sortRunsByDisruption(testRuns)
+	var ids []int64
+	for _, run := range testRuns {
+		ids = append(ids, run.ID)
+	}
+	assert.Equal(t, []int64{3, 2, 4, 1}, ids)
+}
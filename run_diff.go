@@ -15,18 +15,26 @@
 package wptdashboard
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/urlfetch"
 )
 
+// summaryFetchTimeout is the deadline for fetching a single results summary.
+const summaryFetchTimeout = 30 * time.Second
+
+// datastoreQueryTimeout is the deadline for a batch of (concurrent) Datastore queries.
+const datastoreQueryTimeout = 15 * time.Second
+
+// maxConcurrentQueries is the maximum number of concurrent Datastore queries or summary fetches made on behalf of
+// a single request.
+const maxConcurrentQueries = 4
+
 type platformAtRevision struct {
 	// Platform is the string representing browser (+ version), and OS (+ version).
 	Platform string
@@ -35,17 +43,24 @@ type platformAtRevision struct {
 	Revision string
 }
 
-func parsePlatformAtRevisionSpec(spec string) (platformAtRevision platformAtRevision, err error) {
+// ParsePlatformAtRevision splits a platform@revision spec, e.g. "chrome@abcdef0123", into the platform and the
+// revision (SHA[0:10]). A spec without an @ is the platform's "latest" revision.
+func ParsePlatformAtRevision(spec string) (platform string, revision string, err error) {
 	pieces := strings.Split(spec, "@")
 	if len(pieces) > 2 {
-		return platformAtRevision, errors.New("invalid platform@revision spec: " + spec)
+		return "", "", errors.New("invalid platform@revision spec: " + spec)
 	}
-	platformAtRevision.Platform = pieces[0]
 	if len(pieces) < 2 {
 		// No @ is assumed to be the platform only.
-		platformAtRevision.Revision = "latest"
-	} else {
-		platformAtRevision.Revision = pieces[1]
+		return pieces[0], "latest", nil
+	}
+	return pieces[0], pieces[1], nil
+}
+
+func parsePlatformAtRevisionSpec(spec string) (platformAtRevision platformAtRevision, err error) {
+	platformAtRevision.Platform, platformAtRevision.Revision, err = ParsePlatformAtRevision(spec)
+	if err != nil {
+		return platformAtRevision, err
 	}
 	// TODO(lukebjerring): Also handle actual platforms (with version + os)
 	if IsBrowserName(platformAtRevision.Platform) {
@@ -54,114 +69,69 @@ func parsePlatformAtRevisionSpec(spec string) (platformAtRevision platformAtRevi
 	return platformAtRevision, errors.New("Platform " + platformAtRevision.Platform + " not found")
 }
 
-func fetchRunResultsJSONForParam(
-	ctx context.Context, r *http.Request, revision string) (results map[string][]int, err error) {
-	var spec platformAtRevision
-	if spec, err = parsePlatformAtRevisionSpec(revision); err != nil {
-		return nil, err
-	}
-	return fetchRunResultsJSONForSpec(ctx, r, spec)
-}
-
-func fetchRunResultsJSONForSpec(
-	ctx context.Context, r *http.Request, revision platformAtRevision) (results map[string][]int, err error) {
-	var run TestRun
-	if run, err = fetchRunForSpec(ctx, revision); err != nil {
-		return nil, err
-	} else if (run == TestRun{}) {
-		return nil, nil
-	}
-	return fetchRunResultsJSON(ctx, r, run)
-}
-
+// fetchRunForSpec returns the most recent full (i.e. not partial) run for the spec, or an empty TestRun if there
+// isn't one. For a specific revision, it falls back to the most recent partial run at that revision, so that partial
+// runs can be requested (and diffed) explicitly, while 'latest' only ever resolves to a full run.
 func fetchRunForSpec(ctx context.Context, revision platformAtRevision) (TestRun, error) {
 	baseQuery := datastore.
 		NewQuery("TestRun").
-		Order("-CreatedAt").
-		Limit(1)
+		Order("-CreatedAt")
 
-	var results []TestRun
 	// TODO(lukebjerring): Handle actual platforms (split out version + os)
 	query := baseQuery.
 		Filter("BrowserName =", revision.Platform)
 	if revision.Revision != "latest" {
 		query = query.Filter("Revision = ", revision.Revision)
 	}
-	if _, err := query.GetAll(ctx, &results); err != nil {
-		return TestRun{}, err
+	for _, include := range getRunSpecFilters(revision) {
+		run, err := getFirstTestRun(ctx, query, include)
+		if err != nil {
+			return TestRun{}, err
+		} else if run != nil {
+			return *run, nil
+		}
 	}
-	if len(results) < 1 {
-		return TestRun{}, nil
+	return TestRun{}, nil
+}
+
+// getRunSpecFilters returns the filters (see getFirstTestRun) of the runs which the spec can resolve to, in order of
+// preference: full runs, then partial runs, for a specific revision.
+func getRunSpecFilters(revision platformAtRevision) []func(TestRun) bool {
+	if revision.Revision == "latest" {
+		return []func(TestRun) bool{isDefaultRun}
 	}
-	return results[0], nil
+	return []func(TestRun) bool{isDefaultRun, TestRun.IsComplete}
 }
 
-// fetchRunResultsJSON fetches the results JSON summary for the given test run, but does not include subtests (since
-// a full run can span 20k files).
-func fetchRunResultsJSON(ctx context.Context, r *http.Request, run TestRun) (results map[string][]int, err error) {
-	client := urlfetch.Client(ctx)
+// fetchRunResultsSummary fetches the results JSON summary for the given test run, but does not include subtests
+// (since a full run can span 20k files). Summaries are read from the results store (see getResultsStore), and
+// cached by URL (see summaries).
+func fetchRunResultsSummary(ctx context.Context, r *http.Request, run TestRun) (results *ResultsSummary, err error) {
 	url := strings.TrimSpace(run.ResultsURL)
-	if strings.Index(url, "/") == 0 {
-		reqURL := *r.URL
-		reqURL.Path = url
-	}
-	var resp *http.Response
-	if resp, err = client.Get(url); err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	return summaries.get(ctx, url, func(ctx context.Context) (*ResultsSummary, error) {
+		return LoadResultsSummary(ctx, getResultsStore(ctx, r), run)
+	})
+}
 
-	var body []byte
-	if body, err = ioutil.ReadAll(resp.Body); err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("%s returned HTTP status %d:\n%s", url, resp.StatusCode, string(body))
+// LoadResultsSummary reads the run's results JSON summary from the store, parsing it as it is streamed.
+func LoadResultsSummary(ctx context.Context, store ResultsStore, run TestRun) (results *ResultsSummary, err error) {
+	ctx, cancel := context.WithTimeout(ctx, summaryFetchTimeout)
+	defer cancel()
+
+	summary, err := store.Open(ctx, run, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %s", run.ResultsURL, err.Error())
 	}
-	if err = json.Unmarshal(body, &results); err != nil {
-		return nil, err
+	defer summary.Close()
+	if results, err = ParseResultsSummary(summary); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", run.ResultsURL, err.Error())
 	}
 	return results, nil
 }
 
 // getResultsDiff returns a map of test name to an array of [count-different-tests, total-tests], for tests which had
 // different results counts in their map (which is test name to array of [count-passed, total-tests]).
-//
+// See DiffResultsSummaries, which it wraps.
 func getResultsDiff(before map[string][]int, after map[string][]int, filter DiffFilterParam) map[string][]int {
-	diff := make(map[string][]int)
-	if filter.Deleted || filter.Changed {
-		for test, resultsBefore := range before {
-			if resultsAfter, ok := after[test]; !ok {
-				// Missing? Then N / N tests are 'different'.
-				if !filter.Deleted {
-					continue
-				}
-				diff[test] = []int{resultsBefore[1], resultsBefore[1]}
-			} else {
-				if !filter.Changed {
-					continue
-				}
-				passDiff := abs(resultsBefore[0] - resultsAfter[0])
-				countDiff := abs(resultsBefore[1] - resultsAfter[1])
-				if countDiff == 0 && passDiff == 0 {
-					continue
-				}
-				// Changed tests is at most the number of different outcomes,
-				// but newly introduced tests should still be counted (e.g. 0/2 => 0/5)
-				diff[test] = []int{
-					max(passDiff, countDiff),
-					max(resultsBefore[1], resultsAfter[1]),
-				}
-			}
-		}
-	}
-	if filter.Added {
-		for test, resultsAfter := range after {
-			if _, ok := before[test]; !ok {
-				// Missing? Then N / N tests are 'different'
-				diff[test] = []int{resultsAfter[1], resultsAfter[1]}
-			}
-		}
-	}
-	return diff
+	return DiffResultsSummaries(NewResultsSummary(before), NewResultsSummary(after), filter).ToMap()
 }
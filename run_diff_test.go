@@ -115,3 +115,24 @@ func getDeltaResultsMaps(before []int, after []int) (map[string][]int, map[strin
 	return map[string][]int{mockTestPath: before},
 		map[string][]int{mockTestPath: after}
 }
+
+func TestGetRunSpecFilters(t *testing.T) {
+	full := TestRun{}
+	partial := TestRun{Paths: []string{"/css/"}}
+	incomplete := TestRun{Status: RunStatusRunning}
+
+	latest := getRunSpecFilters(platformAtRevision{"chrome", "latest"})
+	if assert.Len(t, latest, 1) {
+		assert.True(t, latest[0](full))
+		assert.False(t, latest[0](partial))
+	}
+
+	// A specific revision prefers its full run, but falls back to a partial run.
+	pinned := getRunSpecFilters(platformAtRevision{"chrome", sha})
+	if assert.Len(t, pinned, 2) {
+		assert.True(t, pinned[0](full))
+		assert.False(t, pinned[0](partial))
+		assert.True(t, pinned[1](partial))
+		assert.False(t, pinned[1](incomplete))
+	}
+}
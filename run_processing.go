@@ -0,0 +1,81 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+)
+
+// processRunTask is the name of the task which post-processes a newly uploaded TestRun.
+const processRunTask = "process-run"
+
+// processRunPayload is the payload of a processRunTask.
+type processRunPayload struct {
+	RunID int64 `json:"run_id"`
+
+	// RevisionCompleted is whether the run made its revision complete (see updateRevision).
+	RevisionCompleted bool `json:"revision_completed"`
+}
+
+func init() {
+	registerTask(processRunTask, processRun)
+}
+
+// processNewTestRun records a newly stored run on its revision (see updateRevision), and enqueues its processing
+// (see processRun). Failures are logged rather than returned, since the run has already been stored.
+func processNewTestRun(ctx context.Context, testRun TestRun) {
+	revisionCompleted, err := updateRevision(ctx, testRun)
+	if err != nil {
+		log.Errorf(ctx, "Failed to update revision %s: %s", testRun.Revision, err.Error())
+	}
+	task := processRunPayload{RunID: testRun.ID, RevisionCompleted: revisionCompleted}
+	if err = getTaskQueue().Enqueue(ctx, processRunTask, task); err != nil {
+		log.Errorf(ctx, "Failed to enqueue processing of run %d: %s", testRun.ID, err.Error())
+	}
+}
+
+// processRun does the work for an uploaded run that's too slow to do during the upload request: it checks that
+// the run's results summary can be fetched and parsed, diffs it against the previous run (see computeRunChanges),
+// then sends the webhook notifications. When retried, it skips the changes if they've been computed, and the
+// notifications which have been delivered (see notifyWebhooks).
+func processRun(ctx context.Context, r *http.Request, payload []byte) error {
+	var task processRunPayload
+	if err := json.Unmarshal(payload, &task); err != nil {
+		return err
+	}
+	testRuns, err := loadTestRunsByID(ctx, []int64{task.RunID})
+	if _, ok := err.(testRunNotFoundError); ok {
+		// The run has since been deleted; there's nothing left to do.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	run := testRuns[0]
+
+	if _, err = fetchRunResultsSummary(ctx, r, run); err != nil {
+		return fmt.Errorf("failed to load results summary of run %d: %s", run.ID, err.Error())
+	}
+	if !run.Changes.Computed {
+		if err = computeRunChanges(ctx, r, run); err != nil {
+			return fmt.Errorf("failed to compute changes of run %d: %s", run.ID, err.Error())
+		}
+	}
+	return notifyWebhooks(ctx, r, run, task.RevisionCompleted)
+}
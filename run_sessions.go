@@ -0,0 +1,326 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// runSessionTTL is how long a RunSession can be uploaded to before it expires.
+const runSessionTTL = 24 * time.Hour
+
+// maxShardSummarySize is the maximum size of a shard's (gzipped) summary, to fit in a Datastore entity.
+const maxShardSummarySize = 900 << 10
+
+// maxOverlapsReported is the number of overlapping tests named in the error for a session with overlapping shards.
+const maxOverlapsReported = 10
+
+var (
+	errRunSessionNotFound  = errors.New("run session not found")
+	errRunSessionExpired   = errors.New("run session has expired")
+	errRunSessionFinalized = errors.New("run session has already been finalized")
+	errShardTooLarge       = fmt.Errorf("shard summary is larger than %d bytes when gzipped", maxShardSummarySize)
+)
+
+// platformPieceRegex matches the pieces of a run's platform (browser name, version, etc).
+var platformPieceRegex = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+// shardOverlapError is returned when tests appear in more than one shard of a session.
+type shardOverlapError []string
+
+func (tests shardOverlapError) Error() string {
+	examples := []string(tests)
+	if len(examples) > maxOverlapsReported {
+		examples = examples[:maxOverlapsReported]
+	}
+	return fmt.Sprintf("%d tests are in more than one shard, e.g. %s", len(tests), strings.Join(examples, ", "))
+}
+
+// runSessionStateError is returned when a session isn't in the state needed for a request, e.g. when it's
+// finalized with shards missing.
+type runSessionStateError string
+
+func (err runSessionStateError) Error() string {
+	return string(err)
+}
+
+// runSessionKey returns the key of the RunSession with the given ID.
+func runSessionKey(ctx context.Context, id int64) *datastore.Key {
+	return datastore.NewKey(ctx, "RunSession", "", id, nil)
+}
+
+// runSessionShardKey returns the key of the given (1-based) shard of a RunSession.
+func runSessionShardKey(ctx context.Context, sessionKey *datastore.Key, shard int) *datastore.Key {
+	return datastore.NewKey(ctx, "RunSessionShard", "", int64(shard), sessionKey)
+}
+
+// validateSessionRun checks that the run of a new session names its revision and platform, which make up the path
+// of its summary (see getSessionSummaryPath).
+func validateSessionRun(run TestRun) error {
+	if len(run.Revision) != 10 || !SHARegex.MatchString(run.Revision) {
+		return fmt.Errorf("invalid revision %s (must be SHA[0:10])", run.Revision)
+	}
+	if run.BrowserName == "" {
+		return errors.New("browser_name missing")
+	}
+	for _, piece := range []string{run.BrowserName, run.BrowserVersion, run.OSName, run.OSVersion} {
+		if piece != "" && !platformPieceRegex.MatchString(piece) {
+			return fmt.Errorf("invalid platform piece %s", piece)
+		}
+	}
+	return nil
+}
+
+// getSessionSummaryPath returns the path of the merged summary of a session's run, relative to the root of the
+// results bucket, in the same layout as run/run.py uploads.
+func getSessionSummaryPath(run TestRun) string {
+	return fmt.Sprintf("%s/%s-summary.json.gz", run.Revision, getRunPlatform(run))
+}
+
+// checkRunSessionOpen returns an error if the session can no longer be uploaded to.
+func checkRunSessionOpen(session RunSession, now time.Time) error {
+	if session.Finalized {
+		return errRunSessionFinalized
+	} else if now.After(session.ExpiresAt) {
+		return errRunSessionExpired
+	}
+	return nil
+}
+
+// loadRunSession loads the session with the given key, returning errRunSessionNotFound if it doesn't exist.
+func loadRunSession(ctx context.Context, key *datastore.Key) (session RunSession, err error) {
+	if err = datastore.Get(ctx, key, &session); err == datastore.ErrNoSuchEntity {
+		return session, errRunSessionNotFound
+	} else if err != nil {
+		return session, err
+	}
+	session.ID = key.IntID()
+	return session, nil
+}
+
+// addShard records the upload of the given shard on the session.
+func (session *RunSession) addShard(shard int) {
+	i := sort.SearchInts(session.Uploaded, shard)
+	if i < len(session.Uploaded) && session.Uploaded[i] == shard {
+		return
+	}
+	session.Uploaded = append(session.Uploaded, 0)
+	copy(session.Uploaded[i+1:], session.Uploaded[i:])
+	session.Uploaded[i] = shard
+}
+
+// checkShardsUploaded returns an error unless the session has all of its shards (or at least one, when the number
+// of shards isn't known).
+func (session RunSession) checkShardsUploaded() error {
+	if len(session.Uploaded) == 0 {
+		return runSessionStateError("no shards have been uploaded")
+	}
+	if session.Shards > 0 && len(session.Uploaded) < session.Shards {
+		uploaded := make(map[int]bool)
+		for _, shard := range session.Uploaded {
+			uploaded[shard] = true
+		}
+		var missing []string
+		for shard := 1; shard <= session.Shards; shard++ {
+			if !uploaded[shard] {
+				missing = append(missing, fmt.Sprint(shard))
+			}
+		}
+		return runSessionStateError(fmt.Sprintf("%d of %d shards are missing: %s",
+			len(missing), session.Shards, strings.Join(missing, ", ")))
+	}
+	return nil
+}
+
+// hasUploaded determines whether the shards uploaded to the session are exactly the given ones, in order.
+func (session RunSession) hasUploaded(shards []int) bool {
+	if len(session.Uploaded) != len(shards) {
+		return false
+	}
+	for i, shard := range shards {
+		if session.Uploaded[i] != shard {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeSummary encodes the summary as gzipped JSON.
+func encodeSummary(summary *ResultsSummary) ([]byte, error) {
+	encoded, err := summary.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(encoded); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeSummary decodes a summary encoded by encodeSummary.
+func decodeSummary(encoded []byte) (*ResultsSummary, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ParseResultsSummary(zr)
+}
+
+// mergeShardSummaries merges the summaries of a session's shards into the run's summary. Each test must only be in
+// one shard; otherwise a shardOverlapError lists the tests in more than one.
+func mergeShardSummaries(shards []*ResultsSummary) (*ResultsSummary, error) {
+	merged := &ResultsSummary{}
+	for _, shard := range shards {
+		for i, test := range shard.tests {
+			merged.add(test, shard.counts[i])
+		}
+	}
+	sort.Stable(summaryByPath{merged})
+
+	var overlapping shardOverlapError
+	for i := 1; i < len(merged.tests); i++ {
+		test := merged.tests[i]
+		if test == merged.tests[i-1] && (len(overlapping) == 0 || overlapping[len(overlapping)-1] != test) {
+			overlapping = append(overlapping, test)
+		}
+	}
+	if len(overlapping) > 0 {
+		return nil, overlapping
+	}
+	return merged, nil
+}
+
+// finalizeRunSession merges the uploaded shards of the session into the run's summary, writes it to the results
+// bucket, then creates the TestRun. Finalizing an already finalized session returns its existing run, with created
+// false.
+func finalizeRunSession(ctx context.Context, key *datastore.Key, writer ResultsWriter) (
+	run TestRun, created bool, err error) {
+	session, err := loadRunSession(ctx, key)
+	if err != nil {
+		return run, false, err
+	}
+	if session.Finalized {
+		return loadSessionRun(ctx, session)
+	} else if err = checkRunSessionOpen(session, time.Now()); err != nil {
+		return run, false, err
+	} else if err = session.checkShardsUploaded(); err != nil {
+		return run, false, err
+	}
+
+	// The shards are too large to read in the transaction, so it checks that none were uploaded in the meantime.
+	var shards []RunSessionShard
+	shardKeys, err := datastore.NewQuery("RunSessionShard").Ancestor(key).GetAll(ctx, &shards)
+	if err != nil {
+		return run, false, err
+	}
+	mergedShards := make([]int, len(shards))
+	summaries := make([]*ResultsSummary, len(shards))
+	for i, shard := range shards {
+		mergedShards[i] = int(shardKeys[i].IntID())
+		if summaries[i], err = decodeSummary(shard.Summary); err != nil {
+			return run, false, err
+		}
+	}
+	sort.Ints(mergedShards)
+	merged, err := mergeShardSummaries(summaries)
+	if err != nil {
+		return run, false, err
+	}
+	encoded, err := encodeSummary(merged)
+	if err != nil {
+		return run, false, err
+	}
+	resultsURL, err := writer.Write(ctx, getSessionSummaryPath(session.Run), encoded)
+	if err != nil {
+		return run, false, err
+	}
+
+	err = datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		if session, err = loadRunSession(ctx, key); err != nil {
+			return err
+		}
+		if session.Finalized {
+			// Finalized concurrently; the other request created the run.
+			created = false
+			return nil
+		} else if !session.hasUploaded(mergedShards) {
+			return runSessionStateError("shards were uploaded while the session was being finalized; finalize it again")
+		}
+		run = session.Run
+		run.ResultsURL = resultsURL
+		run.Status = RunStatusComplete
+		run.CreatedAt = time.Now()
+		runKey, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, "TestRun", nil), &run)
+		if err != nil {
+			return err
+		}
+		run.ID = runKey.IntID()
+		session.Finalized = true
+		session.RunID = run.ID
+		_, err = datastore.Put(ctx, key, &session)
+		created = true
+		return err
+	}, &datastore.TransactionOptions{XG: true})
+	if err != nil {
+		return run, false, err
+	} else if !created {
+		return loadSessionRun(ctx, session)
+	}
+	return run, true, nil
+}
+
+// loadSessionRun loads the TestRun created by finalizing the session.
+func loadSessionRun(ctx context.Context, session RunSession) (TestRun, bool, error) {
+	testRuns, err := loadTestRunsByID(ctx, []int64{session.RunID})
+	if err != nil {
+		return TestRun{}, false, err
+	}
+	return testRuns[0], false, nil
+}
+
+// deleteRunSessionShards deletes the shards of the session with the given key, e.g. once it's finalized.
+func deleteRunSessionShards(ctx context.Context, key *datastore.Key) error {
+	shardKeys, err := datastore.NewQuery("RunSessionShard").Ancestor(key).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, shardKeys)
+}
+
+// deleteRunSessions deletes the sessions with the given keys, along with their shards.
+func deleteRunSessions(ctx context.Context, keys []*datastore.Key) error {
+	for _, key := range keys {
+		if err := deleteRunSessionShards(ctx, key); err != nil {
+			return err
+		}
+	}
+	return datastore.DeleteMulti(ctx, keys)
+}
@@ -0,0 +1,291 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// runSessionsExpireBatchSize is the maximum number of sessions deleted by each run of the expiry cron job.
+const runSessionsExpireBatchSize = 100
+
+// apiRunSessionHandler is responsible for opening sessions for runs uploaded in shards (see RunSession), and
+// emitting their status. All methods require the upload token, supplied in the 'secret' param.
+//
+// GET emits the session with the given 'session_id' param.
+// POST opens a session, from a JSON body in the format of the TestRun model (without a results_url).
+//
+// URL Params:
+//     session_id: (GET) ID of the session
+//     shards: (POST, optional) Number of shards which will be uploaded, checked when the session is finalized
+func apiRunSessionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkUploadToken(ctx, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		key, ok := parseRunSessionKey(ctx, w, r)
+		if !ok {
+			return
+		}
+		session, err := loadRunSession(ctx, key)
+		if err != nil {
+			http.Error(w, err.Error(), runSessionErrorStatus(err))
+			return
+		}
+		writeRunSession(w, session, http.StatusOK)
+	case "POST":
+		handleAPIRunSessionPost(ctx, w, r)
+	default:
+		http.Error(w, "This endpoint only supports GET and POST.", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleAPIRunSessionPost(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var session RunSession
+	if err = json.Unmarshal(body, &session.Run); err != nil {
+		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateSessionRun(session.Run); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if session.Run.Runner != "" {
+		if err = validateRunnerName(session.Run.Runner); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if session.Run.Paths, err = normalizeRunPaths(session.Run.Paths); err != nil {
+		http.Error(w, "Invalid 'paths': "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	session.Run.ID = 0
+	session.Run.ResultsURL = ""
+	session.Run.Changes = RunChanges{}
+	session.Run.Artifacts = nil
+	session.Run.ArchiveURL = ""
+	session.Run.Status = ""
+	session.Run.Progress = RunProgress{}
+	if param := r.URL.Query().Get("shards"); param != "" {
+		if session.Shards, err = strconv.Atoi(param); err != nil || session.Shards < 0 {
+			http.Error(w, "Invalid 'shards' param", http.StatusBadRequest)
+			return
+		}
+	}
+	session.Uploaded = []int{}
+	session.CreatedAt = time.Now()
+	session.ExpiresAt = session.CreatedAt.Add(runSessionTTL)
+
+	key, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, "RunSession", nil), &session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session.ID = key.IntID()
+	writeRunSession(w, session, http.StatusCreated)
+}
+
+// apiRunSessionShardHandler is responsible for (PUT or POST) uploads of the results summary of a shard of a
+// session's run. The body is either a results summary or a wptreport (see ParseResults). Re-uploading a shard
+// replaces it. Requires the upload token, supplied in the 'secret' param.
+//
+// URL Params:
+//     session_id: ID of the session
+//     shard: Number of the shard, from 1
+//     format: (optional) Format of the body, summary or report; detected from the body by default
+func apiRunSessionShardHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkUploadToken(ctx, w, r) {
+		return
+	}
+	if r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "This endpoint only supports PUT and POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := parseRunSessionKey(ctx, w, r)
+	if !ok {
+		return
+	}
+	shard, err := strconv.Atoi(r.URL.Query().Get("shard"))
+	if err != nil || shard < 1 {
+		http.Error(w, "Invalid 'shard' param", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := ParseResults(r.Body, r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	entity := RunSessionShard{Tests: summary.Len(), UploadedAt: time.Now()}
+	if entity.Summary, err = encodeSummary(summary); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(entity.Summary) > maxShardSummarySize {
+		http.Error(w, errShardTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var session RunSession
+	err = datastore.RunInTransaction(ctx, func(ctx context.Context) (err error) {
+		if session, err = loadRunSession(ctx, key); err != nil {
+			return err
+		}
+		if err = checkRunSessionOpen(session, time.Now()); err != nil {
+			return err
+		}
+		if session.Shards > 0 && shard > session.Shards {
+			return runSessionStateError(fmt.Sprintf("shard %d is out of range (the session has %d shards)",
+				shard, session.Shards))
+		}
+		if _, err = datastore.Put(ctx, runSessionShardKey(ctx, key, shard), &entity); err != nil {
+			return err
+		}
+		session.addShard(shard)
+		_, err = datastore.Put(ctx, key, &session)
+		return err
+	}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), runSessionErrorStatus(err))
+		return
+	}
+	writeRunSession(w, session, http.StatusOK)
+}
+
+// apiRunSessionFinalizeHandler is responsible for (POST) requests to finalize a session, which merges its shards
+// into the run's summary and creates the TestRun (see finalizeRunSession), emitting it. Finalizing is idempotent.
+// Requires the upload token, supplied in the 'secret' param.
+//
+// URL Params:
+//     session_id: ID of the session
+func apiRunSessionFinalizeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkUploadToken(ctx, w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := parseRunSessionKey(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	run, created, err := finalizeRunSession(ctx, key, getResultsWriter())
+	if err != nil {
+		http.Error(w, err.Error(), runSessionErrorStatus(err))
+		return
+	}
+	status := http.StatusOK
+	if created {
+		processNewTestRun(ctx, run)
+		if err = deleteRunSessionShards(ctx, key); err != nil {
+			log.Warningf(ctx, "Failed to delete shards of finalized session %d: %s", key.IntID(), err.Error())
+		}
+		status = http.StatusCreated
+	}
+
+	runBytes, err := json.Marshal(run)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(runBytes)
+}
+
+// cronExpireRunSessionsHandler deletes expired sessions (and their shards), finalized or not. It's run by the
+// App Engine cron service (see cron.yaml).
+func cronExpireRunSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkCronRequest(w, r) {
+		return
+	}
+
+	ctx := appengine.NewContext(r)
+	keys, err := datastore.NewQuery("RunSession").
+		Filter("ExpiresAt <", time.Now()).
+		KeysOnly().
+		Limit(runSessionsExpireBatchSize).
+		GetAll(ctx, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = deleteRunSessions(ctx, keys); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof(ctx, "Deleted %d expired run sessions", len(keys))
+}
+
+// parseRunSessionKey parses the 'session_id' param. If it's invalid, an error response is written and false is
+// returned.
+func parseRunSessionKey(ctx context.Context, w http.ResponseWriter, r *http.Request) (*datastore.Key, bool) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("session_id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid 'session_id' param", http.StatusBadRequest)
+		return nil, false
+	}
+	return runSessionKey(ctx, id), true
+}
+
+// runSessionErrorStatus returns the HTTP status for an error from the session APIs.
+func runSessionErrorStatus(err error) int {
+	switch err.(type) {
+	case shardOverlapError, runSessionStateError:
+		return http.StatusConflict
+	}
+	switch err {
+	case errRunSessionNotFound:
+		return http.StatusNotFound
+	case errRunSessionExpired:
+		return http.StatusGone
+	case errRunSessionFinalized:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func writeRunSession(w http.ResponseWriter, session RunSession, status int) {
+	sessionBytes, err := json.Marshal(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(sessionBytes)
+}
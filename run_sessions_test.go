@@ -0,0 +1,115 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSessionRun(t *testing.T) {
+	run := TestRun{BrowserName: "chrome", BrowserVersion: "63.0", OSName: "linux", Revision: "abcdef0123"}
+	assert.Nil(t, validateSessionRun(run))
+	assert.Equal(t, "abcdef0123/chrome-63.0-linux-summary.json.gz", getSessionSummaryPath(run))
+
+	for _, invalid := range []TestRun{
+		{BrowserName: "chrome", Revision: "latest"},
+		{BrowserName: "chrome", Revision: "abcdef01234"},
+		{Revision: "abcdef0123"},
+		{BrowserName: "chrome", BrowserVersion: "..", Revision: "abcdef0123"},
+		{BrowserName: "chrome", OSName: "linux/../x", Revision: "abcdef0123"},
+	} {
+		assert.NotNil(t, validateSessionRun(invalid), "%+v", invalid)
+	}
+}
+
+func TestCheckRunSessionOpen(t *testing.T) {
+	now := time.Now()
+	assert.Nil(t, checkRunSessionOpen(RunSession{ExpiresAt: now.Add(time.Hour)}, now))
+	assert.Equal(t, errRunSessionExpired, checkRunSessionOpen(RunSession{ExpiresAt: now.Add(-time.Hour)}, now))
+	assert.Equal(t, errRunSessionFinalized,
+		checkRunSessionOpen(RunSession{ExpiresAt: now.Add(time.Hour), Finalized: true}, now))
+}
+
+func TestRunSession_Shards(t *testing.T) {
+	session := RunSession{Shards: 3}
+	assert.IsType(t, runSessionStateError(""), session.checkShardsUploaded())
+
+	session.addShard(3)
+	session.addShard(1)
+	session.addShard(3)
+	assert.Equal(t, []int{1, 3}, session.Uploaded)
+	err := session.checkShardsUploaded()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "1 of 3 shards are missing: 2", err.Error())
+		assert.Equal(t, http.StatusConflict, runSessionErrorStatus(err))
+	}
+
+	session.addShard(2)
+	assert.Nil(t, session.checkShardsUploaded())
+	assert.True(t, session.hasUploaded([]int{1, 2, 3}))
+	assert.False(t, session.hasUploaded([]int{1, 3}))
+
+	// Without an expected number of shards, any are enough.
+	assert.Nil(t, RunSession{Uploaded: []int{2}}.checkShardsUploaded())
+}
+
+func TestEncodeSummary(t *testing.T) {
+	summary := NewResultsSummary(map[string][]int{"/a.html": {1, 2}, "/b.html": {0, 1}})
+	encoded, err := encodeSummary(summary)
+	assert.Nil(t, err)
+	decoded, err := decodeSummary(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, summary.ToMap(), decoded.ToMap())
+}
+
+func TestMergeShardSummaries(t *testing.T) {
+	merged, err := mergeShardSummaries([]*ResultsSummary{
+		NewResultsSummary(map[string][]int{"/dom/b.html": {1, 2}, "/dom/a.html": {0, 1}}),
+		NewResultsSummary(map[string][]int{"/css/c.html": {3, 3}}),
+		NewResultsSummary(map[string][]int{}),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{
+		"/css/c.html": {3, 3},
+		"/dom/a.html": {0, 1},
+		"/dom/b.html": {1, 2},
+	}, merged.ToMap())
+	test, _ := merged.At(0)
+	assert.Equal(t, "/css/c.html", test)
+}
+
+func TestMergeShardSummaries_Overlap(t *testing.T) {
+	_, err := mergeShardSummaries([]*ResultsSummary{
+		NewResultsSummary(map[string][]int{"/a.html": {1, 1}, "/b.html": {1, 1}}),
+		NewResultsSummary(map[string][]int{"/a.html": {0, 1}, "/c.html": {1, 1}}),
+		NewResultsSummary(map[string][]int{"/a.html": {1, 1}, "/c.html": {1, 1}}),
+	})
+	if assert.IsType(t, shardOverlapError{}, err) {
+		assert.Equal(t, shardOverlapError{"/a.html", "/c.html"}, err)
+		assert.Equal(t, "2 tests are in more than one shard, e.g. /a.html, /c.html", err.Error())
+		assert.Equal(t, http.StatusConflict, runSessionErrorStatus(err))
+	}
+}
+
+func TestRunSessionErrorStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, runSessionErrorStatus(errRunSessionNotFound))
+	assert.Equal(t, http.StatusGone, runSessionErrorStatus(errRunSessionExpired))
+	assert.Equal(t, http.StatusConflict, runSessionErrorStatus(errRunSessionFinalized))
+	assert.Equal(t, http.StatusInternalServerError, runSessionErrorStatus(errResultsNotFound))
+}
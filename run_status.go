@@ -0,0 +1,243 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// The statuses of a TestRun's lifecycle. Runs are either uploaded complete, or created pending (or running) by
+// their runner, which reports their progress until they're complete or have failed.
+const (
+	RunStatusPending  = "pending"
+	RunStatusRunning  = "running"
+	RunStatusComplete = "complete"
+	RunStatusFailed   = "failed"
+)
+
+// RunStatuses are all of the valid TestRun statuses, in lifecycle order.
+var RunStatuses = []string{RunStatusPending, RunStatusRunning, RunStatusComplete, RunStatusFailed}
+
+// staleRunTimeout is how long a pending or running run can go without reporting progress before it's presumed to
+// have died, and is marked failed (see failStaleRuns).
+const staleRunTimeout = 6 * time.Hour
+
+// runStatusTransitions are the statuses each in-progress status can be updated to. Complete and failed runs are
+// final.
+var runStatusTransitions = map[string][]string{
+	RunStatusPending: {RunStatusPending, RunStatusRunning, RunStatusComplete, RunStatusFailed},
+	RunStatusRunning: {RunStatusRunning, RunStatusComplete, RunStatusFailed},
+}
+
+var errResultsURLRequired = invalidRunStatusUpdateError("a results_url is required to complete a run")
+
+// invalidRunStatusUpdateError is returned for a RunStatusUpdate which is invalid in itself, e.g. has an unknown
+// status.
+type invalidRunStatusUpdateError string
+
+func (err invalidRunStatusUpdateError) Error() string {
+	return string(err)
+}
+
+// runStatusTransitionError is returned when a run can't be updated to the requested status, e.g. because it has
+// already completed.
+type runStatusTransitionError struct {
+	from, to string
+}
+
+func (err runStatusTransitionError) Error() string {
+	return fmt.Sprintf("cannot update a %s run to %s", err.from, err.to)
+}
+
+// GetStatus returns the run's status, which is complete for runs without one.
+func (run TestRun) GetStatus() string {
+	if run.Status == "" {
+		return RunStatusComplete
+	}
+	return run.Status
+}
+
+// IsComplete returns whether the run has finished with results, i.e. whether its results can be shown.
+func (run TestRun) IsComplete() bool {
+	return run.GetStatus() == RunStatusComplete
+}
+
+// isValidRunStatus returns whether the given status is one of RunStatuses.
+func isValidRunStatus(status string) bool {
+	for _, valid := range RunStatuses {
+		if status == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// canTransitionRunStatus returns whether a run with the given status can be updated to the other status.
+func canTransitionRunStatus(from, to string) bool {
+	for _, allowed := range runStatusTransitions[from] {
+		if to == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRunProgress checks that the progress counts are consistent.
+func validateRunProgress(progress RunProgress) error {
+	if progress.Completed < 0 || progress.Expected < 0 {
+		return invalidRunStatusUpdateError("progress counts can't be negative")
+	} else if progress.Expected > 0 && progress.Completed > progress.Expected {
+		return invalidRunStatusUpdateError(fmt.Sprintf("completed tests (%d) exceed expected tests (%d)",
+			progress.Completed, progress.Expected))
+	}
+	return nil
+}
+
+// isDefaultRun is a filter for getTestRuns which only includes complete runs of all of the tests, i.e. the runs
+// included in results unless others are requested.
+func isDefaultRun(run TestRun) bool {
+	return run.IsComplete() && !run.IsPartial()
+}
+
+// getRunsFilter returns a filter for getTestRuns which includes runs with any of the given statuses (only complete
+// runs, when there are none), and partial runs if partial is true. It returns nil when all runs are included.
+// Runs can't be filtered by status in the query itself, since runs stored before statuses existed don't have one.
+func getRunsFilter(statuses []string, partial bool) func(TestRun) bool {
+	if len(statuses) == 0 {
+		statuses = []string{RunStatusComplete}
+	}
+	included := make(map[string]bool)
+	for _, status := range statuses {
+		included[status] = true
+	}
+	if partial && len(included) == len(RunStatuses) {
+		return nil
+	}
+	return func(run TestRun) bool {
+		return included[run.GetStatus()] && (partial || !run.IsPartial())
+	}
+}
+
+// RunStatusUpdate is the body of a request to update the status of an in-progress run (see updateRunStatus).
+type RunStatusUpdate struct {
+	// Status to update to; empty keeps the current status, e.g. to only report progress.
+	Status string `json:"status"`
+
+	// Progress replaces the run's progress, when present.
+	Progress *RunProgress `json:"progress"`
+
+	// ResultsURL of the run's summary, which is required to complete a run created without one.
+	ResultsURL string `json:"results_url"`
+}
+
+// validate checks the update itself, independent of the run it's applied to.
+func (update RunStatusUpdate) validate() error {
+	if update.Status != "" && !isValidRunStatus(update.Status) {
+		return invalidRunStatusUpdateError(fmt.Sprintf("invalid status %s (must be one of %s)", update.Status,
+			strings.Join(RunStatuses, ", ")))
+	}
+	if update.Progress != nil {
+		return validateRunProgress(*update.Progress)
+	}
+	return nil
+}
+
+// apply updates the run, at the given time. It returns a runStatusTransitionError if the run's status can't be
+// updated to the requested one.
+func (update RunStatusUpdate) apply(run *TestRun, now time.Time) error {
+	status := update.Status
+	if status == "" {
+		status = run.GetStatus()
+	}
+	if !canTransitionRunStatus(run.GetStatus(), status) {
+		return runStatusTransitionError{from: run.GetStatus(), to: status}
+	}
+	if update.Progress != nil {
+		run.Progress = *update.Progress
+	}
+	run.Progress.UpdatedAt = now
+	if update.ResultsURL != "" {
+		run.ResultsURL = update.ResultsURL
+	}
+	if status == RunStatusComplete {
+		if run.ResultsURL == "" {
+			return errResultsURLRequired
+		}
+		// Order the run by when it completed, like runs uploaded complete.
+		run.CreatedAt = now
+	}
+	run.Status = status
+	return nil
+}
+
+// updateRunStatus applies the update to the run with the given ID, returning the updated run, and whether the
+// update completed it (in which case it's ready to be processed like a newly uploaded run).
+func updateRunStatus(ctx context.Context, id int64, update RunStatusUpdate) (run TestRun, completed bool,
+	err error) {
+	if err = update.validate(); err != nil {
+		return run, false, err
+	}
+	key := datastore.NewKey(ctx, "TestRun", "", id, nil)
+	err = datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		run = TestRun{}
+		if err := datastore.Get(ctx, key, &run); err == datastore.ErrNoSuchEntity {
+			return testRunNotFoundError(id)
+		} else if err != nil {
+			return err
+		}
+		wasComplete := run.IsComplete()
+		if err := update.apply(&run, time.Now()); err != nil {
+			return err
+		}
+		completed = !wasComplete && run.IsComplete()
+		_, err := datastore.Put(ctx, key, &run)
+		return err
+	}, nil)
+	run.ID = id
+	return run, completed, err
+}
+
+// failStaleRuns marks pending and running runs which haven't reported progress within staleRunTimeout (of now) as
+// failed, since their runners have presumably died. It returns the number of runs marked failed.
+func failStaleRuns(ctx context.Context, now time.Time) (int, error) {
+	failed := 0
+	for _, status := range []string{RunStatusPending, RunStatusRunning} {
+		var testRuns []TestRun
+		keys, err := datastore.NewQuery("TestRun").Filter("Status =", status).GetAll(ctx, &testRuns)
+		if err != nil {
+			return failed, err
+		}
+		for i, run := range testRuns {
+			if now.Sub(run.Progress.UpdatedAt) < staleRunTimeout {
+				continue
+			}
+			update := RunStatusUpdate{Status: RunStatusFailed}
+			if _, _, err = updateRunStatus(ctx, keys[i].IntID(), update); err != nil {
+				if _, ok := err.(runStatusTransitionError); ok {
+					// Updated concurrently, e.g. by the runner completing it.
+					continue
+				}
+				return failed, err
+			}
+			failed++
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+// apiRunStatusHandler is responsible for (POST) updates of the status and progress of runs created pending or
+// running, from a JSON body in the format of RunStatusUpdate. Completing a run processes it like a newly uploaded
+// run. Requires the upload token, supplied in the 'secret' param.
+//
+// URL Params:
+//     run_id: ID of the run
+func apiRunStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkUploadToken(ctx, w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("run_id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid 'run_id' param", http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var update RunStatusUpdate
+	if err = json.Unmarshal(body, &update); err != nil {
+		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	run, completed, err := updateRunStatus(ctx, id, update)
+	if err != nil {
+		http.Error(w, err.Error(), runStatusErrorStatus(err))
+		return
+	}
+	noteRunnerSeen(ctx, run.Runner)
+	if completed {
+		processNewTestRun(ctx, run)
+	}
+
+	runBytes, err := json.Marshal(run)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(runBytes)
+}
+
+// cronFailStaleRunsHandler marks runs which have stopped reporting progress as failed (see failStaleRuns). It's
+// run by the App Engine cron service (see cron.yaml).
+func cronFailStaleRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkCronRequest(w, r) {
+		return
+	}
+
+	ctx := appengine.NewContext(r)
+	failed, err := failStaleRuns(ctx, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof(ctx, "Marked %d stale runs as failed", failed)
+}
+
+// runStatusErrorStatus returns the HTTP status for an error from updateRunStatus.
+func runStatusErrorStatus(err error) int {
+	switch err.(type) {
+	case testRunNotFoundError:
+		return http.StatusNotFound
+	case runStatusTransitionError:
+		return http.StatusConflict
+	case invalidRunStatusUpdateError:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
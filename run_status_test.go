@@ -0,0 +1,118 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestRun_GetStatus(t *testing.T) {
+	assert.Equal(t, RunStatusComplete, TestRun{}.GetStatus())
+	assert.True(t, TestRun{}.IsComplete())
+	assert.True(t, TestRun{Status: RunStatusComplete}.IsComplete())
+	assert.Equal(t, RunStatusRunning, TestRun{Status: RunStatusRunning}.GetStatus())
+	assert.False(t, TestRun{Status: RunStatusRunning}.IsComplete())
+	assert.False(t, TestRun{Status: RunStatusFailed}.IsComplete())
+}
+
+func TestCanTransitionRunStatus(t *testing.T) {
+	assert.True(t, canTransitionRunStatus(RunStatusPending, RunStatusRunning))
+	assert.True(t, canTransitionRunStatus(RunStatusPending, RunStatusFailed))
+	assert.True(t, canTransitionRunStatus(RunStatusRunning, RunStatusRunning))
+	assert.True(t, canTransitionRunStatus(RunStatusRunning, RunStatusComplete))
+	assert.False(t, canTransitionRunStatus(RunStatusRunning, RunStatusPending))
+	assert.False(t, canTransitionRunStatus(RunStatusComplete, RunStatusRunning))
+	assert.False(t, canTransitionRunStatus(RunStatusFailed, RunStatusComplete))
+}
+
+func TestGetRunsFilter(t *testing.T) {
+	legacy := TestRun{}
+	running := TestRun{Status: RunStatusRunning}
+	failed := TestRun{Status: RunStatusFailed}
+	partial := TestRun{Status: RunStatusComplete, Paths: []string{"/dom/"}}
+
+	include := getRunsFilter(nil, false)
+	assert.True(t, include(legacy))
+	assert.False(t, include(running))
+	assert.False(t, include(failed))
+	assert.False(t, include(partial))
+
+	include = getRunsFilter([]string{RunStatusRunning, RunStatusFailed}, false)
+	assert.False(t, include(legacy))
+	assert.True(t, include(running))
+	assert.True(t, include(failed))
+
+	include = getRunsFilter(nil, true)
+	assert.True(t, include(partial))
+	assert.False(t, include(running))
+
+	assert.Nil(t, getRunsFilter(RunStatuses, true))
+	assert.NotNil(t, getRunsFilter(RunStatuses, false))
+}
+
+func TestRunStatusUpdate_Validate(t *testing.T) {
+	assert.Nil(t, RunStatusUpdate{}.validate())
+	assert.Nil(t, RunStatusUpdate{Status: RunStatusRunning, Progress: &RunProgress{Completed: 5, Expected: 10}}.validate())
+	assert.Nil(t, RunStatusUpdate{Progress: &RunProgress{Completed: 5}}.validate())
+
+	for _, invalid := range []RunStatusUpdate{
+		{Status: "done"},
+		{Progress: &RunProgress{Completed: 11, Expected: 10}},
+		{Progress: &RunProgress{Completed: -1}},
+	} {
+		err := invalid.validate()
+		assert.IsType(t, invalidRunStatusUpdateError(""), err, "%+v", invalid)
+		assert.Equal(t, http.StatusBadRequest, runStatusErrorStatus(err))
+	}
+}
+
+func TestRunStatusUpdate_Apply(t *testing.T) {
+	created := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := created.Add(time.Hour)
+	run := TestRun{Status: RunStatusPending, CreatedAt: created}
+
+	progress := RunProgress{Completed: 10, Expected: 100}
+	assert.Nil(t, RunStatusUpdate{Status: RunStatusRunning, Progress: &progress}.apply(&run, now))
+	assert.Equal(t, RunStatusRunning, run.Status)
+	assert.Equal(t, RunProgress{Completed: 10, Expected: 100, UpdatedAt: now}, run.Progress)
+	assert.Equal(t, created, run.CreatedAt)
+
+	// Progress-only updates keep the status.
+	progress.Completed = 50
+	assert.Nil(t, RunStatusUpdate{Progress: &progress}.apply(&run, now))
+	assert.Equal(t, RunStatusRunning, run.Status)
+	assert.Equal(t, 50, run.Progress.Completed)
+
+	err := RunStatusUpdate{Status: RunStatusComplete}.apply(&run, now)
+	assert.Equal(t, errResultsURLRequired, err)
+	assert.Equal(t, http.StatusBadRequest, runStatusErrorStatus(err))
+
+	resultsURL := "https://storage.googleapis.com/wptd/abcdef0123/chrome-summary.json.gz"
+	assert.Nil(t, RunStatusUpdate{Status: RunStatusComplete, ResultsURL: resultsURL}.apply(&run, now))
+	assert.Equal(t, RunStatusComplete, run.Status)
+	assert.Equal(t, resultsURL, run.ResultsURL)
+	assert.Equal(t, now, run.CreatedAt)
+
+	err = RunStatusUpdate{Status: RunStatusFailed}.apply(&run, now)
+	assert.Equal(t, runStatusTransitionError{from: RunStatusComplete, to: RunStatusFailed}, err)
+	assert.Equal(t, http.StatusConflict, runStatusErrorStatus(err))
+
+	// Legacy runs are complete.
+	assert.NotNil(t, RunStatusUpdate{Progress: &progress}.apply(&TestRun{}, now))
+}
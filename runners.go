@@ -0,0 +1,200 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// runnerTimeout is how long a runner can go without being seen before it's presumed dead.
+const runnerTimeout = 15 * time.Minute
+
+// runnerStatsWindow is the period of recent runs counted in a runner's RunnerStatus.
+const runnerStatsWindow = 7 * 24 * time.Hour
+
+// runnerNameRegex matches valid runner names, e.g. "chrome-linux-1".
+var runnerNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._\-]*$`)
+
+var errRunnerNotFound = errors.New("runner not found")
+
+// RunnerStatus is a Runner, with whether it's alive and the outcomes of its recent runs.
+type RunnerStatus struct {
+	Runner
+
+	// Alive is whether the runner has been seen within runnerTimeout.
+	Alive bool `json:"alive"`
+
+	// Recent counts the runner's runs created within runnerStatsWindow.
+	Recent RunnerRunCounts `json:"recent"`
+}
+
+// RunnerRunCounts counts a runner's runs by status.
+type RunnerRunCounts struct {
+	Complete   int `json:"complete"`
+	Failed     int `json:"failed"`
+	InProgress int `json:"in_progress"`
+}
+
+// runnerKey returns the key of the Runner with the given name.
+func runnerKey(ctx context.Context, name string) *datastore.Key {
+	return datastore.NewKey(ctx, "Runner", name, 0, nil)
+}
+
+// validateRunnerName checks that the name is a valid runner name, e.g. "chrome-linux-1".
+func validateRunnerName(name string) error {
+	if !runnerNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid runner name %s", name)
+	}
+	return nil
+}
+
+// validateRunner checks the name and platforms of a runner being registered.
+func validateRunner(runner Runner) error {
+	if err := validateRunnerName(runner.Name); err != nil {
+		return err
+	}
+	for _, platform := range runner.Platforms {
+		if !PlatformIDRegex.MatchString(platform) {
+			return fmt.Errorf("invalid platform %s", platform)
+		}
+	}
+	return nil
+}
+
+// registerRunner creates or updates the runner, keeping the time it was first registered.
+func registerRunner(ctx context.Context, runner Runner, now time.Time) (Runner, error) {
+	key := runnerKey(ctx, runner.Name)
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var existing Runner
+		if err := datastore.Get(ctx, key, &existing); err == nil {
+			runner.RegisteredAt = existing.RegisteredAt
+		} else if err == datastore.ErrNoSuchEntity {
+			runner.RegisteredAt = now
+		} else {
+			return err
+		}
+		runner.LastSeenAt = now
+		_, err := datastore.Put(ctx, key, &runner)
+		return err
+	}, nil)
+	return runner, err
+}
+
+// touchRunner records that the named runner was seen at the given time. It returns errRunnerNotFound if the runner
+// isn't registered.
+func touchRunner(ctx context.Context, name string, now time.Time) error {
+	key := runnerKey(ctx, name)
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var runner Runner
+		if err := datastore.Get(ctx, key, &runner); err == datastore.ErrNoSuchEntity {
+			return errRunnerNotFound
+		} else if err != nil {
+			return err
+		}
+		if !runner.LastSeenAt.Before(now) {
+			return nil
+		}
+		runner.LastSeenAt = now
+		_, err := datastore.Put(ctx, key, &runner)
+		return err
+	}, nil)
+}
+
+// noteRunnerSeen records that the named runner (if any) was seen, e.g. when it uploads a run. Failures are logged
+// rather than returned, since they don't affect the request; runners needn't be registered.
+func noteRunnerSeen(ctx context.Context, name string) {
+	if name == "" {
+		return
+	}
+	if err := touchRunner(ctx, name, time.Now()); err != nil && err != errRunnerNotFound {
+		log.Warningf(ctx, "Failed to update runner %s: %s", name, err.Error())
+	}
+}
+
+// countRuns counts the runs by status.
+func countRuns(runs []TestRun) (counts RunnerRunCounts) {
+	for _, run := range runs {
+		switch run.GetStatus() {
+		case RunStatusComplete:
+			counts.Complete++
+		case RunStatusFailed:
+			counts.Failed++
+		default:
+			counts.InProgress++
+		}
+	}
+	return counts
+}
+
+// getRunnerStatus returns the status of the runner at the given time (see RunnerStatus).
+func getRunnerStatus(ctx context.Context, runner Runner, now time.Time) (RunnerStatus, error) {
+	status := RunnerStatus{Runner: runner, Alive: now.Sub(runner.LastSeenAt) < runnerTimeout}
+	var runs []TestRun
+	_, err := datastore.NewQuery("TestRun").
+		Filter("Runner =", runner.Name).
+		Filter("CreatedAt >=", now.Add(-runnerStatsWindow)).
+		GetAll(ctx, &runs)
+	if err != nil {
+		return status, err
+	}
+	status.Recent = countRuns(runs)
+	return status, nil
+}
+
+// loadRunnerStatuses returns the statuses of all of the registered runners, by name.
+func loadRunnerStatuses(ctx context.Context, now time.Time) ([]RunnerStatus, error) {
+	var runners []Runner
+	keys, err := datastore.NewQuery("Runner").Order("__key__").GetAll(ctx, &runners)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]RunnerStatus, len(runners))
+	ctx, cancel := context.WithTimeout(ctx, datastoreQueryTimeout)
+	defer cancel()
+	err = runConcurrently(ctx, len(runners), maxConcurrentQueries, func(ctx context.Context, i int) (err error) {
+		runners[i].Name = keys[i].StringID()
+		statuses[i], err = getRunnerStatus(ctx, runners[i], now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// LastSeenAgo formats how long ago the runner was last seen, e.g. "5m" or "3d".
+func (status RunnerStatus) LastSeenAgo() string {
+	return formatAge(time.Since(status.LastSeenAt))
+}
+
+// formatAge formats a duration coarsely, in its largest whole unit of days, hours or minutes.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "<1m"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
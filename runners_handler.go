@@ -0,0 +1,147 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// apiRunnersHandler is responsible for registering runners, and emitting the status of each registered runner
+// (see RunnerStatus). POST and DELETE require the upload token, supplied in the 'secret' param.
+//
+// GET emits the runners' statuses.
+// POST registers (or updates) a runner, from a JSON body in the format of the Runner model.
+// DELETE removes the runner with the given 'name' param.
+func apiRunnersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	switch r.Method {
+	case "GET":
+		statuses, err := loadRunnerStatuses(ctx, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if statuses == nil {
+			statuses = []RunnerStatus{}
+		}
+		statusesBytes, err := json.Marshal(statuses)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(statusesBytes)
+	case "POST":
+		if !checkUploadToken(ctx, w, r) {
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var runner Runner
+		if err = json.Unmarshal(body, &runner); err != nil {
+			http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = validateRunner(runner); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if runner, err = registerRunner(ctx, runner, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		runnerBytes, err := json.Marshal(runner)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(runnerBytes)
+	case "DELETE":
+		if !checkUploadToken(ctx, w, r) {
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if err := validateRunnerName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := datastore.Delete(ctx, runnerKey(ctx, name)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "This endpoint only supports GET, POST and DELETE.", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiRunnerHeartbeatHandler is responsible for (POST) heartbeats from registered runners, which record that
+// they're alive. Requires the upload token, supplied in the 'secret' param.
+//
+// URL Params:
+//     runner: Name of the runner
+func apiRunnerHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkUploadToken(ctx, w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("runner")
+	if err := validateRunnerName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := touchRunner(ctx, name, time.Now()); err == errRunnerNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runnersHandler handles GET requests to /runners, a page of the registered runners' statuses.
+func runnersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	statuses, err := loadRunnerStatuses(ctx, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Runners []RunnerStatus
+		Window  string
+	}{
+		statuses,
+		formatAge(runnerStatsWindow),
+	}
+	if err := templates.ExecuteTemplate(w, "runners.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
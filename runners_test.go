@@ -0,0 +1,74 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRunner(t *testing.T) {
+	assert.Nil(t, validateRunner(Runner{Name: "chrome-linux-1", Platforms: []string{"chrome-63.0-linux"}}))
+	assert.NotNil(t, validateRunner(Runner{}))
+	assert.NotNil(t, validateRunner(Runner{Name: "-runner"}))
+	assert.NotNil(t, validateRunner(Runner{Name: "runner/1"}))
+	assert.NotNil(t, validateRunner(Runner{Name: "runner", Platforms: []string{"Chrome 63"}}))
+}
+
+func TestCountRuns(t *testing.T) {
+	counts := countRuns([]TestRun{
+		{},
+		{Status: RunStatusComplete},
+		{Status: RunStatusFailed},
+		{Status: RunStatusRunning},
+		{Status: RunStatusPending},
+	})
+	assert.Equal(t, RunnerRunCounts{Complete: 2, Failed: 1, InProgress: 2}, counts)
+}
+
+func TestFormatAge(t *testing.T) {
+	assert.Equal(t, "<1m", formatAge(30*time.Second))
+	assert.Equal(t, "5m", formatAge(5*time.Minute+10*time.Second))
+	assert.Equal(t, "3h", formatAge(3*time.Hour+59*time.Minute))
+	assert.Equal(t, "7d", formatAge(runnerStatsWindow))
+}
+
+func TestRunnersTemplate(t *testing.T) {
+	data := struct {
+		Runners []RunnerStatus
+		Window  string
+	}{
+		[]RunnerStatus{
+			{
+				Runner: Runner{
+					Name:       "chrome-linux-1",
+					Platforms:  []string{"chrome-63.0-linux", "chrome-64.0-linux"},
+					Hostname:   "vm-1",
+					LastSeenAt: time.Now().Add(-time.Hour),
+				},
+				Recent: RunnerRunCounts{Complete: 6, Failed: 1},
+			},
+		},
+		"7d",
+	}
+	var out bytes.Buffer
+	assert.Nil(t, templates.ExecuteTemplate(&out, "runners.html", data))
+	assert.Contains(t, out.String(), "chrome-63.0-linux, chrome-64.0-linux")
+	assert.Contains(t, out.String(), `class="dead"`)
+	assert.Contains(t, out.String(), "1h ago")
+}
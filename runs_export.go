@@ -0,0 +1,195 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// exportScanLimit is the maximum number of TestRun entities read by a single /api/runs/export response, whether or
+// not they match the filter, so that selective filters don't read the whole kind in one request. A page may therefore
+// have few (or no) runs, and still be followed by another.
+const exportScanLimit = 10000
+
+// exportNextCursorHeader is the response header which holds the cursor for the next page of an export.
+const exportNextCursorHeader = "X-WPTD-Next-Cursor"
+
+// runsExportFilter holds the params which filter the runs emitted by /api/runs/export.
+type runsExportFilter struct {
+	// BrowserNames to include, or nil for all browsers.
+	BrowserNames []string
+
+	// Labels which the runs must all have.
+	Labels []string
+
+	// From (inclusive) and To (exclusive) bound the runs' CreatedAt, when non-zero.
+	From time.Time
+	To   time.Time
+}
+
+// parseRunsExportFilter parses the 'browser(s)', 'label(s)', 'from' and 'to' params.
+func parseRunsExportFilter(r *http.Request) (filter runsExportFilter, err error) {
+	if hasBrowsersParam(r) {
+		if filter.BrowserNames, err = ParseBrowsersParam(r); err != nil {
+			return filter, err
+		}
+	}
+	filter.Labels = ParseLabelsParam(r)
+	if filter.From, err = ParseDateTimeParam(r, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = ParseDateTimeParam(r, "to"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// query returns the Datastore query for the runs, in order of creation. Filters which would need a composite
+// index for every combination (i.e. multiple browsers, and labels) are left to matches.
+func (filter runsExportFilter) query() *datastore.Query {
+	query := datastore.NewQuery("TestRun").Order("CreatedAt")
+	if len(filter.BrowserNames) == 1 {
+		query = query.Filter("BrowserName =", filter.BrowserNames[0])
+	}
+	if !filter.From.IsZero() {
+		query = query.Filter("CreatedAt >=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Filter("CreatedAt <", filter.To)
+	}
+	return query
+}
+
+// matches determines whether the run matches the filter.
+func (filter runsExportFilter) matches(run TestRun) bool {
+	if filter.BrowserNames != nil && !containsString(filter.BrowserNames, run.BrowserName) {
+		return false
+	}
+	for _, label := range filter.Labels {
+		if !containsString(run.Labels, label) {
+			return false
+		}
+	}
+	if !filter.From.IsZero() && run.CreatedAt.Before(filter.From) {
+		return false
+	}
+	return filter.To.IsZero() || run.CreatedAt.Before(filter.To)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTestRunsExportHandler emits every TestRun matching the filter params, in order of creation, as
+// newline-delimited JSON. Runs are streamed a page at a time (see exportScanLimit); when there are more, the
+// response has the cursor for the next page in the X-WPTD-Next-Cursor header, and the URL of the next page in the
+// Link header (rel="next").
+//
+// URL Params:
+//     browser(s): (optional) Browser names to include (see ParseBrowsersParam); all browsers by default
+//     label(s): (optional) Labels which the runs must all have
+//     from: (optional) Earliest CreatedAt to include (RFC 3339, or YYYY-MM-DD)
+//     to: (optional) CreatedAt to stop before (RFC 3339, or YYYY-MM-DD)
+//     cursor: (optional) Cursor of the page to emit, from a previous response
+func apiTestRunsExportHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRunsExportFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := filter.query()
+	if param := r.URL.Query().Get("cursor"); param != "" {
+		cursor, err := datastore.DecodeCursor(param)
+		if err != nil {
+			http.Error(w, "invalid 'cursor' param: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		query = query.Start(cursor)
+	}
+
+	ctx := appengine.NewContext(r)
+	// Find the end of the page first, with a (cheap) keys-only query, so that the next page's cursor can be sent
+	// in the headers before the runs are streamed.
+	var next string
+	it := query.KeysOnly().Limit(exportScanLimit).Run(ctx)
+	scanned := 0
+	for {
+		if _, err := it.Next(nil); err == datastore.Done {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		scanned++
+	}
+	if scanned == exportScanLimit {
+		end, err := it.Cursor()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		next = end.String()
+		query = query.End(end)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if next != "" {
+		w.Header().Set(exportNextCursorHeader, next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, getExportPageURL(r.URL, next).String()))
+	}
+	encoder := json.NewEncoder(w)
+	emitted := false
+	it = query.Run(ctx)
+	for {
+		var testRun TestRun
+		key, err := it.Next(&testRun)
+		if err == datastore.Done {
+			return
+		} else if err != nil {
+			if !emitted {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			// Otherwise it's too late to change the status; the client will see a truncated body.
+			return
+		}
+		testRun.ID = key.IntID()
+		if !filter.matches(testRun) {
+			continue
+		}
+		if err = encoder.Encode(testRun); err != nil {
+			return
+		}
+		emitted = true
+	}
+}
+
+// getExportPageURL returns the (relative) URL of the export page starting at the given cursor.
+func getExportPageURL(u *url.URL, cursor string) *url.URL {
+	params := u.Query()
+	params.Set("cursor", cursor)
+	return &url.URL{Path: u.Path, RawQuery: params.Encode()}
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRunsExportFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/api/runs/export", nil)
+	filter, err := parseRunsExportFilter(r)
+	assert.Nil(t, err)
+	assert.Equal(t, runsExportFilter{}, filter)
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs/export?label=stable&from=2018-01-01&to=2018-02-01", nil)
+	filter, err = parseRunsExportFilter(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"stable"}, filter.Labels)
+	assert.Equal(t, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), filter.From)
+	assert.Equal(t, time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC), filter.To)
+
+	r = httptest.NewRequest("GET", "http://wpt.fyi/api/runs/export?from=yesterday", nil)
+	_, err = parseRunsExportFilter(r)
+	assert.NotNil(t, err)
+}
+
+func TestRunsExportFilterMatches(t *testing.T) {
+	jan := time.Date(2018, 1, 15, 0, 0, 0, 0, time.UTC)
+	run := TestRun{BrowserName: "chrome", Labels: []string{"stable", "sauce"}, CreatedAt: jan}
+	assert.True(t, runsExportFilter{}.matches(run))
+	assert.True(t, runsExportFilter{BrowserNames: []string{"firefox", "chrome"}}.matches(run))
+	assert.False(t, runsExportFilter{BrowserNames: []string{"firefox"}}.matches(run))
+	assert.True(t, runsExportFilter{Labels: []string{"sauce", "stable"}}.matches(run))
+	assert.False(t, runsExportFilter{Labels: []string{"stable", "experimental"}}.matches(run))
+	assert.True(t, runsExportFilter{From: jan, To: jan.Add(time.Hour)}.matches(run))
+	assert.False(t, runsExportFilter{To: jan}.matches(run))
+	assert.False(t, runsExportFilter{From: jan.Add(time.Second)}.matches(run))
+}
+
+func TestGetExportPageURL(t *testing.T) {
+	u, _ := url.Parse("https://wpt.fyi/api/runs/export?label=stable&cursor=abc")
+	assert.Equal(t, "/api/runs/export?cursor=def&label=stable", getExportPageURL(u, "def").String())
+}
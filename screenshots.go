@@ -0,0 +1,150 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// The kinds of reftest screenshots: of the test itself, and of its reference.
+const (
+	ScreenshotTest = "test"
+	ScreenshotRef  = "ref"
+)
+
+// ScreenshotKinds are all of the kinds of screenshots.
+var ScreenshotKinds = []string{ScreenshotTest, ScreenshotRef}
+
+// maxScreenshotSize is the maximum size of an uploaded screenshot (PNG).
+const maxScreenshotSize = 10 << 20
+
+// maxScreenshotDimension is the maximum width and height of a screenshot, which bounds the memory used to diff
+// them. The diff image spans both screenshots, so is at most 2048x2048 too: 16MB at 4 bytes per pixel. Decoded
+// screenshots take 16MB each, or 32MB for 16-bit PNGs, so a diff takes at most 80MB, which fits on the default (F1)
+// instance class.
+const maxScreenshotDimension = 2048
+
+var errScreenshotTooLarge = fmt.Errorf("screenshot is larger than %d bytes", maxScreenshotSize)
+
+// errNoResultsURL is returned when a run's results files are needed before it has a results URL, i.e. before its
+// summary has been uploaded; the results URL determines where the rest of the run's files are stored.
+var errNoResultsURL = errors.New("the run has no results_url yet")
+
+// getScreenshotFile returns the path of a test's screenshot of the given kind, relative to the run's results
+// directory, e.g. /css/a.html.ref.png, so screenshots are stored next to the test's results file.
+func getScreenshotFile(test, kind string) string {
+	return fmt.Sprintf("%s.%s.png", test, kind)
+}
+
+// validateScreenshotParams checks the test path and kind of a screenshot.
+func validateScreenshotParams(test, kind string) error {
+	if !strings.HasPrefix(test, "/") || path.Clean(test) != test || test == "/" {
+		return fmt.Errorf("invalid test %s (must be a path starting with /)", test)
+	}
+	if !containsString(ScreenshotKinds, kind) {
+		return fmt.Errorf("invalid kind %s (must be one of %s)", kind, strings.Join(ScreenshotKinds, ", "))
+	}
+	return nil
+}
+
+// decodeScreenshot decodes a PNG screenshot, checking its dimensions before decoding the pixels.
+func decodeScreenshot(data []byte) (image.Image, error) {
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width > maxScreenshotDimension || config.Height > maxScreenshotDimension {
+		return nil, fmt.Errorf("screenshot is larger than %dx%d pixels (%dx%d)", maxScreenshotDimension,
+			maxScreenshotDimension, config.Width, config.Height)
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// readScreenshot reads an uploaded screenshot, checking that it's a valid PNG.
+func readScreenshot(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxScreenshotSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > maxScreenshotSize {
+		return nil, errScreenshotTooLarge
+	}
+	if _, err = decodeScreenshot(data); err != nil {
+		return nil, fmt.Errorf("invalid PNG: %s", err.Error())
+	}
+	return data, nil
+}
+
+// writeScreenshot stores the run's screenshot of the test, returning its URL.
+func writeScreenshot(ctx context.Context, writer ResultsWriter, run TestRun, test, kind string, data []byte) (
+	string, error) {
+	if run.ResultsURL == "" {
+		return "", errNoResultsURL
+	}
+	name, err := getResultsPath(run, getScreenshotFile(test, kind))
+	if err != nil {
+		return "", err
+	}
+	gzipped, err := gzipData(data)
+	if err != nil {
+		return "", err
+	}
+	return writer.Write(ctx, name, gzipped)
+}
+
+// loadScreenshot reads the run's screenshot of the test from the results store. It returns errResultsNotFound if
+// there isn't one.
+func loadScreenshot(ctx context.Context, store ResultsStore, run TestRun, test, kind string) ([]byte, error) {
+	if run.ResultsURL == "" {
+		return nil, errResultsNotFound
+	}
+	f, err := store.Open(ctx, run, getScreenshotFile(test, kind))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxScreenshotSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > maxScreenshotSize {
+		return nil, errScreenshotTooLarge
+	}
+	return data, nil
+}
+
+// diffScreenshots compares the run's test and reference screenshots of the test.
+func diffScreenshots(ctx context.Context, store ResultsStore, run TestRun, test string, tolerance int) (
+	ImageDiff, error) {
+	var images [2]image.Image
+	for i, kind := range []string{ScreenshotTest, ScreenshotRef} {
+		data, err := loadScreenshot(ctx, store, run, test, kind)
+		if err != nil {
+			return ImageDiff{}, err
+		}
+		if images[i], err = decodeScreenshot(data); err != nil {
+			return ImageDiff{}, fmt.Errorf("invalid %s screenshot: %s", kind, err.Error())
+		}
+	}
+	return DiffImages(images[0], images[1], tolerance), nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/appengine"
+)
+
+// apiScreenshotHandler is responsible for the screenshots of a run's reftests: the screenshot of the test itself
+// and of its reference. GET serves a screenshot, and PUT (or POST) uploads one from a PNG body, replacing any
+// previous one; uploads require the upload token, supplied in the 'secret' param, and the run's results_url.
+//
+// URL Params:
+//     run_id: ID of the run
+//     test: Path of the reftest, e.g. '/css/css-flexbox/align-content-001.htm'
+//     kind: 'test' or 'ref'
+func apiScreenshotHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if r.Method != "GET" && r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "This endpoint only supports GET, PUT and POST.", http.StatusMethodNotAllowed)
+		return
+	} else if r.Method != "GET" && !checkUploadToken(ctx, w, r) {
+		return
+	}
+	test, kind := r.URL.Query().Get("test"), r.URL.Query().Get("kind")
+	if err := validateScreenshotParams(test, kind); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	run, ok := loadRunByIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "GET" {
+		data, err := loadScreenshot(ctx, getResultsStore(ctx, r), run, test, kind)
+		if err == errResultsNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+		return
+	}
+
+	data, err := readScreenshot(r.Body)
+	if err == errScreenshotTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	screenshotURL, err := writeScreenshot(ctx, getResultsWriter(), run, test, kind, data)
+	if err == errNoResultsURL {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	responseBytes, err := json.Marshal(map[string]string{"url": screenshotURL})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(responseBytes)
+}
+
+// apiScreenshotDiffHandler is responsible for emitting the pixel diff of a reftest's test and reference
+// screenshots (see DiffImages): a PNG of the test screenshot with the differing pixels marked in red, with the
+// number of differing pixels in the X-WPTD-Differing-Pixels header, or just the counts, as JSON.
+//
+// URL Params:
+//     run_id: ID of the run
+//     test: Path of the reftest
+//     tolerance: (optional) Maximum difference (0-255) in any color channel of pixels which match (default 0)
+//     format: (optional) 'png' (the default) or 'json'
+func apiScreenshotDiffHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	test := params.Get("test")
+	if err := validateScreenshotParams(test, ScreenshotTest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tolerance := 0
+	if param := params.Get("tolerance"); param != "" {
+		var err error
+		if tolerance, err = strconv.Atoi(param); err != nil || tolerance < 0 || tolerance > 255 {
+			http.Error(w, "Invalid 'tolerance' param: "+param, http.StatusBadRequest)
+			return
+		}
+	}
+	format := params.Get("format")
+	if format != "" && format != "png" && format != "json" {
+		http.Error(w, "Invalid 'format' param: "+format, http.StatusBadRequest)
+		return
+	}
+
+	ctx := appengine.NewContext(r)
+	run, ok := loadRunByIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	diff, err := diffScreenshots(ctx, getResultsStore(ctx, r), run, test, tolerance)
+	if err == errResultsNotFound {
+		http.Error(w, "The run doesn't have both screenshots of "+test, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-WPTD-Differing-Pixels", strconv.Itoa(diff.DifferingPixels))
+	w.Header().Set("X-WPTD-Max-Difference", strconv.Itoa(diff.MaxDifference))
+	if format == "json" {
+		diffBytes, err := json.Marshal(diff)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(diffBytes)
+		return
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, diff.Image); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func encodeTestPNG(t *testing.T, width, height int, c color.Color) []byte {
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, newTestImage(width, height, c)))
+	return buf.Bytes()
+}
+
+func TestValidateScreenshotParams(t *testing.T) {
+	assert.Nil(t, validateScreenshotParams("/css/a.html", ScreenshotTest))
+	assert.Nil(t, validateScreenshotParams("/css/a.html", ScreenshotRef))
+	assert.NotNil(t, validateScreenshotParams("/css/a.html", "diff"))
+	assert.NotNil(t, validateScreenshotParams("css/a.html", ScreenshotTest))
+	assert.NotNil(t, validateScreenshotParams("/css/../../a.html", ScreenshotTest))
+	assert.NotNil(t, validateScreenshotParams("/", ScreenshotTest))
+}
+
+func TestReadScreenshot(t *testing.T) {
+	data := encodeTestPNG(t, 2, 2, color.White)
+	read, err := readScreenshot(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, data, read)
+
+	_, err = readScreenshot(strings.NewReader("GIF89a"))
+	assert.NotNil(t, err)
+}
+
+func TestDecodeScreenshot_Dimensions(t *testing.T) {
+	_, err := decodeScreenshot(encodeTestPNG(t, maxScreenshotDimension, 1, color.White))
+	assert.Nil(t, err)
+	// Both dimensions are limited, not just the pixel count, since the diff image spans both screenshots.
+	_, err = decodeScreenshot(encodeTestPNG(t, maxScreenshotDimension+1, 1, color.White))
+	assert.NotNil(t, err)
+	_, err = decodeScreenshot(encodeTestPNG(t, 1, maxScreenshotDimension+1, color.White))
+	assert.NotNil(t, err)
+}
+
+func TestScreenshots_WriteLoadAndDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "screenshots")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := localResultsStore{dir: dir}
+	ctx := context.Background()
+
+	run := TestRun{Revision: "abcdef0123"}
+	_, err = writeScreenshot(ctx, store, run, "/css/a.html", ScreenshotTest, nil)
+	assert.Equal(t, errNoResultsURL, err)
+	_, err = loadScreenshot(ctx, store, run, "/css/a.html", ScreenshotTest)
+	assert.Equal(t, errResultsNotFound, err)
+
+	run.ResultsURL = "https://storage.googleapis.com/wptd/abcdef0123/chrome-63.0-linux-summary.json.gz"
+	testPNG := encodeTestPNG(t, 3, 2, color.White)
+	screenshotURL, err := writeScreenshot(ctx, store, run, "/css/a.html", ScreenshotTest, testPNG)
+	assert.Nil(t, err)
+	assert.Equal(t, "/abcdef0123/chrome-63.0-linux/css/a.html.test.png", screenshotURL)
+	_, err = os.Stat(filepath.Join(dir, "abcdef0123", "chrome-63.0-linux", "css", "a.html.test.png"))
+	assert.Nil(t, err)
+
+	loaded, err := loadScreenshot(ctx, store, run, "/css/a.html", ScreenshotTest)
+	assert.Nil(t, err)
+	assert.Equal(t, testPNG, loaded)
+
+	_, err = diffScreenshots(ctx, store, run, "/css/a.html", 0)
+	assert.Equal(t, errResultsNotFound, err)
+
+	_, err = writeScreenshot(ctx, store, run, "/css/a.html", ScreenshotRef, encodeTestPNG(t, 3, 2, color.Black))
+	assert.Nil(t, err)
+	diff, err := diffScreenshots(ctx, store, run, "/css/a.html", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, diff.DifferingPixels)
+}
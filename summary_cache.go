@@ -0,0 +1,131 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// summaryCacheSize is the number of parsed results summaries kept in memory (per instance).
+// A summary for a full run is a few megabytes once parsed.
+const summaryCacheSize = 16
+
+// summaries caches parsed results summaries by their ResultsURL. Summaries are immutable once uploaded for a
+// revision, so entries never need invalidating; they're only evicted when least recently used.
+var summaries = newSummaryCache(summaryCacheSize)
+
+// summaryCache is an LRU cache of parsed results summaries, keyed by URL. Concurrent requests for the same
+// (uncached) URL share a single load, made with the first caller's context; if that context is done before the
+// load succeeds, the other callers retry with their own. Cached values are shared, so must not be modified by
+// callers.
+type summaryCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // Front is the most recently used.
+	loading  map[string]*summaryLoad
+}
+
+type summaryCacheEntry struct {
+	url     string
+	results *ResultsSummary
+}
+
+// summaryLoad is an in-flight load of a summary, made with ctx; done is closed once results or err is set.
+type summaryLoad struct {
+	ctx     context.Context
+	done    chan struct{}
+	results *ResultsSummary
+	err     error
+}
+
+// errSummaryLoadPanicked is the error shared with the callers waiting on a load which panicked.
+var errSummaryLoadPanicked = errors.New("summary load panicked")
+
+func newSummaryCache(capacity int) *summaryCache {
+	return &summaryCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		loading:  make(map[string]*summaryLoad),
+	}
+}
+
+// get returns the cached summary for the given URL, or calls load to fetch it (caching the result on success).
+func (c *summaryCache) get(ctx context.Context, url string,
+	load func(context.Context) (*ResultsSummary, error)) (*ResultsSummary, error) {
+	for {
+		c.mu.Lock()
+		if elem, ok := c.entries[url]; ok {
+			c.order.MoveToFront(elem)
+			c.mu.Unlock()
+			return elem.Value.(*summaryCacheEntry).results, nil
+		}
+		inFlight, ok := c.loading[url]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+		select {
+		case <-inFlight.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// Only retry when the load failed because its caller went away, not for errors any caller would get.
+		if inFlight.err == nil || inFlight.ctx.Err() == nil || ctx.Err() != nil {
+			return inFlight.results, inFlight.err
+		}
+	}
+	call := &summaryLoad{ctx: ctx, done: make(chan struct{}), err: errSummaryLoadPanicked}
+	c.loading[url] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.loading, url)
+		if call.err == nil {
+			c.add(url, call.results)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.results, call.err = load(ctx)
+	return call.results, call.err
+}
+
+// add inserts an entry, evicting the least recently used entry if over capacity. c.mu must be held.
+func (c *summaryCache) add(url string, results *ResultsSummary) {
+	if elem, ok := c.entries[url]; ok {
+		elem.Value.(*summaryCacheEntry).results = results
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[url] = c.order.PushFront(&summaryCacheEntry{url, results})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*summaryCacheEntry).url)
+	}
+}
+
+func (c *summaryCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
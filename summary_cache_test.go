@@ -0,0 +1,173 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func loadSummary(results *ResultsSummary, loads *int) func(context.Context) (*ResultsSummary, error) {
+	return func(context.Context) (*ResultsSummary, error) {
+		*loads++
+		return results, nil
+	}
+}
+
+func TestSummaryCache_Hit(t *testing.T) {
+	cache := newSummaryCache(2)
+	summary := NewResultsSummary(map[string][]int{"/a.html": {1, 1}})
+	loads := 0
+
+	results, err := cache.get(context.Background(), "a", loadSummary(summary, &loads))
+	assert.Nil(t, err)
+	assert.Equal(t, summary, results)
+	results, err = cache.get(context.Background(), "a", loadSummary(nil, &loads))
+	assert.Nil(t, err)
+	assert.Equal(t, summary, results)
+	assert.Equal(t, 1, loads)
+}
+
+func TestSummaryCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newSummaryCache(2)
+	loads := 0
+	cache.get(context.Background(), "a", loadSummary(&ResultsSummary{}, &loads))
+	cache.get(context.Background(), "b", loadSummary(&ResultsSummary{}, &loads))
+	cache.get(context.Background(), "a", loadSummary(&ResultsSummary{}, &loads)) // b is now least recently used.
+	cache.get(context.Background(), "c", loadSummary(&ResultsSummary{}, &loads))
+	assert.Equal(t, 3, loads)
+	assert.Equal(t, 2, cache.len())
+
+	cache.get(context.Background(), "a", loadSummary(&ResultsSummary{}, &loads))
+	assert.Equal(t, 3, loads)
+	cache.get(context.Background(), "b", loadSummary(&ResultsSummary{}, &loads))
+	assert.Equal(t, 4, loads)
+}
+
+func TestSummaryCache_ErrorsNotCached(t *testing.T) {
+	cache := newSummaryCache(2)
+	_, err := cache.get(context.Background(), "a", func(context.Context) (*ResultsSummary, error) {
+		return nil, errors.New("fetch failed")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, cache.len())
+}
+
+func TestSummaryCache_ConcurrentLoadsShared(t *testing.T) {
+	cache := newSummaryCache(2)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	loads := 0
+	load := func(context.Context) (*ResultsSummary, error) {
+		mu.Lock()
+		loads++
+		mu.Unlock()
+		<-release
+		return &ResultsSummary{}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.get(context.Background(), "a", load)
+			assert.Nil(t, err)
+		}()
+	}
+	// Wait until the first load has started before letting it finish.
+	for {
+		mu.Lock()
+		started := loads > 0
+		mu.Unlock()
+		if started {
+			break
+		}
+	}
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, loads)
+}
+
+// waitForLoad blocks until the first load of the URL has registered, so that later gets wait on it.
+func waitForLoad(cache *summaryCache, url string) {
+	for {
+		cache.mu.Lock()
+		_, loading := cache.loading[url]
+		cache.mu.Unlock()
+		if loading {
+			return
+		}
+	}
+}
+
+func TestSummaryCache_RetriesLoadCancelledByItsCaller(t *testing.T) {
+	cache := newSummaryCache(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := cache.get(ctx, "a", func(ctx context.Context) (*ResultsSummary, error) {
+			<-ctx.Done()
+			return nil, errors.New("failed to load a: " + ctx.Err().Error())
+		})
+		cancelled <- err
+	}()
+	waitForLoad(cache, "a")
+
+	summary := &ResultsSummary{}
+	loads := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		results, err := cache.get(context.Background(), "a", loadSummary(summary, &loads))
+		assert.Nil(t, err)
+		assert.Equal(t, summary, results)
+	}()
+	cancel()
+	assert.NotNil(t, <-cancelled)
+	<-done
+	assert.Equal(t, 1, loads)
+	assert.Equal(t, 1, cache.len())
+}
+
+func TestSummaryCache_LoadPanics(t *testing.T) {
+	cache := newSummaryCache(2)
+	release := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		cache.get(context.Background(), "a", func(context.Context) (*ResultsSummary, error) {
+			<-release
+			panic("load failed")
+		})
+	}()
+	waitForLoad(cache, "a")
+
+	// The waiter either shares the panicked load's error, or (if it arrives late) makes its own load, which fails.
+	done := make(chan error)
+	go func() {
+		_, err := cache.get(context.Background(), "a", func(context.Context) (*ResultsSummary, error) {
+			return nil, errors.New("fetch failed")
+		})
+		done <- err
+	}()
+	close(release)
+	assert.NotNil(t, <-done)
+	assert.Equal(t, 0, cache.len())
+	assert.Len(t, cache.loading, 0)
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// tasksPath is the path prefix of the handlers for queued tasks, followed by the task name.
+const tasksPath = "/tasks/"
+
+// taskQueueEnvVar selects the TaskQueue implementation; "local" selects localTaskQueue (run by the
+// /cron/tasks/run cron job), and anything else the App Engine task queue.
+const taskQueueEnvVar = "WPTD_TASK_QUEUE"
+
+// taskMaxAttempts is the number of times a failing task is attempted before it's abandoned.
+const taskMaxAttempts = 5
+
+// taskInitialBackoff is the delay before a failed task's first retry, doubled for each subsequent retry.
+const taskInitialBackoff = 10 * time.Second
+
+// TaskQueue schedules background tasks, which are retried until they succeed (or run out of attempts).
+type TaskQueue interface {
+	// Enqueue schedules the task registered with the given name (see registerTask), passing it the JSON encoding
+	// of the payload.
+	Enqueue(ctx context.Context, name string, payload interface{}) error
+}
+
+// TaskHandler performs a task, given its JSON payload. Returning an error causes the task to be retried, so
+// handlers should be idempotent.
+type TaskHandler func(ctx context.Context, r *http.Request, payload []byte) error
+
+var (
+	taskHandlersMutex sync.RWMutex
+	taskHandlers      = make(map[string]TaskHandler)
+)
+
+// registerTask registers the handler for tasks with the given name.
+func registerTask(name string, handler TaskHandler) {
+	taskHandlersMutex.Lock()
+	defer taskHandlersMutex.Unlock()
+	taskHandlers[name] = handler
+}
+
+// getTaskHandler returns the handler registered for tasks with the given name, or nil.
+func getTaskHandler(name string) TaskHandler {
+	taskHandlersMutex.RLock()
+	defer taskHandlersMutex.RUnlock()
+	return taskHandlers[name]
+}
+
+var (
+	taskQueueOnce sync.Once
+	taskQueue     TaskQueue
+)
+
+// getTaskQueue returns the TaskQueue selected by the WPTD_TASK_QUEUE environment variable.
+func getTaskQueue() TaskQueue {
+	taskQueueOnce.Do(func() {
+		if os.Getenv(taskQueueEnvVar) == "local" {
+			taskQueue = newLocalTaskQueue(datastoreTaskStore{})
+		} else {
+			taskQueue = appEngineTaskQueue{}
+		}
+	})
+	return taskQueue
+}
+
+// appEngineTaskQueue is a TaskQueue which pushes tasks to the default App Engine push queue, which delivers them
+// to tasksHandler.
+type appEngineTaskQueue struct{}
+
+func (appEngineTaskQueue) Enqueue(ctx context.Context, name string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	task := &taskqueue.Task{
+		Path:    tasksPath + name,
+		Payload: body,
+		Header:  http.Header{"Content-Type": []string{"application/json"}},
+		Method:  "POST",
+		RetryOptions: &taskqueue.RetryOptions{
+			RetryLimit: taskMaxAttempts - 1,
+			MinBackoff: taskInitialBackoff,
+		},
+	}
+	_, err = taskqueue.Add(ctx, task, "")
+	return err
+}
+
+// tasksHandler performs the tasks pushed by the App Engine task queue, at /tasks/<name>. Failing with any non-2xx
+// status makes the queue retry the task.
+func tasksHandler(w http.ResponseWriter, r *http.Request) {
+	// App Engine strips this header from external requests, so its presence means the queue sent the request.
+	if r.Header.Get("X-AppEngine-QueueName") == "" {
+		http.Error(w, "Tasks can only be sent by the task queue", http.StatusForbidden)
+		return
+	}
+
+	ctx := appengine.NewContext(r)
+	name := strings.TrimPrefix(r.URL.Path, tasksPath)
+	handler := getTaskHandler(name)
+	if handler == nil {
+		// The queue retries any non-2xx status, so succeed, rather than retry a task which can never succeed.
+		log.Errorf(ctx, "Dropping unknown task %s", name)
+		return
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = handler(ctx, r, payload); err != nil {
+		log.Warningf(ctx, "Task %s failed (retry count %s): %s",
+			name, r.Header.Get("X-AppEngine-TaskRetryCount"), err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// cronRunTasksHandler runs the due tasks of the local task queue (see localTaskQueue). It's run by the App Engine
+// cron service (see cron.yaml), and does nothing when tasks are pushed to the App Engine task queue instead.
+func cronRunTasksHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkCronRequest(w, r) {
+		return
+	}
+	queue, ok := getTaskQueue().(*localTaskQueue)
+	if !ok {
+		return
+	}
+	ctx := appengine.NewContext(r)
+	ran, failed, err := queue.runDue(ctx, r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ran > 0 {
+		log.Infof(ctx, "Ran %d tasks, of which %d failed", ran, failed)
+	}
+}
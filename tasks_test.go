@@ -0,0 +1,41 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTasksHandler_RequiresQueue(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://wpt.fyi/tasks/"+processRunTask, nil)
+	w := httptest.NewRecorder()
+	tasksHandler(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCronRunTasksHandler_RequiresCron(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://wpt.fyi/cron/tasks/run", nil)
+	w := httptest.NewRecorder()
+	cronRunTasksHandler(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestProcessRunTaskRegistered(t *testing.T) {
+	assert.NotNil(t, getTaskHandler(processRunTask))
+}
@@ -18,6 +18,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine"
 )
 
 // This handler is responsible for all pages that display test results.
@@ -27,15 +33,58 @@ import (
 //
 // The browsers initially displayed to the user are defined in browsers.json.
 // The JSON property "initially_loaded" is what controls this.
+//
+// URL Params:
+//     sha: SHA[0:10] of the runs to show (or 'latest', the default)
+//     complete: (optional) When 'latest', show the latest SHA with runs for all browsers (or aligned runs)
+//     aligned: (optional) When 'latest', show the run of each browser closest in time to the anchor
+//     anchor: (optional) RFC 3339 time to align runs with
+//     run_ids: (optional) Comma-separated IDs of the specific runs to show (see apiPermalinkHandler)
+//     permalink: (optional) Redirect to the equivalent URL for the concrete runs currently shown
 func testHandler(w http.ResponseWriter, r *http.Request) {
-	runSHA, err := ParseSHAParam(r)
+	selection, err := ParseTestRunSelection(r)
 	if err != nil {
 		http.Error(w, "Invalid query params", http.StatusBadRequest)
 		return
 	}
 
-	const sourceURL = `/api/runs?sha=%s`
-	testRunSources := []string{fmt.Sprintf(sourceURL, runSHA)}
+	if ParseBooleanParam(r, "permalink") {
+		ctx := appengine.NewContext(r)
+		testRuns, _, err := loadTestRuns(ctx, selection)
+		if _, ok := err.(testRunNotFoundError); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if len(testRuns) == 0 {
+			http.Error(w, "No runs found", http.StatusNotFound)
+			return
+		}
+		redirectToPermalink(w, r, testRuns)
+		return
+	}
+
+	var sourceURL string
+	runIDs := make([]string, len(selection.IDs))
+	if len(selection.IDs) > 0 {
+		for i, id := range selection.IDs {
+			runIDs[i] = strconv.FormatInt(id, 10)
+		}
+		sourceURL = fmt.Sprintf(`/api/runs?run_ids=%s`, strings.Join(runIDs, ","))
+	} else {
+		sourceURL = fmt.Sprintf(`/api/runs?sha=%s`, selection.SHA)
+		if selection.Complete {
+			sourceURL += "&complete=true"
+		}
+		if selection.Aligned {
+			sourceURL += "&aligned=true"
+		}
+		if !selection.Anchor.IsZero() {
+			sourceURL += "&anchor=" + url.QueryEscape(selection.Anchor.Format(time.RFC3339))
+		}
+	}
+	testRunSources := []string{sourceURL}
 
 	testRunSourcesBytes, err := json.Marshal(testRunSources)
 	if err != nil {
@@ -46,9 +95,11 @@ func testHandler(w http.ResponseWriter, r *http.Request) {
 	data := struct {
 		TestRunSources string
 		SHA            string
+		RunIDs         string
 	}{
 		string(testRunSourcesBytes),
-		runSHA,
+		selection.SHA,
+		strings.Join(runIDs, ","),
 	}
 
 	if err := templates.ExecuteTemplate(w, "index.html", data); err != nil {
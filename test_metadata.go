@@ -0,0 +1,291 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// maxDatastoreBatch is the maximum number of entities written or deleted in a single Datastore call.
+const maxDatastoreBatch = 500
+
+// testMetadataNotFoundError is returned when there's no TestMetadata with the given ID.
+type testMetadataNotFoundError int64
+
+func (id testMetadataNotFoundError) Error() string {
+	return fmt.Sprintf("Test metadata %d not found", int64(id))
+}
+
+// testMetadataRootKey is the parent of all TestMetadata entities, so that they can be read with a strongly
+// consistent (ancestor) query straight after they are modified.
+func testMetadataRootKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "TestMetadataRoot", "default", 0, nil)
+}
+
+func testMetadataKey(ctx context.Context, id int64) *datastore.Key {
+	return datastore.NewKey(ctx, "TestMetadata", "", id, testMetadataRootKey(ctx))
+}
+
+// isDirectory determines whether the metadata applies to all of the tests under a directory, rather than a test.
+func (metadata TestMetadata) isDirectory() bool {
+	return strings.HasSuffix(metadata.Test, "/")
+}
+
+// validateTestMetadata checks that the metadata has a valid test path (or directory prefix), subtest, platform and
+// bug URL.
+func validateTestMetadata(metadata TestMetadata) error {
+	cleaned := path.Clean(metadata.Test)
+	if metadata.isDirectory() && cleaned != "/" {
+		cleaned += "/"
+	}
+	if !strings.HasPrefix(metadata.Test, "/") || cleaned != metadata.Test {
+		return fmt.Errorf("invalid test %s (must be a path starting with /; directories end with /)", metadata.Test)
+	}
+	if metadata.Subtest != "" && metadata.isDirectory() {
+		return fmt.Errorf("subtest %s requires a test, not the directory %s", metadata.Subtest, metadata.Test)
+	}
+	if metadata.Platform != "" && !IsBrowserName(metadata.Platform) {
+		return fmt.Errorf("invalid platform %s", metadata.Platform)
+	}
+	parsed, err := url.Parse(metadata.BugURL)
+	if err != nil || !parsed.IsAbs() || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("invalid bug_url %s", metadata.BugURL)
+	}
+	return nil
+}
+
+// appliesTo determines whether the metadata applies to the given test (or any of its subtests) on the given
+// platform (browser name), or on any platform when platform is empty.
+func (metadata TestMetadata) appliesTo(test string, platform string) bool {
+	if platform != "" && metadata.Platform != "" && metadata.Platform != platform {
+		return false
+	}
+	if metadata.isDirectory() {
+		return strings.HasPrefix(test, metadata.Test)
+	}
+	return test == metadata.Test
+}
+
+// annotateTests returns the metadata which applies to each of the given tests on any of the given platforms, keyed
+// by test. Tests without any metadata are omitted.
+func annotateTests(metadata []TestMetadata, tests []string, platforms []string) map[string][]TestMetadata {
+	annotations := make(map[string][]TestMetadata)
+	for _, test := range tests {
+		for _, m := range metadata {
+			for _, platform := range platforms {
+				if m.appliesTo(test, platform) {
+					annotations[test] = append(annotations[test], m)
+					break
+				}
+			}
+		}
+	}
+	return annotations
+}
+
+// sortTestMetadata orders the metadata by test, then subtest, then platform.
+func sortTestMetadata(metadata []TestMetadata) {
+	sort.SliceStable(metadata, func(i, j int) bool {
+		a, b := metadata[i], metadata[j]
+		if a.Test != b.Test {
+			return a.Test < b.Test
+		} else if a.Subtest != b.Subtest {
+			return a.Subtest < b.Subtest
+		}
+		return a.Platform < b.Platform
+	})
+}
+
+// computeTestMetadataVersion returns a hash of the metadata, which changes whenever any of it is modified, added or
+// removed, for the ETags of responses annotated with it.
+func computeTestMetadataVersion(metadata []TestMetadata) string {
+	hash := sha1.New()
+	for _, m := range metadata {
+		fmt.Fprintf(hash, "%d:%d;", m.ID, m.UpdatedAt.UnixNano())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// loadTestMetadata loads all of the TestMetadata entities, ordered by sortTestMetadata.
+func loadTestMetadata(ctx context.Context) ([]TestMetadata, error) {
+	var metadata []TestMetadata
+	keys, err := datastore.NewQuery("TestMetadata").Ancestor(testMetadataRootKey(ctx)).GetAll(ctx, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		metadata[i].ID = keys[i].IntID()
+	}
+	sortTestMetadata(metadata)
+	return metadata, nil
+}
+
+// putTestMetadata stores the metadata, as a new entity when it has no ID, returning it as stored.
+func putTestMetadata(ctx context.Context, metadata TestMetadata, now time.Time) (TestMetadata, error) {
+	metadata.UpdatedAt = now
+	if metadata.ID == 0 {
+		key, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, "TestMetadata", testMetadataRootKey(ctx)), &metadata)
+		if err != nil {
+			return metadata, err
+		}
+		metadata.ID = key.IntID()
+		return metadata, nil
+	}
+
+	key := testMetadataKey(ctx, metadata.ID)
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var existing TestMetadata
+		if err := datastore.Get(ctx, key, &existing); err == datastore.ErrNoSuchEntity {
+			return testMetadataNotFoundError(metadata.ID)
+		} else if err != nil {
+			return err
+		}
+		_, err := datastore.Put(ctx, key, &metadata)
+		return err
+	}, nil)
+	return metadata, err
+}
+
+// replaceTestMetadata replaces all of the TestMetadata entities with the given metadata (e.g. imported from a
+// file), returning it as stored. Re-imported entries keep their IDs (see matchTestMetadata). It's done in batches,
+// rather than in a single transaction, so that any amount of metadata can be imported; the imported metadata is
+// written before the rest is deleted, so a failed import never loses metadata, and should be retried.
+func replaceTestMetadata(ctx context.Context, metadata []TestMetadata, now time.Time) ([]TestMetadata, error) {
+	var existing []TestMetadata
+	existingKeys, err := datastore.NewQuery("TestMetadata").Ancestor(testMetadataRootKey(ctx)).GetAll(ctx, &existing)
+	if err != nil {
+		return nil, err
+	}
+	for i := range existingKeys {
+		existing[i].ID = existingKeys[i].IntID()
+	}
+	ids, removed := matchTestMetadata(existing, metadata)
+
+	stored := make([]TestMetadata, len(metadata))
+	for start := 0; start < len(metadata); start += maxDatastoreBatch {
+		end := start + maxDatastoreBatch
+		if end > len(metadata) {
+			end = len(metadata)
+		}
+		keys := make([]*datastore.Key, end-start)
+		for i := range keys {
+			if id := ids[start+i]; id != 0 {
+				keys[i] = testMetadataKey(ctx, id)
+			} else {
+				keys[i] = datastore.NewIncompleteKey(ctx, "TestMetadata", testMetadataRootKey(ctx))
+			}
+			stored[start+i] = metadata[start+i]
+			stored[start+i].ID = 0
+			stored[start+i].UpdatedAt = now
+		}
+		if keys, err = datastore.PutMulti(ctx, keys, stored[start:end]); err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			stored[start+i].ID = key.IntID()
+		}
+	}
+
+	for start := 0; start < len(removed); start += maxDatastoreBatch {
+		end := start + maxDatastoreBatch
+		if end > len(removed) {
+			end = len(removed)
+		}
+		keys := make([]*datastore.Key, end-start)
+		for i := range keys {
+			keys[i] = testMetadataKey(ctx, removed[start+i])
+		}
+		if err = datastore.DeleteMulti(ctx, keys); err != nil {
+			return nil, err
+		}
+	}
+	sortTestMetadata(stored)
+	return stored, nil
+}
+
+// matchTestMetadata matches the imported metadata with the existing metadata for the same test, subtest, platform
+// and bug, returning the ID of each imported entry's match (or 0, for a new entry), and the IDs of the existing
+// metadata which wasn't matched, i.e. which the import removes.
+func matchTestMetadata(existing []TestMetadata, imported []TestMetadata) (ids []int64, removed []int64) {
+	identity := func(m TestMetadata) string {
+		return strings.Join([]string{m.Test, m.Subtest, m.Platform, m.BugURL}, "\x00")
+	}
+	unmatched := make(map[string][]int64)
+	for _, m := range existing {
+		unmatched[identity(m)] = append(unmatched[identity(m)], m.ID)
+	}
+	ids = make([]int64, len(imported))
+	for i, m := range imported {
+		if matches := unmatched[identity(m)]; len(matches) > 0 {
+			ids[i] = matches[0]
+			unmatched[identity(m)] = matches[1:]
+		}
+	}
+	for _, m := range existing {
+		if matches := unmatched[identity(m)]; len(matches) > 0 {
+			removed = append(removed, matches...)
+			delete(unmatched, identity(m))
+		}
+	}
+	return ids, removed
+}
+
+// ParseTestMetadataYAML parses and validates metadata in the YAML format of exported metadata (see
+// MarshalTestMetadataYAML), i.e. a list of entries with test, and optionally subtest and platform, and bug_url and
+// optionally notes.
+func ParseTestMetadataYAML(r io.Reader) ([]TestMetadata, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	var metadata []TestMetadata
+	if err := decoder.Decode(&metadata); err != nil && err != io.EOF {
+		return nil, err
+	}
+	for i, m := range metadata {
+		if err := validateTestMetadata(m); err != nil {
+			return nil, fmt.Errorf("entry %d: %s", i+1, err.Error())
+		}
+	}
+	return metadata, nil
+}
+
+// MarshalTestMetadataYAML encodes the metadata as YAML, in the format read by ParseTestMetadataYAML.
+func MarshalTestMetadataYAML(metadata []TestMetadata) ([]byte, error) {
+	if metadata == nil {
+		metadata = []TestMetadata{}
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(metadata); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
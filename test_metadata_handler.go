@@ -0,0 +1,191 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// apiTestMetadataHandler emits the test metadata (see TestMetadata), which links failures to bug-tracker issues. It's
+// edited with apiAdminTestMetadataHandler.
+func apiTestMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "This endpoint only supports GET.", http.StatusMethodNotAllowed)
+		return
+	}
+	handleAPITestMetadataGet(appengine.NewContext(r), w, r)
+}
+
+// apiAdminTestMetadataHandler is responsible for editing the test metadata. All methods require an admin of the
+// app (see checkAdminRequest).
+//
+// POST adds metadata, from a JSON body in the format of the TestMetadata model.
+// PUT replaces the metadata with the given 'id' param, from a JSON body.
+// DELETE removes the metadata with the given 'id' param.
+func apiAdminTestMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkAdminRequest(ctx, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "POST", "PUT":
+		handleAPITestMetadataPut(ctx, w, r)
+	case "DELETE":
+		handleAPITestMetadataDelete(ctx, w, r)
+	default:
+		http.Error(w, "This endpoint only supports POST, PUT and DELETE.", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAPITestMetadataGet emits the metadata.
+//
+// URL Params:
+//     test: (optional) Path of a test; only emits the metadata which applies to it
+//     platform: (optional) Browser name; with test, only emits the metadata which applies to the test on it
+func handleAPITestMetadataGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	metadata, err := loadTestMetadata(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if test := r.URL.Query().Get("test"); test != "" {
+		platforms := []string{r.URL.Query().Get("platform")}
+		metadata = annotateTests(metadata, []string{test}, platforms)[test]
+	}
+	if metadata == nil {
+		metadata = []TestMetadata{}
+	}
+	writeTestMetadata(w, http.StatusOK, metadata)
+}
+
+func handleAPITestMetadataPut(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var metadata TestMetadata
+	if err = json.Unmarshal(body, &metadata); err != nil {
+		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := http.StatusCreated
+	metadata.ID = 0
+	if r.Method == "PUT" {
+		if metadata.ID, err = strconv.ParseInt(r.URL.Query().Get("id"), 10, 64); err != nil || metadata.ID <= 0 {
+			http.Error(w, "Invalid 'id' param", http.StatusBadRequest)
+			return
+		}
+		status = http.StatusOK
+	}
+	if err = validateTestMetadata(metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if metadata, err = putTestMetadata(ctx, metadata, time.Now()); err != nil {
+		if _, ok := err.(testMetadataNotFoundError); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	writeTestMetadata(w, status, metadata)
+}
+
+func handleAPITestMetadataDelete(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid 'id' param", http.StatusBadRequest)
+		return
+	}
+	if err = datastore.Delete(ctx, testMetadataKey(ctx, id)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiTestMetadataExportHandler emits all of the test metadata as a YAML file (see MarshalTestMetadataYAML), which
+// can be edited and imported with apiAdminTestMetadataImportHandler.
+func apiTestMetadataExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "This endpoint only supports GET.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := appengine.NewContext(r)
+	metadata, err := loadTestMetadata(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bytes, err := MarshalTestMetadataYAML(metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="test-metadata.yml"`)
+	w.Write(bytes)
+}
+
+// apiAdminTestMetadataImportHandler replaces all of the test metadata with that in the YAML body of a POST request
+// (see ParseTestMetadataYAML), and emits it. It requires an admin of the app (see checkAdminRequest).
+func apiAdminTestMetadataImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "This endpoint only supports POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := appengine.NewContext(r)
+	if !checkAdminRequest(ctx, w, r) {
+		return
+	}
+
+	imported, err := ParseTestMetadataYAML(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to parse YAML: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := replaceTestMetadata(ctx, imported, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if stored == nil {
+		stored = []TestMetadata{}
+	}
+	writeTestMetadata(w, http.StatusOK, stored)
+}
+
+// writeTestMetadata writes the metadata (a TestMetadata or a slice of them) to the response as JSON.
+func writeTestMetadata(w http.ResponseWriter, status int, metadata interface{}) {
+	bytes, err := json.Marshal(metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
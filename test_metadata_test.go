@@ -0,0 +1,157 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTestMetadata(t *testing.T) {
+	valid := TestMetadata{Test: "/dom/a.html", BugURL: "https://crbug.com/123"}
+	assert.Nil(t, validateTestMetadata(valid))
+
+	for _, m := range []TestMetadata{
+		{Test: "/dom/events/", BugURL: "https://crbug.com/123"},
+		{Test: "/", BugURL: "https://crbug.com/123"},
+		{Test: "/dom/a.html", Subtest: "First subtest", Platform: "chrome", BugURL: "http://bugzil.la/1"},
+	} {
+		assert.Nil(t, validateTestMetadata(m), m.Test)
+	}
+
+	for _, m := range []TestMetadata{
+		{Test: "dom/a.html", BugURL: "https://crbug.com/123"},
+		{Test: "/dom/../a.html", BugURL: "https://crbug.com/123"},
+		{Test: "/dom//events/", BugURL: "https://crbug.com/123"},
+		{Test: "/dom/", Subtest: "First subtest", BugURL: "https://crbug.com/123"},
+		{Test: "/dom/a.html", Platform: "netscape", BugURL: "https://crbug.com/123"},
+		{Test: "/dom/a.html", BugURL: "crbug.com/123"},
+		{Test: "/dom/a.html", BugURL: "javascript:alert(1)"},
+	} {
+		assert.NotNil(t, validateTestMetadata(m), m.Test)
+	}
+}
+
+func TestTestMetadata_AppliesTo(t *testing.T) {
+	test := TestMetadata{Test: "/dom/a.html"}
+	assert.True(t, test.appliesTo("/dom/a.html", "chrome"))
+	assert.False(t, test.appliesTo("/dom/a.html.ini", "chrome"))
+	assert.False(t, test.appliesTo("/dom/b.html", "chrome"))
+
+	dir := TestMetadata{Test: "/dom/", Platform: "firefox"}
+	assert.True(t, dir.appliesTo("/dom/events/a.html", "firefox"))
+	assert.True(t, dir.appliesTo("/dom/events/a.html", ""))
+	assert.False(t, dir.appliesTo("/dom/events/a.html", "chrome"))
+	assert.False(t, dir.appliesTo("/domparsing/a.html", "firefox"))
+}
+
+func TestAnnotateTests(t *testing.T) {
+	metadata := []TestMetadata{
+		{ID: 1, Test: "/dom/"},
+		{ID: 2, Test: "/dom/a.html", Platform: "chrome"},
+		{ID: 3, Test: "/dom/a.html", Platform: "safari"},
+	}
+	annotations := annotateTests(metadata, []string{"/dom/a.html", "/dom/b.html", "/html/c.html"},
+		[]string{"chrome", "firefox"})
+	assert.Len(t, annotations, 2)
+	assert.Equal(t, []TestMetadata{metadata[0], metadata[1]}, annotations["/dom/a.html"])
+	assert.Equal(t, []TestMetadata{metadata[0]}, annotations["/dom/b.html"])
+}
+
+func TestSortTestMetadata(t *testing.T) {
+	metadata := []TestMetadata{
+		{Test: "/dom/b.html"},
+		{Test: "/dom/a.html", Subtest: "2"},
+		{Test: "/dom/a.html", Subtest: "1", Platform: "safari"},
+		{Test: "/dom/a.html", Subtest: "1", Platform: "chrome"},
+	}
+	sortTestMetadata(metadata)
+	assert.Equal(t, "chrome", metadata[0].Platform)
+	assert.Equal(t, "safari", metadata[1].Platform)
+	assert.Equal(t, "2", metadata[2].Subtest)
+	assert.Equal(t, "/dom/b.html", metadata[3].Test)
+}
+
+func TestMatchTestMetadata(t *testing.T) {
+	existing := []TestMetadata{
+		{ID: 1, Test: "/dom/a.html", BugURL: "https://crbug.com/1", Notes: "old"},
+		{ID: 2, Test: "/dom/a.html", Platform: "chrome", BugURL: "https://crbug.com/1"},
+		{ID: 3, Test: "/css/", BugURL: "https://crbug.com/2"},
+		{ID: 4, Test: "/css/", BugURL: "https://crbug.com/2"},
+	}
+	imported := []TestMetadata{
+		{Test: "/css/", BugURL: "https://crbug.com/2"},
+		{Test: "/dom/a.html", BugURL: "https://crbug.com/1", Notes: "new"},
+		{Test: "/html/b.html", BugURL: "https://crbug.com/3"},
+	}
+	ids, removed := matchTestMetadata(existing, imported)
+	// Notes don't affect matching, and duplicates are matched one to one.
+	assert.Equal(t, []int64{3, 1, 0}, ids)
+	assert.Equal(t, []int64{2, 4}, removed)
+
+	ids, removed = matchTestMetadata(nil, imported)
+	assert.Equal(t, []int64{0, 0, 0}, ids)
+	assert.Empty(t, removed)
+}
+
+func TestComputeTestMetadataVersion(t *testing.T) {
+	now := time.Now()
+	metadata := []TestMetadata{{ID: 1, UpdatedAt: now}, {ID: 2, UpdatedAt: now}}
+	version := computeTestMetadataVersion(metadata)
+	assert.Equal(t, version, computeTestMetadataVersion(metadata))
+	assert.NotEqual(t, version, computeTestMetadataVersion(metadata[:1]))
+
+	metadata[1].UpdatedAt = now.Add(time.Second)
+	assert.NotEqual(t, version, computeTestMetadataVersion(metadata))
+}
+
+func TestTestMetadataYAML_RoundTrip(t *testing.T) {
+	metadata := []TestMetadata{
+		{ID: 1, Test: "/dom/", BugURL: "https://crbug.com/1", Notes: "Flaky on bots", UpdatedAt: time.Now()},
+		{Test: "/dom/a.html", Subtest: "First subtest", Platform: "firefox", BugURL: "https://bugzil.la/2"},
+	}
+	bytes, err := MarshalTestMetadataYAML(metadata)
+	assert.Nil(t, err)
+	assert.Equal(t, `- test: /dom/
+  bug_url: https://crbug.com/1
+  notes: Flaky on bots
+- test: /dom/a.html
+  subtest: First subtest
+  platform: firefox
+  bug_url: https://bugzil.la/2
+`, string(bytes))
+
+	parsed, err := ParseTestMetadataYAML(strings.NewReader(string(bytes)))
+	assert.Nil(t, err)
+	metadata[0].ID = 0
+	metadata[0].UpdatedAt = time.Time{}
+	assert.Equal(t, metadata, parsed)
+}
+
+func TestParseTestMetadataYAML(t *testing.T) {
+	parsed, err := ParseTestMetadataYAML(strings.NewReader(""))
+	assert.Nil(t, err)
+	assert.Len(t, parsed, 0)
+
+	_, err = ParseTestMetadataYAML(strings.NewReader("- test: /dom/\n  bug: https://crbug.com/1\n"))
+	assert.NotNil(t, err)
+
+	_, err = ParseTestMetadataYAML(strings.NewReader("- test: dom\n  bug_url: https://crbug.com/1\n"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "entry 1")
+}
@@ -39,7 +39,8 @@ func handleTestRunGet(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid max-count param: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	sourceURL := fmt.Sprintf(`/api/runs?max-count=%d`, maxCount)
+	// Include runs which are still in progress, or failed, so they can be monitored.
+	sourceURL := fmt.Sprintf(`/api/runs?max-count=%d&status=all`, maxCount)
 
 	// Serialize the data + pipe through the test-runs.html template.
 	testRunSourcesBytes, err := json.Marshal([]string{sourceURL})
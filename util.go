@@ -15,73 +15,109 @@
 package wptdashboard
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"sort"
-)
-
-var browsers map[string]Browser
+	"net/http"
+	"net/url"
+	"sync"
 
-// No 'set' type in Go, so use map instead.
-var browserNames map[string]bool
-var browserNamesAlphabetical []string
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/user"
+)
 
-// GetBrowsers loads, parses and returns the set of names of browsers
-// which are to be included (flagged as initially_loaded in the JSON).
+// GetBrowsers returns the registry of browsers (platforms), keyed by platform ID. It is seeded from
+// browsers.json, and reflects the Browser entities in the Datastore once those have been loaded
+// (see browserRegistry). The returned map must not be modified.
 func GetBrowsers() (map[string]Browser, error) {
-	if browsers != nil {
-		return browsers, nil
-	}
-	var bytes []byte
-	var err error
-	if bytes, err = ioutil.ReadFile("browsers.json"); err != nil {
+	snapshot, err := browsers.get()
+	if err != nil {
 		return nil, err
 	}
-
-	if err = json.Unmarshal(bytes, &browsers); err != nil {
-		return nil, err
-	}
-	return browsers, nil
+	return snapshot.browsers, nil
 }
 
 // GetBrowserNames returns an alphabetically-ordered array of the names
-// of the browsers returned by GetBrowsers.
+// of the browsers returned by GetBrowsers which are flagged as initially_loaded.
 func GetBrowserNames() ([]string, error) {
-	if browserNamesAlphabetical == nil {
-		if err := loadBrowserNames(); err != nil {
-			return nil, err
-		}
+	snapshot, err := browsers.get()
+	if err != nil {
+		return nil, err
 	}
-	return browserNamesAlphabetical, nil
+	return snapshot.namesAlphabetical, nil
 }
 
 // IsBrowserName determines whether the given name string is a valid browser name.
 // Used for validating user-input params for browsers.
 func IsBrowserName(name string) bool {
-	if browserNames == nil {
-		if err := loadBrowserNames(); err != nil {
-			return false
-		}
+	snapshot, err := browsers.get()
+	if err != nil {
+		return false
 	}
-	_, ok := browserNames[name]
+	_, ok := snapshot.names[name]
 	return ok
 }
 
-func loadBrowserNames() error {
-	var browsers map[string]Browser
-	var err error
-	if browsers, err = GetBrowsers(); err != nil {
+// runConcurrently calls f for each index in [0, n), with at most parallelism calls in flight at once.
+// Once any call fails, the context passed to the remaining calls is cancelled (and no new calls are started).
+// The first error encountered is returned.
+func runConcurrently(parent context.Context, n int, parallelism int, f func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	semaphore := make(chan struct{}, parallelism)
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := f(ctx, i); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
 		return err
 	}
-	browserNames = make(map[string]bool)
-	for _, browser := range browsers {
-		if browser.InitiallyLoaded {
-			browserNamesAlphabetical = append(browserNamesAlphabetical, browser.BrowserName)
-			browserNames[browser.BrowserName] = true
+	return parent.Err()
+}
+
+// checkCronRequest checks that the request was sent by the App Engine cron service. If not, an error response is
+// written and false is returned.
+func checkCronRequest(w http.ResponseWriter, r *http.Request) bool {
+	// App Engine strips this header from external requests, so its presence means the cron service sent the request.
+	if r.Header.Get("X-AppEngine-Cron") != "true" {
+		http.Error(w, "Cron jobs can only be run by the cron service", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// checkAdminRequest checks that the request was made by an admin of the app, which app.yaml requires of /api/admin/
+// URLs, and that it wasn't sent by another site's page on the admin's behalf. If not, an error response is written
+// and false is returned.
+func checkAdminRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	if !user.IsAdmin(ctx) {
+		http.Error(w, "Only admins of the app can use this endpoint", http.StatusForbidden)
+		return false
+	}
+	// Browsers send the Origin of cross-site requests, which could otherwise use the admin's login cookie.
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "Cross-origin requests are not allowed", http.StatusForbidden)
+			return false
 		}
 	}
-	sort.Strings(browserNamesAlphabetical)
-	return nil
+	return true
 }
 
 func abs(x int) int {
@@ -15,9 +15,14 @@
 package wptdashboard
 
 import (
-	"github.com/stretchr/testify/assert"
+	"errors"
 	"sort"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 )
 
 func TestGetBrowserNames(t *testing.T) {
@@ -39,3 +44,55 @@ func TestIsBrowserName_Names(t *testing.T) {
 		assert.True(t, IsBrowserName(name))
 	}
 }
+
+func TestRunConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	done := make([]bool, 10)
+	err := runConcurrently(context.Background(), len(done), 3, func(ctx context.Context, i int) error {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		done[i] = true
+		mu.Unlock()
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.True(t, maxInFlight <= 3)
+	for _, d := range done {
+		assert.True(t, d)
+	}
+}
+
+func TestRunConcurrently_Error(t *testing.T) {
+	failure := errors.New("failed")
+	err := runConcurrently(context.Background(), 100, 2, func(ctx context.Context, i int) error {
+		if i == 3 {
+			return failure
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Millisecond):
+		}
+		return nil
+	})
+	assert.Equal(t, failure, err)
+}
+
+func TestRunConcurrently_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := runConcurrently(ctx, 5, 1, func(ctx context.Context, i int) error {
+		calls++
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, calls)
+}
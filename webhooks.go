@@ -0,0 +1,357 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// WebhookEventRunUploaded is sent whenever a new TestRun is uploaded.
+const WebhookEventRunUploaded = "run-uploaded"
+
+// WebhookEventRevisionComplete is sent when an upload completes a revision, i.e. there is now a run at that
+// revision for every initially-loaded browser.
+const WebhookEventRevisionComplete = "revision-complete"
+
+// WebhookEventRunRegressed is sent when a new TestRun has fewer passing results than the previous run of the same
+// browser, for at least one test.
+const WebhookEventRunRegressed = "run-regressed"
+
+// WebhookEvents is the list of all supported webhook event types.
+var WebhookEvents = []string{
+	WebhookEventRunUploaded,
+	WebhookEventRevisionComplete,
+	WebhookEventRunRegressed,
+}
+
+// webhookSignatureHeader is the header carrying the HMAC-SHA256 of the request body, keyed by the hook's secret.
+const webhookSignatureHeader = "X-WPTD-Signature"
+
+// webhookEventHeader is the header carrying the event type of the notification.
+const webhookEventHeader = "X-WPTD-Event"
+
+// webhookMaxAttempts is the number of times delivery of a notification is attempted before giving up.
+var webhookMaxAttempts = 4
+
+// webhookInitialBackoff is the delay before the first retry; it doubles after each failed attempt.
+var webhookInitialBackoff = time.Second
+
+// WebhookPayload is the JSON body POSTed to registered webhooks.
+type WebhookPayload struct {
+	Event   string  `json:"event"`
+	TestRun TestRun `json:"test_run"`
+
+	// PreviousTestRun and Regressions are only set for run-regressed events. Regressions is a JSON object of test
+	// name to [count-newly-failing, total-tests], in the same style as /api/diff.
+	PreviousTestRun *TestRun        `json:"previous_test_run,omitempty"`
+	Regressions     *ResultsSummary `json:"regressions,omitempty"`
+
+	// Owners are the regressed tests, keyed by their owners (see getTestOwners), so that regressions can be
+	// routed to them. Tests without owners are omitted.
+	Owners map[string][]string `json:"owners,omitempty"`
+}
+
+// webhookDeliveryError is returned when a webhook endpoint responds with a non-2xx status.
+type webhookDeliveryError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e webhookDeliveryError) Error() string {
+	return fmt.Sprintf("%s returned HTTP status %d", e.URL, e.StatusCode)
+}
+
+// retryable is false for client errors, which won't be fixed by trying again (except 429 Too Many Requests).
+func (e webhookDeliveryError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsWebhookEvent determines whether the given string is a supported webhook event type.
+func IsWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (hook Webhook) subscribedTo(event string) bool {
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (hook Webhook) matchesBrowser(browserName string) bool {
+	if len(hook.Browsers) == 0 {
+		return true
+	}
+	for _, b := range hook.Browsers {
+		if b == browserName {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPaths returns the subset of the given results which fall under one of the hook's path prefixes.
+func (hook Webhook) filterPaths(results *ResultsSummary) *ResultsSummary {
+	if len(hook.Paths) == 0 {
+		return results
+	}
+	return results.Filter(func(test string) bool {
+		for _, prefix := range hook.Paths {
+			if strings.HasPrefix(test, prefix) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// signWebhookPayload returns the value of the signature header for the given body, in the form "sha256=<hex>".
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook POSTs the body to the hook's URL, retrying with exponential backoff on network errors and
+// server-side failures.
+func deliverWebhook(client *http.Client, hook Webhook, event string, body []byte) (err error) {
+	backoff := webhookInitialBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if err = postWebhook(client, hook, event, body); err == nil {
+			return nil
+		}
+		if deliveryErr, ok := err.(webhookDeliveryError); ok && !deliveryErr.retryable() {
+			return err
+		}
+		if attempt < webhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+func postWebhook(client *http.Client, hook Webhook, event string, body []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event)
+	req.Header.Set(webhookSignatureHeader, signWebhookPayload(hook.Secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webhookDeliveryError{hook.URL, resp.StatusCode}
+	}
+	return nil
+}
+
+// notifyWebhooks sends the notifications for a newly uploaded TestRun to all of the registered webhooks that are
+// subscribed to them. revisionCompleted is whether the run made its revision complete (see updateRevision).
+//
+// Each successful delivery is recorded (see WebhookDelivery), so that when the run's processing is retried, only the
+// notifications which failed are sent again. Failures which retrying can't fix (see webhookDeliveryError.retryable)
+// are only logged, and any others are returned, after every notification has been attempted.
+func notifyWebhooks(ctx context.Context, r *http.Request, run TestRun, revisionCompleted bool) error {
+	var hooks []Webhook
+	keys, err := datastore.NewQuery("Webhook").GetAll(ctx, &hooks)
+	if err != nil {
+		return err
+	}
+
+	subscribed := make(map[string]bool)
+	var matching []Webhook
+	for i, hook := range hooks {
+		hook.ID = keys[i].IntID()
+		if !hook.matchesBrowser(run.BrowserName) {
+			continue
+		}
+		matching = append(matching, hook)
+		for _, event := range hook.Events {
+			subscribed[event] = true
+		}
+	}
+	if len(matching) == 0 {
+		return nil
+	}
+
+	var previous TestRun
+	var regressions *ResultsSummary
+	var owners []DirectoryOwners
+	if subscribed[WebhookEventRunRegressed] {
+		if previous, regressions, err = getRegressionsSincePreviousRun(ctx, r, run); err != nil {
+			return err
+		}
+		// Owners are only a hint, so regressions are still notified without them.
+		if regressions.Len() > 0 {
+			if owners, err = loadDirectoryOwners(ctx); err != nil {
+				log.Warningf(ctx, "Failed to load owners: %s", err.Error())
+			}
+		}
+	}
+
+	type notification struct {
+		hook    Webhook
+		payload WebhookPayload
+	}
+	var notifications []notification
+	for _, hook := range matching {
+		if hook.subscribedTo(WebhookEventRunUploaded) {
+			notifications = append(notifications,
+				notification{hook, WebhookPayload{Event: WebhookEventRunUploaded, TestRun: run}})
+		}
+		if revisionCompleted && hook.subscribedTo(WebhookEventRevisionComplete) {
+			notifications = append(notifications,
+				notification{hook, WebhookPayload{Event: WebhookEventRevisionComplete, TestRun: run}})
+		}
+		if hook.subscribedTo(WebhookEventRunRegressed) {
+			if filtered := hook.filterPaths(regressions); filtered.Len() > 0 {
+				notifications = append(notifications, notification{hook, WebhookPayload{
+					Event:           WebhookEventRunRegressed,
+					TestRun:         run,
+					PreviousTestRun: &previous,
+					Regressions:     filtered,
+					Owners:          groupTestsByOwner(owners, filtered),
+				}})
+			}
+		}
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	deliveryKeys := make([]*datastore.Key, len(notifications))
+	for i, n := range notifications {
+		deliveryKeys[i] = webhookDeliveryKey(ctx, run.ID, n.hook.ID, n.payload.Event)
+	}
+	delivered, err := loadWebhookDeliveries(ctx, deliveryKeys)
+	if err != nil {
+		return err
+	}
+
+	client := urlfetch.Client(ctx)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	failed := 0
+	for i, n := range notifications {
+		if delivered[i] {
+			continue
+		}
+		body, err := json.Marshal(n.payload)
+		if err != nil {
+			log.Errorf(ctx, "Failed to marshal %s payload: %s", n.payload.Event, err.Error())
+			continue
+		}
+		wg.Add(1)
+		go func(key *datastore.Key, hook Webhook, event string) {
+			defer wg.Done()
+			if err := deliverWebhook(client, hook, event, body); err != nil {
+				log.Warningf(ctx, "Failed to deliver %s to %s: %s", event, hook.URL, err.Error())
+				if deliveryErr, ok := err.(webhookDeliveryError); !ok || deliveryErr.retryable() {
+					mutex.Lock()
+					failed++
+					mutex.Unlock()
+				}
+				return
+			}
+			if _, err := datastore.Put(ctx, key, &WebhookDelivery{DeliveredAt: time.Now()}); err != nil {
+				log.Warningf(ctx, "Failed to record delivery of %s to %s: %s", event, hook.URL, err.Error())
+			}
+		}(deliveryKeys[i], n.hook, n.payload.Event)
+	}
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("failed to deliver %d notifications of run %d", failed, run.ID)
+	}
+	return nil
+}
+
+// webhookDeliveryKey returns the key of the WebhookDelivery of the given event of a run to a webhook.
+func webhookDeliveryKey(ctx context.Context, runID int64, hookID int64, event string) *datastore.Key {
+	return datastore.NewKey(ctx, "WebhookDelivery", fmt.Sprintf("%d/%d/%s", runID, hookID, event), 0, nil)
+}
+
+// loadWebhookDeliveries determines which of the WebhookDeliveries with the given keys exist, i.e. which
+// notifications have already been delivered.
+func loadWebhookDeliveries(ctx context.Context, keys []*datastore.Key) ([]bool, error) {
+	delivered := make([]bool, len(keys))
+	deliveries := make([]WebhookDelivery, len(keys))
+	err := datastore.GetMulti(ctx, keys, deliveries)
+	if err == nil {
+		for i := range delivered {
+			delivered[i] = true
+		}
+		return delivered, nil
+	}
+	multiErr, ok := err.(appengine.MultiError)
+	if !ok {
+		return nil, err
+	}
+	for i, err := range multiErr {
+		if err == nil {
+			delivered[i] = true
+		} else if err != datastore.ErrNoSuchEntity {
+			return nil, err
+		}
+	}
+	return delivered, nil
+}
+
+// getRegressionsSincePreviousRun loads the run of the same browser which preceded the given run, and returns it
+// along with the regressions between the two (see RegressionsBetween).
+func getRegressionsSincePreviousRun(ctx context.Context, r *http.Request, run TestRun) (
+	previous TestRun, regressions *ResultsSummary, err error) {
+	var previousRun *TestRun
+	if previousRun, err = getPreviousRun(ctx, run); err != nil || previousRun == nil {
+		return previous, nil, err
+	}
+	previous = *previousRun
+
+	var before, after *ResultsSummary
+	if before, err = fetchRunResultsSummary(ctx, r, previous); err != nil {
+		return previous, nil, err
+	}
+	if after, err = fetchRunResultsSummary(ctx, r, run); err != nil {
+		return previous, nil, err
+	}
+	return previous, RegressionsBetween(before, after), nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// apiAdminWebhooksHandler is responsible for managing registered webhooks (see webhooks.go).
+// All methods require an admin of the app (see checkAdminRequest).
+//
+// GET lists the registered webhooks (without their secrets).
+// POST registers a new webhook, from a JSON body in the format of the Webhook model.
+// DELETE removes the webhook with the given 'id' param.
+func apiAdminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !checkAdminRequest(ctx, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		handleAPIWebhooksGet(ctx, w, r)
+	case "POST":
+		handleAPIWebhooksPost(ctx, w, r)
+	case "DELETE":
+		handleAPIWebhooksDelete(ctx, w, r)
+	default:
+		http.Error(w, "This endpoint only supports GET, POST and DELETE.", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleAPIWebhooksGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var hooks []Webhook
+	keys, err := datastore.NewQuery("Webhook").Order("CreatedAt").GetAll(ctx, &hooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range hooks {
+		hooks[i].ID = keys[i].IntID()
+		hooks[i].Secret = ""
+	}
+	if hooks == nil {
+		hooks = []Webhook{}
+	}
+
+	hooksBytes, err := json.Marshal(hooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(hooksBytes)
+}
+
+func handleAPIWebhooksPost(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var hook Webhook
+	if err = json.Unmarshal(body, &hook); err != nil {
+		http.Error(w, "Failed to parse JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateWebhook(hook); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook.CreatedAt = time.Now()
+
+	key := datastore.NewIncompleteKey(ctx, "Webhook", nil)
+	if key, err = datastore.Put(ctx, key, &hook); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hook.ID = key.IntID()
+	hook.Secret = ""
+
+	hookBytes, err := json.Marshal(hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(hookBytes)
+}
+
+func handleAPIWebhooksDelete(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid 'id' param", http.StatusBadRequest)
+		return
+	}
+	key := datastore.NewKey(ctx, "Webhook", "", id, nil)
+	if err = datastore.Delete(ctx, key); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateWebhook checks that a webhook submitted to the API has an absolute http(s) URL, a secret, and only
+// known event types and browser names.
+func validateWebhook(hook Webhook) error {
+	parsed, err := url.Parse(hook.URL)
+	if err != nil || !parsed.IsAbs() || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("invalid webhook url %s", hook.URL)
+	}
+	if hook.Secret == "" {
+		return fmt.Errorf("webhook secret missing")
+	}
+	if len(hook.Events) == 0 {
+		return fmt.Errorf("webhook events missing")
+	}
+	for _, event := range hook.Events {
+		if !IsWebhookEvent(event) {
+			return fmt.Errorf("invalid webhook event %s", event)
+		}
+	}
+	for _, browser := range hook.Browsers {
+		if !IsBrowserName(browser) {
+			return fmt.Errorf("invalid browser %s", browser)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	webhookInitialBackoff = time.Millisecond
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t,
+		"sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13",
+		signWebhookPayload("secret", []byte("{}")))
+	assert.NotEqual(t, signWebhookPayload("secret", []byte("{}")), signWebhookPayload("other", []byte("{}")))
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	body := []byte(`{"event":"run-uploaded"}`)
+	var received []byte
+	var signature, event string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(webhookSignatureHeader)
+		event = r.Header.Get(webhookEventHeader)
+	}))
+	defer server.Close()
+
+	hook := Webhook{URL: server.URL, Secret: "shh"}
+	assert.Nil(t, deliverWebhook(http.DefaultClient, hook, WebhookEventRunUploaded, body))
+	assert.Equal(t, body, received)
+	assert.Equal(t, signWebhookPayload("shh", body), signature)
+	assert.Equal(t, WebhookEventRunUploaded, event)
+}
+
+func TestDeliverWebhook_RetriesServerErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	hook := Webhook{URL: server.URL, Secret: "shh"}
+	assert.Nil(t, deliverWebhook(http.DefaultClient, hook, WebhookEventRunUploaded, []byte("{}")))
+	assert.Equal(t, 3, attempts)
+}
+
+func TestDeliverWebhook_GivesUp(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	hook := Webhook{URL: server.URL, Secret: "shh"}
+	err := deliverWebhook(http.DefaultClient, hook, WebhookEventRunUploaded, []byte("{}"))
+	assert.Equal(t, webhookDeliveryError{server.URL, http.StatusInternalServerError}, err)
+	assert.Equal(t, webhookMaxAttempts, attempts)
+}
+
+func TestDeliverWebhook_NoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	hook := Webhook{URL: server.URL, Secret: "shh"}
+	assert.NotNil(t, deliverWebhook(http.DefaultClient, hook, WebhookEventRunUploaded, []byte("{}")))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestWebhook_Filters(t *testing.T) {
+	hook := Webhook{
+		Events:   []string{WebhookEventRunRegressed},
+		Browsers: []string{"chrome"},
+		Paths:    []string{"/dom/"},
+	}
+	assert.True(t, hook.subscribedTo(WebhookEventRunRegressed))
+	assert.False(t, hook.subscribedTo(WebhookEventRunUploaded))
+	assert.True(t, hook.matchesBrowser("chrome"))
+	assert.False(t, hook.matchesBrowser("firefox"))
+	assert.True(t, Webhook{}.matchesBrowser("firefox"))
+
+	results := NewResultsSummary(map[string][]int{
+		"/dom/a.html": {1, 2},
+		"/css/b.html": {1, 2},
+	})
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}}, hook.filterPaths(results).ToMap())
+	assert.Equal(t, results, Webhook{}.filterPaths(results))
+}
+
+func TestWebhook_FilterPaths_FirstRun(t *testing.T) {
+	// A browser's first run has no previous run, so no regressions.
+	hook := Webhook{Events: []string{WebhookEventRunRegressed}, Paths: []string{"/dom/"}}
+	var regressions *ResultsSummary
+	assert.Equal(t, 0, hook.filterPaths(regressions).Len())
+	assert.Empty(t, groupTestsByOwner(nil, hook.filterPaths(regressions)))
+}
+
+func TestValidateWebhook(t *testing.T) {
+	valid := Webhook{
+		URL:    "https://example.com/hook",
+		Secret: "shh",
+		Events: []string{WebhookEventRunUploaded},
+	}
+	assert.Nil(t, validateWebhook(valid))
+
+	invalid := valid
+	invalid.URL = "ftp://example.com"
+	assert.NotNil(t, validateWebhook(invalid))
+
+	invalid = valid
+	invalid.Secret = ""
+	assert.NotNil(t, validateWebhook(invalid))
+
+	invalid = valid
+	invalid.Events = []string{"not-an-event"}
+	assert.NotNil(t, validateWebhook(invalid))
+
+	invalid = valid
+	invalid.Browsers = []string{"not-a-browser"}
+	assert.NotNil(t, validateWebhook(invalid))
+}
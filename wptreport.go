@@ -0,0 +1,120 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Formats of a results body (see ParseResults).
+const (
+	ResultsFormatSummary = "summary"
+	ResultsFormatReport  = "report"
+)
+
+// resultsSniffSize is the number of bytes of a results body examined to detect its format.
+const resultsSniffSize = 512
+
+// WPTReport is the JSON report written by `wpt run --log-wptreport`. Only the fields needed to summarize it are
+// decoded.
+type WPTReport struct {
+	Results []WPTReportResult `json:"results"`
+}
+
+// WPTReportResult is the result of a single test file in a WPTReport.
+type WPTReportResult struct {
+	Test     string             `json:"test"`
+	Status   string             `json:"status"`
+	Subtests []WPTReportSubtest `json:"subtests"`
+}
+
+// WPTReportSubtest is the result of a single subtest in a WPTReportResult.
+type WPTReportSubtest struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// Summary converts the report into a results summary, in the same way as report_to_summary in run/run.py: each test
+// file counts as one test (passing if its status is OK or PASS), plus one per subtest.
+func (report WPTReport) Summary() (*ResultsSummary, error) {
+	summary := &ResultsSummary{}
+	seen := make(map[string]bool, len(report.Results))
+	for _, result := range report.Results {
+		if seen[result.Test] {
+			return nil, fmt.Errorf("test %s appears more than once in the report", result.Test)
+		}
+		seen[result.Test] = true
+
+		counts := TestCounts{0, 1}
+		if result.Status == "OK" || result.Status == "PASS" {
+			counts[0] = 1
+		}
+		for _, subtest := range result.Subtests {
+			if subtest.Status == "PASS" {
+				counts[0]++
+			}
+			counts[1]++
+		}
+		summary.add(internPath(result.Test), counts)
+	}
+	summary.sort()
+	return summary, nil
+}
+
+// ParseResults decodes a results body in the given format: a results summary (see ParseResultsSummary), or a
+// wptreport, which is converted into a summary (see WPTReport.Summary). When format is empty, it's detected from the
+// body; the keys of a summary are test paths (starting with "/"), whereas a wptreport's aren't.
+func ParseResults(r io.Reader, format string) (*ResultsSummary, error) {
+	if format == "" {
+		buffered := bufio.NewReaderSize(r, resultsSniffSize)
+		peek, err := buffered.Peek(resultsSniffSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		format = sniffResultsFormat(peek)
+		r = buffered
+	}
+
+	switch format {
+	case ResultsFormatSummary:
+		return ParseResultsSummary(r)
+	case ResultsFormatReport:
+		var report WPTReport
+		if err := json.NewDecoder(r).Decode(&report); err != nil {
+			return nil, err
+		}
+		return report.Summary()
+	}
+	return nil, fmt.Errorf("invalid results format %s (must be %s or %s)", format, ResultsFormatSummary,
+		ResultsFormatReport)
+}
+
+// sniffResultsFormat returns the format of a results body, given (at least) its first key.
+func sniffResultsFormat(peek []byte) string {
+	peek = bytes.TrimLeft(peek, " \t\r\n")
+	if !bytes.HasPrefix(peek, []byte("{")) {
+		// Not an object at all; let the summary parser report the error.
+		return ResultsFormatSummary
+	}
+	peek = bytes.TrimLeft(peek[1:], " \t\r\n")
+	if len(peek) == 0 || peek[0] == '}' || bytes.HasPrefix(peek, []byte(`"/`)) {
+		return ResultsFormatSummary
+	}
+	return ResultsFormatReport
+}
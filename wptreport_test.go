@@ -0,0 +1,81 @@
+// Copyright 2018 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wptdashboard
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testReport = `{
+	"run_info": {"product": "chrome"},
+	"results": [
+		{"test": "/dom/b.html", "status": "OK", "subtests": [
+			{"name": "first", "status": "PASS"},
+			{"name": "second", "status": "FAIL"}
+		]},
+		{"test": "/dom/a.html", "status": "TIMEOUT", "subtests": []},
+		{"test": "/dom/c.html", "status": "PASS", "subtests": []}
+	]
+}`
+
+func TestWPTReport_Summary(t *testing.T) {
+	summary, err := ParseResults(strings.NewReader(testReport), ResultsFormatReport)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{
+		"/dom/a.html": {0, 1},
+		"/dom/b.html": {2, 3},
+		"/dom/c.html": {1, 1},
+	}, summary.ToMap())
+	assert.Equal(t, 3, summary.Len())
+	test, _ := summary.At(0)
+	assert.Equal(t, "/dom/a.html", test)
+}
+
+func TestWPTReport_SummaryDuplicate(t *testing.T) {
+	report := WPTReport{Results: []WPTReportResult{{Test: "/a.html"}, {Test: "/a.html"}}}
+	_, err := report.Summary()
+	assert.NotNil(t, err)
+}
+
+func TestParseResults_DetectsFormat(t *testing.T) {
+	summary, err := ParseResults(strings.NewReader(testReport), "")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, summary.Len())
+
+	summary, err = ParseResults(strings.NewReader(` { "/dom/a.html": [1, 2]}`), "")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int{"/dom/a.html": {1, 2}}, summary.ToMap())
+
+	summary, err = ParseResults(strings.NewReader(`{}`), "")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, summary.Len())
+
+	_, err = ParseResults(strings.NewReader(`[]`), "")
+	assert.NotNil(t, err)
+
+	_, err = ParseResults(strings.NewReader(`{}`), "yaml")
+	assert.NotNil(t, err)
+}
+
+func TestSniffResultsFormat(t *testing.T) {
+	assert.Equal(t, ResultsFormatSummary, sniffResultsFormat([]byte(`{"/a.html": [1, 1]}`)))
+	assert.Equal(t, ResultsFormatSummary, sniffResultsFormat([]byte("\n{\n  }")))
+	assert.Equal(t, ResultsFormatSummary, sniffResultsFormat([]byte("")))
+	assert.Equal(t, ResultsFormatReport, sniffResultsFormat([]byte(`{"results": [`)))
+	assert.Equal(t, ResultsFormatReport, sniffResultsFormat([]byte("{\n  \"time_start\": 1")))
+}